@@ -0,0 +1,330 @@
+package api
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go-chess/dao/redis"
+	"go-chess/engine"
+	"go-chess/global"
+	"go-chess/util"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	botPrefix    = "bot-" //AI的uuid前缀，后面是难度
+	botQueueSize = 64
+)
+
+// botLevel 各难度的最大搜索深度、每步最长思考时间和随机分值
+type botLevel struct {
+	depth  int
+	think  time.Duration
+	random int32
+}
+
+var botLevels = map[int]botLevel{
+	1: {1, 300 * time.Millisecond, 63},
+	2: {2, 500 * time.Millisecond, 31},
+	3: {4, time.Second, 15},
+	4: {6, 2 * time.Second, 7},
+	5: {engine.LimitDepth, 5 * time.Second, 3},
+}
+
+// botJob 交给worker搜索的局面，worker按开局设置和着法重建一份对局，不和房间共用
+type botJob struct {
+	g        *roomGame
+	turn     int
+	ply      int
+	variant  int
+	handicap int
+	removed  []string
+	seed     int64
+	moves    []string
+	level    botLevel
+	deadline time.Time
+}
+
+// botMove 搜索结果，期间悔过棋或又提交过新的搜索时结果作废
+type botMove struct {
+	g    *roomGame
+	turn int
+	ply  int
+	move string
+}
+
+// botJobs 等待搜索的局面，由固定数量的worker处理，AI对局再多也不会拖慢房间
+var botJobs = make(chan botJob, botQueueSize)
+
+// botOverflow 队列已满时每个房间只保留最新的一个局面，新的替换旧的，worker空出来后优先处理
+var (
+	botMu       sync.Mutex
+	botOverflow = make(map[string]botJob)
+)
+
+func isBot(uuid string) bool {
+	return strings.HasPrefix(uuid, botPrefix)
+}
+
+func botName(level int) string {
+	return fmt.Sprintf("AI%d级", level)
+}
+
+// displayName 对局记录中的用户名，AI不在用户表中
+func displayName(names map[string]string, uuid string) string {
+	if name, ok := names[uuid]; ok || !isBot(uuid) {
+		return name
+	}
+	level, _ := strconv.Atoi(strings.TrimPrefix(uuid, botPrefix))
+	return botName(level)
+}
+
+// hasBot 房间里是否有AI占座
+func hasBot(roomId string) bool {
+	uuids, err := redis.RoomMembers(roomId)
+	if err != nil {
+		return false
+	}
+	for _, uuid := range uuids {
+		if isBot(uuid) {
+			return true
+		}
+	}
+	return false
+}
+
+// newBotConn AI在房间里的连接，没有WebSocket，发给它的消息直接丢弃
+func newBotConn(level int) *connection {
+	c := &connection{
+		send: make(chan []byte, 256),
+		name: botName(level),
+		uuid: botPrefix + strconv.Itoa(level),
+		bot:  level,
+	}
+	go func() {
+		for range c.send {
+		}
+	}()
+	return c
+}
+
+// addBot 让AI坐到房间的空座位上，AI加入后自动准备
+func addBot(ctx *gin.Context) {
+	Iuuid, _ := ctx.Get("uuid")
+	uuid := Iuuid.(string)
+	roomId := ctx.Param("room_id")
+	level, err := strconv.Atoi(ctx.DefaultPostForm("level", "3"))
+	if _, ok := botLevels[level]; err != nil || !ok {
+		util.RespErrorWithData(ctx, 400, "bot error", "level must be between 1 and 5")
+		return
+	}
+
+	flag, err := redis.IsInRoom(roomId, uuid)
+	if err != nil {
+		log.Println(err)
+		util.RespError(ctx, 400, "judge in the room err")
+		return
+	}
+	if !flag {
+		util.RespErrorWithData(ctx, 400, "bot error", "you are not in the room")
+		return
+	}
+	num, err := redis.RoomNum(roomId)
+	if err != nil {
+		util.RespError(ctx, 400, "room num err")
+		return
+	}
+	if num >= 2 {
+		util.RespErrorWithData(ctx, 400, "bot error", "the room is full")
+		return
+	}
+	variant, err := redis.GetVariant(roomId)
+	if err != nil {
+		util.RespError(ctx, 400, "get variant error")
+		return
+	}
+	if variant == engine.VariantJieqi {
+		//揭棋的搜索会看到暗子
+		util.RespErrorWithData(ctx, 400, "bot error", "AI cannot play jieqi")
+		return
+	}
+
+	botUuid := botPrefix + strconv.Itoa(level)
+	err = redis.AddRoom(roomId, botUuid)
+	if err != nil {
+		util.RespError(ctx, 400, "add bot error")
+		return
+	}
+	err, key := redis.ReadySet(roomId, botUuid)
+	if err != nil || key != 1 {
+		util.RespError(ctx, 400, "bot ready error")
+		return
+	}
+	registerBot(roomId, level)
+	notifyReady(roomId, botUuid, botName(level), true)
+	util.RespSuccessful(ctx, "add bot successful")
+}
+
+// botLoop 启动固定数量的worker
+func botLoop() {
+	workers := global.Settings.BotInfo.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		go botWorker()
+	}
+}
+
+func botWorker() {
+	for job := range botJobs {
+		job.run()
+		for {
+			job, ok := overflowJob()
+			if !ok {
+				break
+			}
+			job.run()
+		}
+	}
+}
+
+// overflowJob 取出一个积压的局面
+func overflowJob() (botJob, bool) {
+	botMu.Lock()
+	defer botMu.Unlock()
+	for roomId, job := range botOverflow {
+		delete(botOverflow, roomId)
+		return job, true
+	}
+	return botJob{}, false
+}
+
+// run 搜索并把着法交回房间
+func (j botJob) run() {
+	m := botMove{j.g, j.turn, j.ply, j.search()}
+	hubs.post(j.g.roomId, false, func(h *roomHub) {
+		h.playBotMove(m)
+	})
+}
+
+func (j botJob) search() string {
+	game, err := engine.NewGame(j.variant, j.handicap, j.removed, j.seed)
+	if err != nil {
+		log.Println("bot new game err:", err)
+		return ""
+	}
+	for _, mv := range j.moves {
+		if !game.PlayMove(mv) {
+			log.Println("bot replay move", mv, "failed")
+			return ""
+		}
+	}
+	s, ok := game.(engine.Searcher)
+	if !ok {
+		return ""
+	}
+	return s.SearchMove(j.level.depth, j.level.random, j.deadline)
+}
+
+// botTurn 轮到AI走棋时把局面交给worker，思考时间不超过剩余时间的十分之一
+func (h *roomHub) botTurn(g *roomGame) {
+	c := g.players[g.game.Side()]
+	if c == nil || c.bot == 0 || h.game != g {
+		return
+	}
+	level := botLevels[c.bot]
+	now := time.Now()
+	think := g.clock.left(now) / 10
+	if think > level.think {
+		think = level.think
+	}
+	g.botTurns++
+	job := botJob{
+		g:        g,
+		turn:     g.botTurns,
+		ply:      len(g.moves),
+		variant:  g.variant,
+		handicap: g.handicap,
+		removed:  g.removed,
+		seed:     g.seed,
+		moves:    append([]string(nil), g.moves...),
+		level:    level,
+		deadline: now.Add(think),
+	}
+	select {
+	case botJobs <- job:
+	default:
+		//队列已满时放进这个房间的积压位置，替换掉已经过期的局面；排队超过思考时间的局面会立即返回
+		botMu.Lock()
+		botOverflow[g.roomId] = job
+		botMu.Unlock()
+	}
+}
+
+// playBotMove 执行AI的着法，对局已结束或悔过棋时丢弃
+func (h *roomHub) playBotMove(m botMove) {
+	g := m.g
+	if h.game != g || g.botTurns != m.turn || len(g.moves) != m.ply {
+		return
+	}
+	c := g.players[g.game.Side()]
+	if c == nil || c.bot == 0 {
+		return
+	}
+	if m.move == "" {
+		log.Println("bot found no move in room", g.roomId)
+		return
+	}
+	h.playMove(request{message: message{roomId: g.roomId, name: c.name, conn: c}, typ: TypeMove, move: m.move})
+}
+
+// botReply AI立即答复对方的请求
+func (h *roomHub) botReply(g *roomGame, sd int, typ string, accept bool) {
+	c := g.players[sd]
+	if c == nil || c.bot == 0 {
+		return
+	}
+	h.handle(request{message: message{roomId: g.roomId, name: c.name, conn: c}, typ: typ, accept: accept})
+}
+
+// readyBots 对局结束后AI重新准备，真人准备后即可再开一局
+func (h *roomHub) readyBots() {
+	roomId := h.id
+	for con := range h.conns {
+		if con.bot == 0 {
+			continue
+		}
+		uuid := con.uuid
+		writeRoom(roomId, func() {
+			err, _ := redis.ReadySet(roomId, uuid)
+			if err != nil {
+				log.Println(err)
+			}
+		})
+		h.sendRoom(h.frame(TypeReady, readyPayload{con.name, true}))
+	}
+}
+
+// dropBots 房间里没有真人选手、也没有进行中的对局时AI离开
+func (h *roomHub) dropBots() {
+	if h.game != nil {
+		return
+	}
+	for con := range h.conns {
+		if !con.spectator && con.bot == 0 {
+			return
+		}
+	}
+	for con := range h.conns {
+		if con.bot == 0 {
+			continue
+		}
+		delete(h.conns, con)
+		close(con.send)
+		h.leaveSeat(con.uuid)
+	}
+}
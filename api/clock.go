@@ -0,0 +1,156 @@
+package api
+
+import (
+	"errors"
+	"go-chess/model"
+	"time"
+)
+
+// gameClock 服务端计时，按座位而不是连接计时，玩家断线重连后继续使用
+type gameClock struct {
+	tc       model.TimeControl
+	remain   [2]time.Duration //剩余基本时间，进入读秒后为本次读秒的剩余时间
+	periods  [2]int           //剩余读秒次数
+	overtime [2]bool          //是否已用完基本时间
+	side     int              //正在计时的一方
+	since    time.Time        //本步开始计时的时刻
+}
+
+// clockPayload 双方剩余时间(毫秒)和读秒次数，下标0为红方
+type clockPayload struct {
+	Remain  [2]int64 `json:"remain"`
+	Periods [2]int   `json:"periods"`
+}
+
+// checkTimeControl 校验房间的用时设置
+func checkTimeControl(tc model.TimeControl) error {
+	if tc.Base <= 0 || tc.Base > 3*3600 {
+		return errors.New("base must be between 1 and 10800 seconds")
+	}
+	if tc.Increment < 0 || tc.Increment > 600 || tc.Periods < 0 || tc.Periods > 10 {
+		return errors.New("increment or periods out of range")
+	}
+	switch tc.Mode {
+	case model.ClockSudden, model.ClockFischer:
+		return nil
+	case model.ClockJiamiao:
+		if tc.Increment == 0 {
+			return errors.New("jiamiao needs increment")
+		}
+		return nil
+	case model.ClockByoyomi:
+		if tc.Increment == 0 || tc.Periods == 0 {
+			return errors.New("byoyomi needs increment and periods")
+		}
+		return nil
+	}
+	return errors.New("unknown clock mode")
+}
+
+// newClock 开局时红方开始计时
+func newClock(tc model.TimeControl, now time.Time) *gameClock {
+	c := &gameClock{tc: tc, since: now}
+	for sd := 0; sd < 2; sd++ {
+		c.remain[sd] = time.Duration(tc.Base) * time.Second
+		c.periods[sd] = tc.Periods
+		//加秒可以看作只有一次、每步重置的读秒
+		if tc.Mode == model.ClockJiamiao {
+			c.periods[sd] = 1
+		}
+	}
+	return c
+}
+
+func (c *gameClock) period() time.Duration {
+	return time.Duration(c.tc.Increment) * time.Second
+}
+
+// spend 扣除一方的用时，超时返回true
+func (c *gameClock) spend(sd int, elapsed time.Duration) bool {
+	switch c.tc.Mode {
+	case model.ClockJiamiao, model.ClockByoyomi:
+		if !c.overtime[sd] {
+			if elapsed <= c.remain[sd] {
+				c.remain[sd] -= elapsed
+				return false
+			}
+			elapsed -= c.remain[sd]
+			c.overtime[sd] = true
+			c.remain[sd] = c.period()
+		}
+		//超过一次读秒就用掉一次
+		for elapsed > c.remain[sd] {
+			elapsed -= c.remain[sd]
+			c.periods[sd]--
+			if c.periods[sd] <= 0 {
+				c.periods[sd] = 0
+				c.remain[sd] = 0
+				return true
+			}
+			c.remain[sd] = c.period()
+		}
+		c.remain[sd] -= elapsed
+		return false
+	}
+	c.remain[sd] -= elapsed
+	if c.remain[sd] < 0 {
+		c.remain[sd] = 0
+		return true
+	}
+	return false
+}
+
+// press 走棋方按钟，超时返回true，否则加秒并开始给对方计时
+func (c *gameClock) press(now time.Time) bool {
+	sd := c.side
+	if c.spend(sd, now.Sub(c.since)) {
+		return true
+	}
+	switch {
+	case c.tc.Mode == model.ClockFischer:
+		c.remain[sd] += c.period()
+	case c.overtime[sd]:
+		//读秒内走棋，本次读秒不扣次数并重新计时
+		c.remain[sd] = c.period()
+	}
+	c.side = 1 - sd
+	c.since = now
+	return false
+}
+
+// rewind 悔棋后改由sd方计时，原来计时的一方本步已用的时间照常扣除
+func (c *gameClock) rewind(sd int, now time.Time) {
+	c.spend(c.side, now.Sub(c.since))
+	if c.overtime[c.side] {
+		c.remain[c.side] = c.period()
+	}
+	c.side = sd
+	c.since = now
+}
+
+// left 正在计时的一方还有多久超时
+func (c *gameClock) left(now time.Time) time.Duration {
+	sd := c.side
+	total := c.remain[sd]
+	switch c.tc.Mode {
+	case model.ClockJiamiao, model.ClockByoyomi:
+		if c.overtime[sd] {
+			total += time.Duration(c.periods[sd]-1) * c.period()
+		} else {
+			total += time.Duration(c.periods[sd]) * c.period()
+		}
+	}
+	return total - now.Sub(c.since)
+}
+
+// snapshot 当前时刻双方的剩余时间
+func (c *gameClock) snapshot(now time.Time) clockPayload {
+	cur := *c
+	cur.spend(cur.side, now.Sub(cur.since))
+	var p clockPayload
+	for sd := 0; sd < 2; sd++ {
+		p.Remain[sd] = cur.remain[sd].Milliseconds()
+		p.Periods[sd] = cur.periods[sd]
+	}
+	return p
+}
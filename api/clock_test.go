@@ -0,0 +1,143 @@
+package api
+
+import (
+	"go-chess/model"
+	"testing"
+	"time"
+)
+
+func TestGameClock(t *testing.T) {
+	type step struct {
+		elapsed time.Duration
+		rewind  int //-1为按钟，否则悔棋后改由该方计时
+		timeout bool
+	}
+	press := func(elapsed time.Duration) step { return step{elapsed, -1, false} }
+	timeout := func(elapsed time.Duration) step { return step{elapsed, -1, true} }
+	rewind := func(elapsed time.Duration, sd int) step { return step{elapsed, sd, false} }
+	tests := []struct {
+		name    string
+		tc      model.TimeControl
+		steps   []step
+		wait    time.Duration //最后一步之后再过多久取快照
+		remain  [2]int64
+		periods [2]int
+		left    time.Duration
+	}{
+		{
+			name:   "sudden",
+			tc:     model.TimeControl{Mode: model.ClockSudden, Base: 60},
+			steps:  []step{press(10 * time.Second), press(20 * time.Second)},
+			remain: [2]int64{50000, 40000},
+			left:   50 * time.Second,
+		},
+		{
+			name:   "sudden timeout",
+			tc:     model.TimeControl{Mode: model.ClockSudden, Base: 60},
+			steps:  []step{timeout(61 * time.Second)},
+			remain: [2]int64{0, 60000},
+		},
+		{
+			name:   "fischer adds increment",
+			tc:     model.TimeControl{Mode: model.ClockFischer, Base: 60, Increment: 5},
+			steps:  []step{press(10 * time.Second), press(20 * time.Second)},
+			wait:   time.Second,
+			remain: [2]int64{54000, 45000},
+			left:   54 * time.Second,
+		},
+		{
+			name:    "jiamiao resets the move limit",
+			tc:      model.TimeControl{Mode: model.ClockJiamiao, Base: 10, Increment: 5},
+			steps:   []step{press(12 * time.Second), press(3 * time.Second)},
+			remain:  [2]int64{5000, 7000},
+			periods: [2]int{1, 1},
+			left:    5 * time.Second,
+		},
+		{
+			name:    "jiamiao timeout",
+			tc:      model.TimeControl{Mode: model.ClockJiamiao, Base: 10, Increment: 5},
+			steps:   []step{timeout(16 * time.Second)},
+			remain:  [2]int64{0, 10000},
+			periods: [2]int{0, 1},
+		},
+		{
+			name:    "byoyomi uses up periods",
+			tc:      model.TimeControl{Mode: model.ClockByoyomi, Base: 10, Increment: 5, Periods: 3},
+			steps:   []step{press(17 * time.Second), press(time.Second)},
+			remain:  [2]int64{5000, 9000},
+			periods: [2]int{2, 3},
+			left:    10 * time.Second,
+		},
+		{
+			name:    "byoyomi timeout",
+			tc:      model.TimeControl{Mode: model.ClockByoyomi, Base: 10, Increment: 5, Periods: 2},
+			steps:   []step{timeout(21 * time.Second)},
+			remain:  [2]int64{0, 10000},
+			periods: [2]int{0, 2},
+		},
+		{
+			name:   "rewind charges the side on move",
+			tc:     model.TimeControl{Mode: model.ClockFischer, Base: 60, Increment: 5},
+			steps:  []step{press(10 * time.Second), press(20 * time.Second), rewind(5*time.Second, 1)},
+			wait:   3 * time.Second,
+			remain: [2]int64{50000, 42000},
+			left:   42 * time.Second,
+		},
+		{
+			name:    "rewind in byoyomi resets the period",
+			tc:      model.TimeControl{Mode: model.ClockByoyomi, Base: 10, Increment: 5, Periods: 3},
+			steps:   []step{press(12 * time.Second), press(time.Second), rewind(4*time.Second, 1)},
+			remain:  [2]int64{5000, 9000},
+			periods: [2]int{3, 3},
+			left:    24 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Unix(1_000_000, 0)
+			c := newClock(tt.tc, now)
+			for i, s := range tt.steps {
+				now = now.Add(s.elapsed)
+				if s.rewind >= 0 {
+					c.rewind(s.rewind, now)
+					continue
+				}
+				if got := c.press(now); got != s.timeout {
+					t.Fatalf("step %d press timeout = %v, want %v", i, got, s.timeout)
+				}
+			}
+			now = now.Add(tt.wait)
+			p := c.snapshot(now)
+			if p.Remain != tt.remain || p.Periods != tt.periods {
+				t.Errorf("snapshot = %v %v, want %v %v", p.Remain, p.Periods, tt.remain, tt.periods)
+			}
+			if tt.left != 0 {
+				if got := c.left(now); got != tt.left {
+					t.Errorf("left = %v, want %v", got, tt.left)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckTimeControl(t *testing.T) {
+	tests := []struct {
+		tc      model.TimeControl
+		wantErr bool
+	}{
+		{model.TimeControl{Mode: model.ClockSudden, Base: 600}, false},
+		{model.TimeControl{Mode: model.ClockFischer, Base: 300, Increment: 3}, false},
+		{model.TimeControl{Mode: model.ClockJiamiao, Base: 600, Increment: 30}, false},
+		{model.TimeControl{Mode: model.ClockJiamiao, Base: 600}, true},
+		{model.TimeControl{Mode: model.ClockByoyomi, Base: 600, Increment: 30, Periods: 3}, false},
+		{model.TimeControl{Mode: model.ClockByoyomi, Base: 600, Increment: 30}, true},
+		{model.TimeControl{Mode: model.ClockSudden, Base: 0}, true},
+		{model.TimeControl{Mode: model.ClockFischer, Base: 300, Increment: -1}, true},
+		{model.TimeControl{Mode: "hourglass", Base: 300}, true},
+	}
+	for _, tt := range tests {
+		if err := checkTimeControl(tt.tc); (err != nil) != tt.wantErr {
+			t.Errorf("checkTimeControl(%+v) err = %v, wantErr %v", tt.tc, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,460 @@
+package api
+
+import (
+	"fmt"
+	"go-chess/dao/redis"
+	"go-chess/engine"
+	"go-chess/global"
+	"go-chess/model"
+	"log"
+	"math/rand"
+	"time"
+)
+
+// roomGame 房间内由服务端裁判的一局棋，客户端只负责显示和提交着法
+type roomGame struct {
+	roomId  string
+	game    engine.Game
+	players [2]*connection //0=红方，1=黑方，断线时为nil
+	uuids   [2]string
+	names   [2]string
+	moves   []string //着法记录
+	clock   *gameClock
+	timer   *time.Timer    //走棋方超时的定时器
+	graces  [2]*time.Timer //断线玩家的重连期限
+	tc      model.TimeControl
+
+	//开局设置，悔棋时按它重新开局再走到指定步数
+	variant  int
+	handicap int
+	removed  []string
+	seed     int64
+
+	drawOffer      int           //提和的一方，-1表示没有
+	takebackOffer  int           //请求悔棋的一方，-1表示没有
+	drawLimits     [2]offerLimit //双方提和的次数限制
+	takebackLimits [2]offerLimit //双方悔棋的次数限制
+
+	record *model.Game //对局的数据库记录
+
+	rated   bool            //是否计算等级分
+	ratings [2]model.Rating //开局时双方的等级分
+
+	botTurns int //交给AI搜索的次数，用来丢弃过期的搜索结果
+}
+
+// readyEvent 选手切换了准备状态，readyUuids为切换后所有已准备的用户
+type readyEvent struct {
+	roomId     string
+	uuid       string
+	name       string
+	ready      bool
+	readyUuids []string
+}
+
+// notifyReady 把准备状态的变化交给房间广播，双方都准备后开局
+func notifyReady(roomId, uuid, name string, ready bool) {
+	uuids, err := redis.ReadyMembers(roomId)
+	if err != nil {
+		log.Println(err)
+	}
+	sendReady(readyEvent{roomId, uuid, name, ready, uuids})
+}
+
+// seat 用户在对局中的座位，不是对局双方时返回-1
+func (g *roomGame) seat(uuid string) int {
+	for sd := range g.uuids {
+		if g.uuids[sd] == uuid {
+			return sd
+		}
+	}
+	return -1
+}
+
+// side 连接执哪一方，不是对局双方时返回-1
+func (g *roomGame) side(c *connection) int {
+	for sd, player := range g.players {
+		if player == c {
+			return sd
+		}
+	}
+	return -1
+}
+
+// setReady 广播准备状态，房间里两名选手都准备后自动开局
+func (h *roomHub) setReady(e readyEvent) {
+	h.ready = e.readyUuids
+	h.sendRoom(h.frame(TypeReady, readyPayload{e.name, e.ready}))
+	h.tryStart()
+}
+
+// isReady AI总是准备好的
+func (h *roomHub) isReady(c *connection) bool {
+	return c.bot != 0 || containsString(h.ready, c.uuid)
+}
+
+// unready 用户离开座位后不再算作准备
+func (h *roomHub) unready(uuid string) {
+	ready := h.ready[:0:0]
+	for _, v := range h.ready {
+		if v != uuid {
+			ready = append(ready, v)
+		}
+	}
+	h.ready = ready
+}
+
+// tryStart 双方都准备后在goroutine里读取开局设置，读完再交回房间开局，读redis和MySQL时房间照常处理消息
+func (h *roomHub) tryStart() {
+	if h.game != nil || h.loading {
+		return
+	}
+	players := h.players()
+	if len(players) != 2 {
+		return
+	}
+	for _, con := range players {
+		if !h.isReady(con) {
+			return
+		}
+	}
+	red, black := h.assignColors(players[0], players[1])
+	h.loading = true
+	roomId, uuids := h.id, [2]string{red.uuid, black.uuid}
+	go func() {
+		s := loadSettings(roomId, uuids)
+		hubs.post(roomId, false, func(h *roomHub) {
+			h.loading = false
+			h.startGame(red, black, s)
+		})
+	}()
+}
+
+// assignColors 房间第一局随机分配红黑，之后每局交换
+func (h *roomHub) assignColors(a, b *connection) (*connection, *connection) {
+	switch h.lastRed {
+	case a.uuid:
+		return b, a
+	case b.uuid:
+		return a, b
+	}
+	if rand.Intn(2) == 0 {
+		return a, b
+	}
+	return b, a
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// gameSettings 开局前读取的房间设置和双方等级分
+type gameSettings struct {
+	variant  int
+	handicap int
+	removed  string
+	tc       model.TimeControl
+	rated    bool
+	ratings  [2]model.Rating
+}
+
+// loadSettings 读取房间的变体、让子、用时和双方等级分，出错时按默认设置
+func loadSettings(roomId string, uuids [2]string) gameSettings {
+	var s gameSettings
+	var err error
+	s.variant, err = redis.GetVariant(roomId)
+	if err != nil {
+		log.Println(err)
+	}
+	s.handicap, s.removed, err = redis.GetHandicap(roomId)
+	if err != nil {
+		log.Println(err)
+	}
+	s.tc, err = redis.GetClock(roomId)
+	if err != nil {
+		log.Println(err)
+	}
+	loadRatings(roomId, uuids, &s)
+	return s
+}
+
+// startGame 开局设置读完后开局，读取期间有人离开或取消准备就不开
+func (h *roomHub) startGame(red, black *connection, s gameSettings) {
+	if h.game != nil || !h.conns[red] || !h.conns[black] || !h.isReady(red) || !h.isReady(black) {
+		return
+	}
+	variant, handicap, removed := s.variant, s.handicap, s.removed
+	seed := time.Now().UnixNano()
+	game, err := engine.NewGame(variant, handicap, splitSquares(removed), seed)
+	if err != nil {
+		//让子设置在设置时已经校验过，这里出错时按标准开局
+		log.Println("new game err:", err)
+		variant, handicap, removed = engine.VariantStandard, engine.HandicapNone, ""
+		game = engine.NewPosition()
+	}
+	now := time.Now()
+	g := &roomGame{
+		roomId:  h.id,
+		game:    game,
+		players: [2]*connection{red, black},
+		uuids:   [2]string{red.uuid, black.uuid},
+		names:   [2]string{red.name, black.name},
+		clock:   newClock(s.tc, now),
+		tc:      s.tc,
+
+		variant:       variant,
+		handicap:      handicap,
+		removed:       splitSquares(removed),
+		seed:          seed,
+		drawOffer:     -1,
+		takebackOffer: -1,
+
+		rated:   s.rated,
+		ratings: s.ratings,
+	}
+	h.game = g
+	h.lastRed = red.uuid
+	recordStart(g, now)
+	roomId := h.id
+	writeRoom(roomId, func() {
+		err := redis.SetRoomPlaying(roomId, true)
+		if err != nil {
+			log.Println(err)
+		}
+	})
+	h.armClock(g, now)
+	h.sendRoom(h.frame(TypeGameStart, gameStartPayload{
+		Variant:     game.Variant(),
+		Red:         red.name,
+		Black:       black.name,
+		Fen:         game.Fen(),
+		TimeControl: s.tc,
+		Clock:       g.clock.snapshot(now),
+	}))
+	h.botTurn(g)
+}
+
+// armClock 走棋方的时间用完时通知房间
+func (h *roomHub) armClock(g *roomGame, now time.Time) {
+	if g.timer != nil {
+		g.timer.Stop()
+	}
+	g.timer = time.AfterFunc(g.clock.left(now), func() {
+		hubs.post(g.roomId, false, func(h *roomHub) {
+			h.checkFlag(g)
+		})
+	})
+}
+
+// checkFlag 定时器到时后确认走棋方是否超时，对局已结束的定时器直接忽略
+func (h *roomHub) checkFlag(g *roomGame) {
+	if h.game != g {
+		return
+	}
+	now := time.Now()
+	if g.clock.left(now) > 0 {
+		h.armClock(g, now)
+		return
+	}
+	h.flagFall(g)
+}
+
+// flagFall 超时判负，对方没有取胜的子力时判和
+func (h *roomHub) flagFall(g *roomGame) {
+	sd := g.clock.side
+	if g.game.HasAttacker(1 - sd) {
+		h.endGame(1-sd, engine.ReasonTimeout)
+	} else {
+		h.endGame(engine.WinnerDraw, engine.ReasonTimeout)
+	}
+}
+
+// player 找到请求者所在的对局和座位，出错时回复请求者
+func (h *roomHub) player(r request) (*roomGame, int) {
+	g := h.game
+	if g == nil {
+		sendError(r, ErrNoGame, "no game in progress")
+		return nil, -1
+	}
+	sd := g.side(r.conn)
+	if sd < 0 {
+		sendError(r, ErrNotPlayer, "you are not a player")
+		return nil, -1
+	}
+	return g, sd
+}
+
+// playMove 校验并执行玩家提交的着法，合法着法广播给房间内所有人
+func (h *roomHub) playMove(r request) {
+	g, sd := h.player(r)
+	if g == nil {
+		return
+	}
+	if sd != g.game.Side() {
+		sendError(r, ErrNotTurn, "not your turn")
+		return
+	}
+	now := time.Now()
+	if g.clock.left(now) <= 0 {
+		h.flagFall(g)
+		return
+	}
+	if !g.game.PlayMove(r.move) {
+		sendError(r, ErrIllegalMove, "illegal move")
+		return
+	}
+	g.moves = append(g.moves, r.move)
+	//走棋后没有答复的提和、悔棋作废
+	g.drawOffer, g.takebackOffer = -1, -1
+	g.clock.press(now)
+	h.armClock(g, now)
+
+	square, piece := g.game.LastReveal()
+	clock := g.clock.snapshot(now)
+	recordMove(g, sd, r.move, clock, now)
+	h.sendRoom(h.frame(TypeMove, movePayload{
+		Move:         r.move,
+		Side:         sd,
+		Fen:          g.game.Fen(),
+		RevealSquare: square,
+		RevealPiece:  piece,
+		Clock:        &clock,
+	}))
+
+	winner, reason := g.game.Judge(global.Settings.RuleInfo)
+	if reason != engine.ReasonNone {
+		h.endGame(winner, reason)
+		return
+	}
+	h.botTurn(g)
+}
+
+// leaveGame 对局中玩家被踢出房间，对方获胜
+func (h *roomHub) leaveGame(c *connection) {
+	g := h.game
+	if g == nil {
+		return
+	}
+	if sd := g.side(c); sd >= 0 {
+		h.endGame(1-sd, engine.ReasonAbandon)
+	}
+}
+
+// disconnect 对局中玩家断线，保留座位并通知对方，返回是否保留了座位
+func (h *roomHub) disconnect(c *connection) bool {
+	g := h.game
+	if g == nil {
+		return false
+	}
+	sd := g.side(c)
+	if sd < 0 {
+		return false
+	}
+	g.players[sd] = nil
+	//同一用户还有连接在房间里，座位交给那个连接，不用等重连
+	if other := h.seatedConn(c.uuid, c); other != nil {
+		h.resume(g, sd, other)
+		return true
+	}
+	grace := time.Duration(global.Settings.RoomInfo.Grace) * time.Second
+	g.graces[sd] = time.AfterFunc(grace, func() {
+		hubs.post(g.roomId, false, func(h *roomHub) {
+			h.checkGrace(g, sd)
+		})
+	})
+	notice := fmt.Sprintf("系统消息：对手%s断线，%d秒内未重连判负", c.name, global.Settings.RoomInfo.Grace)
+	h.sendRoom(h.frame(TypeSystem, systemPayload{EventOffline, notice}))
+	return true
+}
+
+// resume 断线的玩家回到座位，发送当前局面、着法、时间和最近的聊天
+func (h *roomHub) resume(g *roomGame, sd int, c *connection) {
+	if g.graces[sd] != nil {
+		g.graces[sd].Stop()
+		g.graces[sd] = nil
+	}
+	g.players[sd] = c
+	h.sendSnapshot(g, c)
+	notice := "系统消息：" + c.name + "已重连"
+	h.sendRoom(h.frame(TypeSystem, systemPayload{EventOnline, notice}))
+}
+
+// sendSnapshot 发送当前局面、着法、时间和最近的聊天，选手看不到观众频道的聊天
+func (h *roomHub) sendSnapshot(g *roomGame, c *connection) {
+	var chats []chatPayload
+	for _, chat := range h.chats {
+		if c.spectator || chat.Channel != ChannelSpectator {
+			chats = append(chats, chat)
+		}
+	}
+	sendTo(c, encodeFrame(TypeSnapshot, h.seq, g.roomId, snapshotPayload{
+		Variant:     g.game.Variant(),
+		Red:         g.names[0],
+		Black:       g.names[1],
+		Fen:         g.game.Fen(),
+		Side:        g.game.Side(),
+		Moves:       g.moves,
+		TimeControl: g.tc,
+		Clock:       g.clock.snapshot(time.Now()),
+		Chats:       chats,
+		Viewers:     h.viewers(),
+	}))
+}
+
+// checkGrace 重连期限到了仍未回来的玩家判负，终局时让出座位
+func (h *roomHub) checkGrace(g *roomGame, sd int) {
+	if h.game != g || g.players[sd] != nil {
+		return
+	}
+	h.endGame(1-sd, engine.ReasonAbandon)
+}
+
+func (h *roomHub) endGame(winner, reason int) {
+	g := h.game
+	if g == nil {
+		return
+	}
+	if g.timer != nil {
+		g.timer.Stop()
+	}
+	for _, t := range g.graces {
+		if t != nil {
+			t.Stop()
+		}
+	}
+	now := time.Now()
+	recordEnd(g, winner, reason, now)
+	ratings := updateRatings(g, winner, now)
+	h.sendRoom(h.frame(TypeGameOver, gameOverPayload{winner, reason, ratings}))
+	h.game = nil
+	//终局时仍未重连的选手让出座位，不然房间会一直显示满员
+	for sd, c := range g.players {
+		if c == nil {
+			h.leaveSeat(g.uuids[sd])
+		}
+	}
+
+	//下一局需要双方重新准备
+	h.ready = nil
+	roomId := h.id
+	writeRoom(roomId, func() {
+		err := redis.SetRoomPlaying(roomId, false)
+		if err != nil {
+			log.Println(err)
+		}
+		err = redis.ResetReady(roomId)
+		if err != nil {
+			log.Println(err)
+		}
+	})
+	for _, name := range g.names {
+		h.sendRoom(h.frame(TypeReady, readyPayload{name, false}))
+	}
+	h.readyBots()
+	h.dropBots()
+}
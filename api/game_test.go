@@ -0,0 +1,83 @@
+package api
+
+import (
+	"go-chess/engine"
+	"go-chess/model"
+	"testing"
+	"time"
+)
+
+// startTestGame 在房间里直接摆一局对局，不经过准备和读取设置
+func startTestGame(t *testing.T, roomId string, red, black *connection) *roomGame {
+	g := &roomGame{
+		roomId:        roomId,
+		game:          engine.NewPosition(),
+		players:       [2]*connection{red, black},
+		uuids:         [2]string{red.uuid, black.uuid},
+		names:         [2]string{red.name, black.name},
+		tc:            model.TimeControl{Mode: model.ClockSudden, Base: 600},
+		drawOffer:     -1,
+		takebackOffer: -1,
+	}
+	g.clock = newClock(g.tc, time.Now())
+	hubs.post(roomId, false, func(h *roomHub) {
+		h.game = g
+	})
+	waitRoom(t, roomId)
+	return g
+}
+
+// inspect 在房间的goroutine里读取房间状态
+func inspect(t *testing.T, roomId string, read func(h *roomHub)) {
+	hubs.post(roomId, false, read)
+	waitRoom(t, roomId)
+}
+
+// TestReconnectBeforeOldConnectionDrops 服务端发现旧连接断开之前选手就重连了，新连接接管座位，旧连接超时后也不判负
+func TestReconnectBeforeOldConnectionDrops(t *testing.T) {
+	const roomId = "reconnect-room"
+	red, black := newTestConn("red"), newTestConn("black")
+	joinRoom(message{nil, roomId, red.name, red, ""})
+	joinRoom(message{nil, roomId, black.name, black, ""})
+	waitRoom(t, roomId)
+	g := startTestGame(t, roomId, red, black)
+
+	again := newTestConn("red")
+	joinRoom(message{nil, roomId, again.name, again, ""})
+	inspect(t, roomId, func(h *roomHub) {
+		if g.players[0] != again {
+			t.Error("new connection did not take over the seat")
+		}
+		if h.conns[red] {
+			t.Error("old connection is still in the room")
+		}
+	})
+
+	//旧连接的读循环超时退出
+	leaveRoom(message{nil, roomId, red.name, red, ""})
+	inspect(t, roomId, func(h *roomHub) {
+		if h.game != g || g.players[0] != again {
+			t.Error("old connection dropping ended the game or freed the seat")
+		}
+		if g.graces[0] != nil {
+			t.Error("grace timer started for a connected player")
+		}
+	})
+
+	//同一用户两个连接都在房间里时，其中一个断开，座位交给另一个
+	second := newTestConn("black")
+	inspect(t, roomId, func(h *roomHub) {
+		h.conns[second] = true
+	})
+	leaveRoom(message{nil, roomId, black.name, black, ""})
+	inspect(t, roomId, func(h *roomHub) {
+		if g.players[1] != second || g.graces[1] != nil {
+			t.Error("seat was not handed to the other connection of the same user")
+		}
+		h.game = nil
+	})
+
+	leaveRoom(message{nil, roomId, again.name, again, ""})
+	leaveRoom(message{nil, roomId, second.name, second, ""})
+	waitGone(t, roomId)
+}
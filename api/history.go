@@ -0,0 +1,263 @@
+package api
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go-chess/dao/mysql"
+	"go-chess/engine"
+	"go-chess/model"
+	"go-chess/util"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// gameInfo 对局列表和详情中的对局信息
+type gameInfo struct {
+	Id          int64             `json:"id"`
+	RedUuid     string            `json:"red_uuid"`
+	BlackUuid   string            `json:"black_uuid"`
+	Red         string            `json:"red"`
+	Black       string            `json:"black"`
+	Variant     int               `json:"variant"`
+	Handicap    int               `json:"handicap"`
+	Removed     string            `json:"removed,omitempty"` //额外去掉的棋子，ICCS坐标，逗号分隔
+	TimeControl model.TimeControl `json:"time_control"`
+	Result      int               `json:"result"`
+	Reason      int               `json:"reason"`
+	MoveCount   int               `json:"move_count"`
+	StartedAt   time.Time         `json:"started_at"`
+	EndedAt     *time.Time        `json:"ended_at"`
+}
+
+// gameDetail 对局详情，揭棋和暗棋结束后才给出seed，可以据此复盘暗子
+type gameDetail struct {
+	gameInfo
+	StartFen string     `json:"start_fen"`
+	Seed     int64      `json:"seed,omitempty"`
+	Moves    []moveInfo `json:"moves"`
+}
+
+type moveInfo struct {
+	Ply     int    `json:"ply"`
+	Side    int    `json:"side"`
+	Move    string `json:"move"`
+	Fen     string `json:"fen"`
+	ClockMs int64  `json:"clock_ms"`
+}
+
+func newGameInfo(game model.Game, names map[string]string) gameInfo {
+	return gameInfo{
+		Id:        game.Id,
+		RedUuid:   game.RedUuid,
+		BlackUuid: game.BlackUuid,
+		Red:       displayName(names, game.RedUuid),
+		Black:     displayName(names, game.BlackUuid),
+		Variant:   game.Variant,
+		Handicap:  game.Handicap,
+		Removed:   game.Removed,
+		TimeControl: model.TimeControl{
+			Mode:      game.ClockMode,
+			Base:      game.ClockBase,
+			Increment: game.ClockIncrement,
+			Periods:   game.ClockPeriods,
+		},
+		Result:    game.Result,
+		Reason:    game.Reason,
+		MoveCount: game.MoveCount,
+		StartedAt: game.StartedAt,
+		EndedAt:   game.EndedAt,
+	}
+}
+
+// listGames 查询用户的对局，不传uuid时查自己；别人的对局只能看到已结束的
+func listGames(ctx *gin.Context) {
+	Iuuid, _ := ctx.Get("uuid")
+	self := Iuuid.(string)
+	q := model.GameQuery{
+		Uuid:   ctx.DefaultQuery("uuid", self),
+		Result: ctx.Query("result"),
+		Color:  ctx.Query("color"),
+	}
+	q.FinishedOnly = q.Uuid != self
+
+	var err error
+	q.Page, err = strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	if err != nil || q.Page < 1 {
+		util.RespErrorWithData(ctx, 400, "page error", "page must be a positive number")
+		return
+	}
+	q.Size, err = strconv.Atoi(ctx.DefaultQuery("size", strconv.Itoa(defaultPageSize)))
+	if err != nil || q.Size < 1 || q.Size > maxPageSize {
+		util.RespErrorWithData(ctx, 400, "size error", "size must be between 1 and 100")
+		return
+	}
+	if q.Result != "" && q.Result != "win" && q.Result != "loss" && q.Result != "draw" {
+		util.RespErrorWithData(ctx, 400, "result error", "result must be win, loss or draw")
+		return
+	}
+	if q.Color != "" && q.Color != "red" && q.Color != "black" {
+		util.RespErrorWithData(ctx, 400, "color error", "color must be red or black")
+		return
+	}
+	if from := ctx.Query("from"); from != "" {
+		q.From, err = time.ParseInLocation("2006-01-02", from, time.Local)
+		if err != nil {
+			util.RespErrorWithData(ctx, 400, "date error", "from must be like 2022-01-02")
+			return
+		}
+	}
+	if to := ctx.Query("to"); to != "" {
+		q.To, err = time.ParseInLocation("2006-01-02", to, time.Local)
+		if err != nil {
+			util.RespErrorWithData(ctx, 400, "date error", "to must be like 2022-01-02")
+			return
+		}
+		//包含to当天
+		q.To = q.To.AddDate(0, 0, 1)
+	}
+	if opponent := ctx.Query("opponent"); opponent != "" {
+		q.Opponent, err = mysql.SelectUuidByName(opponent)
+		if err != nil {
+			util.RespErrorWithData(ctx, 400, "opponent error", "opponent not found")
+			return
+		}
+	}
+
+	games, total, err := mysql.SelectGames(q)
+	if err != nil {
+		util.RespError(ctx, 500, "select games error")
+		return
+	}
+	var uuids []string
+	for _, game := range games {
+		uuids = append(uuids, game.RedUuid, game.BlackUuid)
+	}
+	names := map[string]string{}
+	if len(uuids) > 0 {
+		names, err = mysql.SelectUserNamesByUuids(uuids)
+		if err != nil {
+			util.RespError(ctx, 500, "select names error")
+			return
+		}
+	}
+	infos := make([]gameInfo, 0, len(games))
+	for _, game := range games {
+		infos = append(infos, newGameInfo(game, names))
+	}
+	util.RespSuccessfulWithData(ctx, "list games successful", gin.H{
+		"total": total,
+		"page":  q.Page,
+		"size":  q.Size,
+		"games": infos,
+	})
+}
+
+// loadGame 读取对局和着法，进行中的对局只有对局双方能看
+func loadGame(ctx *gin.Context) (gameDetail, bool) {
+	Iuuid, _ := ctx.Get("uuid")
+	uuid := Iuuid.(string)
+	id, err := strconv.ParseInt(ctx.Param("game_id"), 10, 64)
+	if err != nil {
+		util.RespErrorWithData(ctx, 400, "game error", "game_id must be a number")
+		return gameDetail{}, false
+	}
+	game, err := mysql.SelectGameById(id)
+	if err != nil {
+		util.RespErrorWithData(ctx, 400, "game error", "game not found")
+		return gameDetail{}, false
+	}
+	bFinished := game.Result != model.ResultPlaying
+	if !bFinished && uuid != game.RedUuid && uuid != game.BlackUuid {
+		util.RespErrorWithData(ctx, 400, "game error", "game is in progress")
+		return gameDetail{}, false
+	}
+	moves, err := mysql.SelectGameMoves(id)
+	if err != nil {
+		util.RespError(ctx, 500, "select moves error")
+		return gameDetail{}, false
+	}
+	names, err := mysql.SelectUserNamesByUuids([]string{game.RedUuid, game.BlackUuid})
+	if err != nil {
+		util.RespError(ctx, 500, "select names error")
+		return gameDetail{}, false
+	}
+
+	detail := gameDetail{
+		gameInfo: newGameInfo(game, names),
+		StartFen: game.StartFen,
+		Moves:    make([]moveInfo, 0, len(moves)),
+	}
+	if bFinished && game.Variant != engine.VariantStandard {
+		detail.Seed = game.Seed
+	}
+	for _, mv := range moves {
+		detail.Moves = append(detail.Moves, moveInfo{mv.Ply, mv.Side, mv.Move, mv.Fen, mv.ClockMs})
+	}
+	return detail, true
+}
+
+// getGame 查询一局棋及全部着法
+func getGame(ctx *gin.Context) {
+	detail, ok := loadGame(ctx)
+	if !ok {
+		return
+	}
+	util.RespSuccessfulWithData(ctx, "get game successful", detail)
+}
+
+// exportGame 导出ICCS格式的PGN棋谱
+func exportGame(ctx *gin.Context) {
+	detail, ok := loadGame(ctx)
+	if !ok {
+		return
+	}
+	ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=game_%d.pgn", detail.Id))
+	ctx.String(http.StatusOK, gamePgn(detail))
+}
+
+// gamePgn 生成PGN棋谱，着法用ICCS坐标，暗棋翻子只有一个格子
+func gamePgn(d gameDetail) string {
+	results := map[int]string{
+		engine.WinnerRed:   "1-0",
+		engine.WinnerBlack: "0-1",
+		engine.WinnerDraw:  "1/2-1/2",
+	}
+	result, ok := results[d.Result]
+	if !ok {
+		result = "*"
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "[Game \"Chinese Chess\"]\n")
+	fmt.Fprintf(&sb, "[Event \"go-chess #%d\"]\n", d.Id)
+	fmt.Fprintf(&sb, "[Date \"%s\"]\n", d.StartedAt.Format("2006.01.02"))
+	fmt.Fprintf(&sb, "[Red \"%s\"]\n", d.Red)
+	fmt.Fprintf(&sb, "[Black \"%s\"]\n", d.Black)
+	fmt.Fprintf(&sb, "[Result \"%s\"]\n", result)
+	fmt.Fprintf(&sb, "[Variant \"%d\"]\n", d.Variant)
+	fmt.Fprintf(&sb, "[TimeControl \"%s %d+%d/%d\"]\n", d.TimeControl.Mode, d.TimeControl.Base, d.TimeControl.Increment, d.TimeControl.Periods)
+	fmt.Fprintf(&sb, "[FEN \"%s\"]\n", d.StartFen)
+	fmt.Fprintf(&sb, "[Format \"ICCS\"]\n\n")
+	for i, mv := range d.Moves {
+		if i%2 == 0 {
+			fmt.Fprintf(&sb, "%d. ", i/2+1)
+		}
+		move := strings.ToUpper(mv.Move)
+		if len(move) == 4 {
+			move = move[:2] + "-" + move[2:]
+		}
+		sb.WriteString(move)
+		sb.WriteByte(' ')
+		if i%2 == 1 {
+			sb.WriteByte('\n')
+		}
+	}
+	sb.WriteString(result + "\n")
+	return sb.String()
+}
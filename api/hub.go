@@ -0,0 +1,322 @@
+package api
+
+import (
+	"go-chess/dao/redis"
+	"log"
+	"sync"
+	"time"
+)
+
+// roomQueueSize 每个房间积压事件的软上限，超过后丢弃聊天和心跳，其他事件照常排队并计数
+const roomQueueSize = 64
+
+// hub 所有房间的索引，每个房间由自己的goroutine处理，一个房间慢不会拖住其他房间
+type hub struct {
+	sync.Mutex
+	rooms     map[string]*roomHub
+	dropped   int64 //积压时丢弃的聊天和心跳
+	overflows int64 //积压超过上限后仍然排队的事件
+}
+
+var hubs = hub{rooms: make(map[string]*roomHub)}
+
+// roomHub 一个房间的actor，房间的连接、对局、消息序号和聊天只由它自己的goroutine读写
+// 广播时只往连接的发送队列里放，不做redis、MySQL等I/O
+type roomHub struct {
+	id     string
+	events []func(h *roomHub) //待处理的事件，由hubs的锁保护
+	wake   chan struct{}
+
+	conns   map[*connection]bool
+	game    *roomGame
+	seq     int64
+	chats   []chatPayload
+	lastRed string          //上一局执红的用户，下一局交换
+	ready   []string        //最近一次准备状态变化后已准备的用户
+	bans    map[string]bool //被房主踢出的用户
+	loading bool            //正在读取开局设置
+}
+
+// post 把事件交给房间处理，投递方不等待；create为false且房间不存在时丢弃
+func (hs *hub) post(roomId string, create bool, ev func(h *roomHub)) {
+	hs.enqueue(roomId, create, false, ev)
+}
+
+// offer 聊天和心跳交给房间处理，房间积压超过roomQueueSize时丢弃，返回是否投递
+func (hs *hub) offer(roomId string, ev func(h *roomHub)) bool {
+	return hs.enqueue(roomId, false, true, ev)
+}
+
+func (hs *hub) enqueue(roomId string, create, droppable bool, ev func(h *roomHub)) bool {
+	hs.Lock()
+	h := hs.rooms[roomId]
+	if h == nil {
+		if !create {
+			hs.Unlock()
+			return false
+		}
+		h = &roomHub{
+			id:    roomId,
+			wake:  make(chan struct{}, 1),
+			conns: make(map[*connection]bool),
+			bans:  make(map[string]bool),
+		}
+		hs.rooms[roomId] = h
+		go h.run()
+	}
+	if len(h.events) >= roomQueueSize {
+		if droppable {
+			hs.dropped++
+			hs.Unlock()
+			return false
+		}
+		hs.overflows++
+	}
+	h.events = append(h.events, ev)
+	hs.Unlock()
+	select {
+	case h.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// next 取出下一个事件，房间没有连接、没有对局也没有待处理的事件时从索引中移除，返回nil
+func (hs *hub) next(h *roomHub) func(h *roomHub) {
+	hs.Lock()
+	defer hs.Unlock()
+	if len(h.events) > 0 {
+		ev := h.events[0]
+		h.events[0] = nil
+		h.events = h.events[1:]
+		return ev
+	}
+	if len(h.conns) == 0 && h.game == nil {
+		delete(hs.rooms, h.id)
+	}
+	return nil
+}
+
+// has 房间是否在本实例上
+func (hs *hub) has(roomId string) bool {
+	hs.Lock()
+	defer hs.Unlock()
+	return hs.rooms[roomId] != nil
+}
+
+// roomIds 本实例上的所有房间，包括等待重连的对局
+func (hs *hub) roomIds() []string {
+	hs.Lock()
+	defer hs.Unlock()
+	ids := make([]string, 0, len(hs.rooms))
+	for id := range hs.rooms {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (h *roomHub) run() {
+	for range h.wake {
+		for {
+			ev := hubs.next(h)
+			if ev != nil {
+				ev(h)
+				continue
+			}
+			if len(h.conns) > 0 || h.game != nil {
+				break
+			}
+			//房间空了，之后进入的用户可以由任意实例接管
+			roomId := h.id
+			writeRoom(roomId, func() {
+				releaseNode(roomId)
+			})
+			return
+		}
+	}
+}
+
+// joinRoom 连接进入房间，房间不存在时创建
+func joinRoom(m message) {
+	hubs.post(m.roomId, true, func(h *roomHub) {
+		h.join(m.conn)
+	})
+}
+
+// leaveRoom 连接断开
+func leaveRoom(m message) {
+	//匹配超时后从房间回到队列的连接已不在房间里，房间可能已经退出
+	if waiting.remove(m.conn) {
+		close(m.conn.send)
+		_ = redis.LeaveMatch(m.conn.uuid)
+		return
+	}
+	hubs.post(m.roomId, false, func(h *roomHub) {
+		h.leave(m.conn)
+	})
+}
+
+// postRequest 走棋、准备、心跳和错误回复交给房间处理，房间积压时先丢弃心跳
+func postRequest(r request) {
+	ev := func(h *roomHub) {
+		h.handle(r)
+	}
+	if r.typ == TypePing {
+		hubs.offer(r.roomId, ev)
+		return
+	}
+	hubs.post(r.roomId, false, ev)
+}
+
+// postReady 准备状态的变化交给房间处理
+func postReady(e readyEvent) {
+	hubs.post(e.roomId, false, func(h *roomHub) {
+		h.setReady(e)
+	})
+}
+
+// postOwnerEvent 房主操作交给房间处理
+func postOwnerEvent(e ownerEvent) {
+	hubs.post(e.roomId, false, func(h *roomHub) {
+		h.ownerAction(e)
+	})
+}
+
+// join 连接进入房间，观众补发当前对局，断线的选手回到座位
+func (h *roomHub) join(c *connection) {
+	if h.bans[c.uuid] {
+		//踢人和进入房间同时发生时，进入的连接在这里拦下
+		sendSystem(c, h.id, EventKicked, "您已被房主踢出房间！！！")
+		close(c.send)
+		if !c.spectator {
+			h.leaveSeat(c.uuid)
+		}
+		return
+	}
+	h.conns[c] = true
+
+	if c.spectator {
+		if h.game != nil {
+			h.sendSnapshot(h.game, c)
+		}
+		h.sendViewers()
+		return
+	}
+
+	sysmsg := "系统消息：欢迎新伙伴" + c.name + "加入" + h.id + "聊天室！！！"
+	h.sendRoom(h.frame(TypeSystem, systemPayload{EventJoin, sysmsg}))
+
+	//断线的玩家重连后回到座位；服务端可能还没发现旧连接已断，新连接直接顶替旧连接
+	if g := h.game; g != nil {
+		if sd := g.seat(c.uuid); sd >= 0 {
+			if old := g.players[sd]; old != nil && old != c && h.conns[old] {
+				delete(h.conns, old)
+				close(old.send)
+			}
+			h.resume(g, sd, c)
+		}
+	}
+}
+
+// seatedConn 房间里同一用户的另一个选手连接，没有时返回nil
+func (h *roomHub) seatedConn(uuid string, except *connection) *connection {
+	for c := range h.conns {
+		if c != except && !c.spectator && c.uuid == uuid {
+			return c
+		}
+	}
+	return nil
+}
+
+// leave 连接断开或跟不上被断开，对局中的选手保留座位等待重连
+func (h *roomHub) leave(c *connection) {
+	if !h.conns[c] {
+		//匹配超时后从房间回到队列的连接，断开时离开队列
+		if waiting.remove(c) {
+			close(c.send)
+			uid := c.uuid
+			writeRoom(h.id, func() {
+				_ = redis.LeaveMatch(uid)
+			})
+		}
+		return
+	}
+	delete(h.conns, c)
+	close(c.send)
+	if c.spectator {
+		h.sendViewers()
+	} else if !h.disconnect(c) && h.seatedConn(c.uuid, c) == nil {
+		h.leaveSeat(c.uuid)
+		delMsg := "系统消息：" + c.name + "离开了" + h.id + "聊天室"
+		h.sendRoom(h.frame(TypeSystem, systemPayload{EventLeave, delMsg}))
+	}
+	h.dropBots()
+}
+
+// kickout 3次不合法信息后，被踢出群聊
+func (h *roomHub) kickout(c *connection) {
+	//连接可能已经断开，发送队列已关闭
+	if !h.conns[c] {
+		return
+	}
+	notice := "由于您多次发送不合法信息,已被踢出群聊！！！"
+	sendSystem(c, h.id, EventKicked, notice)
+	h.leaveGame(c)
+	delete(h.conns, c)
+	close(c.send)
+	if !c.spectator {
+		h.leaveSeat(c.uuid)
+	}
+	h.dropBots()
+}
+
+// notify 单独提示房间里的一个连接
+func (h *roomHub) notify(c *connection, event, text string) {
+	if h.conns[c] {
+		sendSystem(c, h.id, event, text)
+	}
+}
+
+// chat 转发聊天，观众频道只发给观众
+func (h *roomHub) chat(m message) {
+	if !h.conns[m.conn] {
+		//连接已被踢出、断开或回到匹配队列
+		return
+	}
+	chat := chatPayload{m.name, string(m.data), m.channel}
+	h.keepChat(chat)
+	var data []byte
+	if m.channel == ChannelSpectator {
+		//观众频道不占用房间的消息序号，避免选手看到序号不连续
+		data = encodeFrame(TypeChat, 0, h.id, chat)
+	} else {
+		data = h.frame(TypeChat, chat)
+	}
+	for con := range h.conns {
+		if con == m.conn { //自己发送的信息，不用再发给自己
+			continue
+		}
+		if m.channel == ChannelSpectator && !con.spectator { //观众频道只发给观众
+			continue
+		}
+		h.send(con, data)
+	}
+}
+
+// send 发给房间里的一个连接，发送队列已满说明客户端跟不上，直接断开而不是等它
+func (h *roomHub) send(c *connection, data []byte) {
+	select {
+	case c.send <- data:
+	default:
+		log.Println("send queue full, drop slow connection", c.name)
+		h.leave(c)
+	}
+}
+
+// leaseLoop 定时续期本实例负责的房间
+func leaseLoop() {
+	ticker := time.NewTicker(nodeRefresh)
+	for range ticker.C {
+		_ = redis.RefreshRoomNodes(hubs.roomIds(), nodeId, nodeTTL)
+	}
+}
@@ -0,0 +1,229 @@
+package api
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestConn 测试用的连接，发给它的消息由goroutine读走
+func newTestConn(name string) *connection {
+	c := &connection{send: make(chan []byte, 256), name: name, uuid: "uuid-" + name}
+	go func() {
+		for range c.send {
+		}
+	}()
+	return c
+}
+
+// waitRoom 等待事件都处理完，返回前房间里之前投递的事件已全部执行
+func waitRoom(tb testing.TB, roomId string) {
+	done := make(chan struct{})
+	hubs.post(roomId, false, func(h *roomHub) {
+		close(done)
+	})
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		tb.Fatalf("room %s did not drain", roomId)
+	}
+}
+
+// waitGone 等待房间退出
+func waitGone(tb testing.TB, roomId string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		hubs.Lock()
+		_, ok := hubs.rooms[roomId]
+		hubs.Unlock()
+		if !ok {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	tb.Fatalf("room %s did not exit", roomId)
+}
+
+func TestHubDropsSlowConsumer(t *testing.T) {
+	fast := newTestConn("fast")
+	slow := &connection{send: make(chan []byte, 1), name: "slow", uuid: "uuid-slow"} //没有人读
+	joinRoom(message{nil, "slow-room", fast.name, fast, ""})
+	joinRoom(message{nil, "slow-room", slow.name, slow, ""})
+	for i := 0; i < 10; i++ {
+		m := message{[]byte("hi"), "slow-room", fast.name, fast, ""}
+		hubs.post("slow-room", false, func(h *roomHub) {
+			h.chat(m)
+		})
+	}
+	waitRoom(t, "slow-room")
+
+	var stillIn bool
+	hubs.post("slow-room", false, func(h *roomHub) {
+		stillIn = h.conns[slow]
+	})
+	waitRoom(t, "slow-room")
+	if stillIn {
+		t.Fatal("slow connection should be dropped")
+	}
+	//发送队列已关闭
+	for range slow.send {
+	}
+
+	leaveRoom(message{nil, "slow-room", fast.name, fast, ""})
+	waitGone(t, "slow-room")
+}
+
+func TestHubPostDoesNotBlock(t *testing.T) {
+	c := newTestConn("blocked")
+	joinRoom(message{nil, "busy-room", c.name, c, ""})
+	release := make(chan struct{})
+	hubs.post("busy-room", false, func(h *roomHub) {
+		<-release
+	})
+
+	hubs.Lock()
+	dropped, overflows := hubs.dropped, hubs.overflows
+	hubs.Unlock()
+	start := time.Now()
+	for i := 0; i < 10*roomQueueSize; i++ {
+		hubs.post("busy-room", false, func(h *roomHub) {})
+		hubs.offer("busy-room", func(h *roomHub) {})
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("posting to a busy room took %v", d)
+	}
+	hubs.Lock()
+	dropped, overflows = hubs.dropped-dropped, hubs.overflows-overflows
+	hubs.Unlock()
+	if dropped == 0 || overflows == 0 {
+		t.Fatalf("dropped %d overflows %d, want both > 0", dropped, overflows)
+	}
+
+	close(release)
+	waitRoom(t, "busy-room")
+	leaveRoom(message{nil, "busy-room", c.name, c, ""})
+	waitGone(t, "busy-room")
+}
+
+func TestHubRoomsIndependent(t *testing.T) {
+	block := make(chan struct{})
+	a, b := newTestConn("a"), newTestConn("b")
+	joinRoom(message{nil, "room-a", a.name, a, ""})
+	joinRoom(message{nil, "room-b", b.name, b, ""})
+	hubs.post("room-a", false, func(h *roomHub) {
+		<-block
+	})
+	//room-a卡住时room-b照常处理
+	waitRoom(t, "room-b")
+	close(block)
+	waitRoom(t, "room-a")
+	leaveRoom(message{nil, "room-a", a.name, a, ""})
+	leaveRoom(message{nil, "room-b", b.name, b, ""})
+	waitGone(t, "room-a")
+	waitGone(t, "room-b")
+}
+
+// singleLoop 改造前的hub：所有房间的事件经同一个无缓冲channel交给一个goroutine处理，只用于基准测试对比
+type singleLoop struct {
+	rooms  map[string]*roomHub
+	events chan func()
+}
+
+func newSingleLoop() *singleLoop {
+	l := &singleLoop{rooms: make(map[string]*roomHub), events: make(chan func())}
+	go func() {
+		for ev := range l.events {
+			ev()
+		}
+	}()
+	return l
+}
+
+// post 和改造前一样，投递方等到循环空出来才能交出事件
+func (l *singleLoop) post(roomId string, ev func(h *roomHub)) {
+	l.events <- func() {
+		h := l.rooms[roomId]
+		if h == nil {
+			h = &roomHub{id: roomId, conns: make(map[*connection]bool), bans: make(map[string]bool)}
+			l.rooms[roomId] = h
+		}
+		ev(h)
+	}
+}
+
+// benchmarkHub 在rooms个房间里轮流广播聊天，slow为true时每个房间都有一个不读消息的客户端，
+// single为true时用改造前的单循环hub作对比
+func benchmarkHub(b *testing.B, rooms int, slow, single bool) {
+	post := func(roomId string, ev func(h *roomHub)) {
+		hubs.post(roomId, true, ev)
+	}
+	var loop *singleLoop
+	if single {
+		loop = newSingleLoop()
+		defer close(loop.events)
+		post = loop.post
+	}
+	ids := make([]string, rooms)
+	senders := make([]*connection, rooms)
+	var all []message
+	for i := range ids {
+		ids[i] = fmt.Sprintf("bench-%d-%v-%v-%d", rooms, slow, single, i)
+		senders[i] = newTestConn(fmt.Sprintf("s%d", i))
+		members := []*connection{senders[i], newTestConn(fmt.Sprintf("r%d", i))}
+		if slow {
+			members = append(members, &connection{send: make(chan []byte, 1), name: "slow", uuid: "uuid-slow"})
+		}
+		for _, c := range members {
+			m := message{nil, ids[i], c.name, c, ""}
+			post(m.roomId, func(h *roomHub) {
+				h.join(m.conn)
+			})
+			all = append(all, m)
+		}
+	}
+	if !single {
+		for _, id := range ids {
+			waitRoom(b, id)
+		}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	var wg sync.WaitGroup
+	wg.Add(b.N)
+	for n := 0; n < b.N; n++ {
+		i := n % rooms
+		m := message{[]byte("hello"), ids[i], senders[i].name, senders[i], ""}
+		post(ids[i], func(h *roomHub) {
+			h.chat(m)
+			wg.Done()
+		})
+	}
+	wg.Wait()
+	b.StopTimer()
+
+	for _, m := range all {
+		m := m
+		post(m.roomId, func(h *roomHub) {
+			h.leave(m.conn)
+		})
+	}
+	if !single {
+		for _, id := range ids {
+			waitGone(b, id)
+		}
+	}
+}
+
+func BenchmarkHub(b *testing.B) {
+	for _, hub := range []string{"single", "actor"} {
+		for _, rooms := range []int{100, 1000, 5000} {
+			for _, slow := range []bool{false, true} {
+				b.Run(fmt.Sprintf("%s/rooms=%d/slow=%v", hub, rooms, slow), func(b *testing.B) {
+					benchmarkHub(b, rooms, slow, hub == "single")
+				})
+			}
+		}
+	}
+}
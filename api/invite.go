@@ -0,0 +1,161 @@
+package api
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+	"go-chess/dao/mysql"
+	"go-chess/dao/redis"
+	"go-chess/global"
+	"go-chess/model"
+	"go-chess/util"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultInviteTTL = 3600          //邀请码默认有效期(秒)
+	maxInviteTTL     = 7 * 24 * 3600 //邀请码最长有效期(秒)
+	defaultQRSize    = 256           //二维码默认边长(像素)
+	maxQRSize        = 1024
+)
+
+// inviteSigningKey 和登录token分开签名，邀请码不能当作登录token使用；密钥来自配置，启动时由initInvite设置
+var inviteSigningKey []byte
+
+// minInviteSecret 邀请码密钥的最短长度
+const minInviteSecret = 16
+
+// initInvite 读取邀请码密钥，没有配置或太短时返回错误，api不能启动
+func initInvite() error {
+	secret := global.Settings.RoomInfo.InviteSecret
+	if len(secret) < minInviteSecret {
+		return fmt.Errorf("room.inviteSecret (or CHESS_INVITE_SECRET) must be set to at least %d characters", minInviteSecret)
+	}
+	inviteSigningKey = []byte(secret)
+	return nil
+}
+
+type inviteInfo struct {
+	RoomId    string `json:"room_id"`
+	Token     string `json:"token"`
+	Link      string `json:"link"`
+	Invitee   string `json:"invitee,omitempty"`
+	ExpiresAt int64  `json:"expires_at"` //过期时间(秒)
+}
+
+// checkRoomAccess 进入私有或有密码的房间时校验密码或邀请码，房主和已占座的用户不需要
+func checkRoomAccess(meta model.RoomMeta, roomId, uuid, password, invite string) bool {
+	if (!meta.Private && meta.Password == "") || meta.Owner == uuid {
+		return true
+	}
+	if meta.Password != "" && subtle.ConstantTimeCompare([]byte(meta.Password), []byte(hashPassword(roomId, password))) == 1 {
+		return true
+	}
+	return invite != "" && checkInvite(invite, roomId, uuid, meta)
+}
+
+// checkInvite 校验邀请码的签名、有效期、房间和被邀请人
+func checkInvite(token, roomId, uuid string, meta model.RoomMeta) bool {
+	claims := &model.InviteClaims{}
+	t, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		return inviteSigningKey, nil
+	})
+	if err != nil || !t.Valid {
+		return false
+	}
+	if claims.RoomId != roomId || claims.Created != meta.CreatedAt {
+		return false
+	}
+	return claims.Invitee == "" || claims.Invitee == uuid
+}
+
+// mintInvite 签发邀请码，房主或房间里的选手才能邀请，invitee为被邀请人的用户名，不填时任何人可用
+func mintInvite(ctx *gin.Context, get func(key, defaultValue string) string) (inviteInfo, bool) {
+	Iuuid, _ := ctx.Get("uuid")
+	uuid := Iuuid.(string)
+	roomId := ctx.Param("room_id")
+	ttl, err := strconv.Atoi(get("expire", strconv.Itoa(defaultInviteTTL)))
+	if err != nil || ttl < 1 || ttl > maxInviteTTL {
+		util.RespErrorWithData(ctx, 400, "invite error", "expire must be between 1 and "+strconv.Itoa(maxInviteTTL))
+		return inviteInfo{}, false
+	}
+
+	meta, err := redis.GetRoomMeta(roomId)
+	if err != nil {
+		util.RespError(ctx, 400, "get room error")
+		return inviteInfo{}, false
+	}
+	if meta.CreatedAt == 0 {
+		util.RespErrorWithData(ctx, 400, "invite error", "only rooms created in the lobby can be invited to")
+		return inviteInfo{}, false
+	}
+	if meta.Owner != uuid {
+		flag, err := redis.IsInRoom(roomId, uuid)
+		if err != nil {
+			util.RespError(ctx, 400, "judge in the room err")
+			return inviteInfo{}, false
+		}
+		if !flag {
+			util.RespErrorWithData(ctx, 400, "invite error", "you are not in the room")
+			return inviteInfo{}, false
+		}
+	}
+
+	info := inviteInfo{RoomId: roomId, Invitee: get("invitee", "")}
+	claims := model.InviteClaims{
+		RoomId:  roomId,
+		Created: meta.CreatedAt,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Unix() + int64(ttl),
+			Issuer:    "YuanXinHao",
+		},
+	}
+	if info.Invitee != "" {
+		claims.Invitee, err = mysql.SelectUuidByName(info.Invitee)
+		if err != nil || claims.Invitee == "" {
+			util.RespErrorWithData(ctx, 400, "invite error", "invitee does not exist")
+			return inviteInfo{}, false
+		}
+	}
+	info.Token, err = jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(inviteSigningKey)
+	if err != nil {
+		util.RespError(ctx, 400, "sign invite error")
+		return inviteInfo{}, false
+	}
+	info.ExpiresAt = claims.ExpiresAt
+	info.Link = global.Settings.RoomInfo.InviteUrl + "?" + url.Values{"room_id": {roomId}, "invite": {info.Token}}.Encode()
+	return info, true
+}
+
+// createInvite 生成房间的邀请链接
+func createInvite(ctx *gin.Context) {
+	info, ok := mintInvite(ctx, ctx.DefaultPostForm)
+	if !ok {
+		return
+	}
+	util.RespSuccessfulWithData(ctx, "create invite successful", info)
+}
+
+// inviteQRCode 生成邀请链接并返回二维码PNG，方便用手机扫码进入
+func inviteQRCode(ctx *gin.Context) {
+	size, err := strconv.Atoi(ctx.DefaultQuery("size", strconv.Itoa(defaultQRSize)))
+	if err != nil || size < 64 || size > maxQRSize {
+		util.RespErrorWithData(ctx, 400, "invite error", "size must be between 64 and "+strconv.Itoa(maxQRSize))
+		return
+	}
+	info, ok := mintInvite(ctx, ctx.DefaultQuery)
+	if !ok {
+		return
+	}
+	png, err := qrcode.Encode(info.Link, qrcode.Medium, size)
+	if err != nil {
+		util.RespError(ctx, 400, "encode qr code error")
+		return
+	}
+	ctx.Data(http.StatusOK, "image/png", png)
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"github.com/dgrijalva/jwt-go"
+	"go-chess/global"
+	"go-chess/model"
+	"testing"
+	"time"
+)
+
+func TestInitInvite(t *testing.T) {
+	secret := global.Settings.RoomInfo.InviteSecret
+	defer func() { global.Settings.RoomInfo.InviteSecret = secret }()
+	for _, tt := range []struct {
+		secret  string
+		wantErr bool
+	}{
+		{"", true},
+		{"short", true},
+		{"0123456789abcdef", false},
+	} {
+		global.Settings.RoomInfo.InviteSecret = tt.secret
+		if err := initInvite(); (err != nil) != tt.wantErr {
+			t.Errorf("initInvite(%q) err = %v, wantErr %v", tt.secret, err, tt.wantErr)
+		}
+	}
+}
+
+// TestCheckInviteRejectsOtherKey 只知道房间号和创建时间、不知道密钥时伪造不了邀请码
+func TestCheckInviteRejectsOtherKey(t *testing.T) {
+	key := inviteSigningKey
+	defer func() { inviteSigningKey = key }()
+	inviteSigningKey = []byte("server-side-secret-key")
+	meta := model.RoomMeta{Private: true, CreatedAt: 1000}
+	claims := model.InviteClaims{
+		RoomId:         "private-room",
+		Created:        meta.CreatedAt,
+		StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Unix() + 60},
+	}
+	sign := func(key string) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	if !checkInvite(sign("server-side-secret-key"), "private-room", "uuid-guest", meta) {
+		t.Error("invite signed with the configured key was rejected")
+	}
+	if checkInvite(sign("go-chess-invite"), "private-room", "uuid-guest", meta) {
+		t.Error("invite signed with the old built-in key was accepted")
+	}
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"go-chess/dao/mysql"
+	"go-chess/dao/redis"
+	"go-chess/model"
+	"go-chess/rating"
+	"go-chess/util"
+	"math"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultTopN   = 20
+	maxTopN       = 100
+	defaultRadius = 5
+	maxRadius     = 25
+)
+
+type rankInfo struct {
+	Rank  int64   `json:"rank"`
+	Uuid  string  `json:"uuid"`
+	Name  string  `json:"name"`
+	Score float64 `json:"score"`
+}
+
+// leaderboardKey 校验类别和周期，返回对应排行榜的key
+func leaderboardKey(ctx *gin.Context) (string, bool) {
+	category := ctx.Param("category")
+	if !rating.IsCategory(category) {
+		util.RespErrorWithData(ctx, 400, "leaderboard error", "category must be bullet, blitz, rapid or classical")
+		return "", false
+	}
+	period := ctx.DefaultQuery("period", rating.PeriodAll)
+	switch period {
+	case rating.PeriodAll, rating.PeriodWeek, rating.PeriodMonth:
+		return redis.LeaderboardKey(category, period, time.Now()), true
+	}
+	util.RespErrorWithData(ctx, 400, "leaderboard error", "period must be all, week or month")
+	return "", false
+}
+
+// queryLimit 读取数量参数，超出范围时报错
+func queryLimit(ctx *gin.Context, key string, defaultValue, max int) (int64, bool) {
+	n, err := strconv.Atoi(ctx.DefaultQuery(key, strconv.Itoa(defaultValue)))
+	if err != nil || n < 1 || n > max {
+		util.RespErrorWithData(ctx, 400, "leaderboard error", key+" must be between 1 and "+strconv.Itoa(max))
+		return 0, false
+	}
+	return int64(n), true
+}
+
+func rankInfos(entries []model.RankEntry) ([]rankInfo, error) {
+	uuids := make([]string, 0, len(entries))
+	for _, e := range entries {
+		uuids = append(uuids, e.Uuid)
+	}
+	names, err := mysql.SelectUserNamesByUuids(uuids)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]rankInfo, 0, len(entries))
+	for _, e := range entries {
+		infos = append(infos, rankInfo{e.Rank, e.Uuid, names[e.Uuid], math.Round(e.Score)})
+	}
+	return infos, nil
+}
+
+// topLeaderboard 排行榜前n名，总榜按当前等级分，周榜和月榜按周期内等级分的变化
+func topLeaderboard(ctx *gin.Context) {
+	key, ok := leaderboardKey(ctx)
+	if !ok {
+		return
+	}
+	n, ok := queryLimit(ctx, "n", defaultTopN, maxTopN)
+	if !ok {
+		return
+	}
+	entries, err := redis.TopLeaderboard(key, n)
+	if err != nil {
+		util.RespError(ctx, 400, "get leaderboard error")
+		return
+	}
+	infos, err := rankInfos(entries)
+	if err != nil {
+		util.RespError(ctx, 400, "select user error")
+		return
+	}
+	util.RespSuccessfulWithData(ctx, "get leaderboard successful", infos)
+}
+
+// aroundLeaderboard 用户在排行榜上的前后几名，不传uuid时查自己
+func aroundLeaderboard(ctx *gin.Context) {
+	key, ok := leaderboardKey(ctx)
+	if !ok {
+		return
+	}
+	radius, ok := queryLimit(ctx, "range", defaultRadius, maxRadius)
+	if !ok {
+		return
+	}
+	Iuuid, _ := ctx.Get("uuid")
+	uuid := ctx.DefaultQuery("uuid", Iuuid.(string))
+	entries, found, err := redis.AroundLeaderboard(key, uuid, radius)
+	if err != nil {
+		util.RespError(ctx, 400, "get leaderboard error")
+		return
+	}
+	if !found {
+		util.RespErrorWithData(ctx, 400, "leaderboard error", "user is not on the leaderboard")
+		return
+	}
+	infos, err := rankInfos(entries)
+	if err != nil {
+		util.RespError(ctx, 400, "select user error")
+		return
+	}
+	util.RespSuccessfulWithData(ctx, "get leaderboard successful", infos)
+}
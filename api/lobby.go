@@ -0,0 +1,293 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/gin-gonic/gin"
+	"go-chess/dao/mysql"
+	"go-chess/dao/redis"
+	"go-chess/engine"
+	"go-chess/model"
+	"go-chess/rating"
+	"go-chess/util"
+	"log"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	roomCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789" //去掉了容易看错的I、O、0、1
+	roomCodeLength   = 6
+	roomCodeRetries  = 5
+)
+
+type roomPlayer struct {
+	Uuid   string  `json:"uuid"`
+	Name   string  `json:"name"`
+	Rating float64 `json:"rating"` //房间用时类别下的等级分
+	Ready  bool    `json:"ready"`
+}
+
+type roomInfo struct {
+	RoomId      string            `json:"room_id"`
+	Owner       string            `json:"owner"`
+	OwnerName   string            `json:"owner_name"`
+	Variant     int               `json:"variant"`
+	TimeControl model.TimeControl `json:"time_control"`
+	Rated       bool              `json:"rated"`
+	Private     bool              `json:"private"`
+	HasPassword bool              `json:"has_password"`
+	Locked      bool              `json:"locked"`
+	Status      string            `json:"status"`
+	Players     []roomPlayer      `json:"players"`
+	CreatedAt   int64             `json:"created_at"`
+}
+
+// newRoomCode 生成房间号，用户可以口头或手动输入
+func newRoomCode() (string, error) {
+	code := make([]byte, roomCodeLength)
+	max := big.NewInt(int64(len(roomCodeAlphabet)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = roomCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// hashPassword 房间密码加上房间号后取哈希，redis中不存明文
+func hashPassword(roomId, password string) string {
+	sum := sha256.Sum256([]byte(roomId + ":" + password))
+	return hex.EncodeToString(sum[:])
+}
+
+// createRoom 在大厅创建房间，返回生成的房间号，创建者之后用房间号进入
+func createRoom(ctx *gin.Context) {
+	Iuuid, _ := ctx.Get("uuid")
+	uuid := Iuuid.(string)
+	variant, err := strconv.Atoi(ctx.DefaultPostForm("variant", "0"))
+	if err != nil || variant < engine.VariantStandard || variant > engine.VariantBanqi {
+		util.RespErrorWithData(ctx, 400, "create room error", "unknown variant")
+		return
+	}
+	tc, err := parseTimeControl(ctx.DefaultPostForm)
+	if err != nil {
+		util.RespErrorWithData(ctx, 400, "create room error", err.Error())
+		return
+	}
+	rated, err := strconv.ParseBool(ctx.DefaultPostForm("rated", "false"))
+	if err != nil {
+		util.RespErrorWithData(ctx, 400, "create room error", "rated must be true or false")
+		return
+	}
+	private, err := strconv.ParseBool(ctx.DefaultPostForm("private", "false"))
+	if err != nil {
+		util.RespErrorWithData(ctx, 400, "create room error", "private must be true or false")
+		return
+	}
+	password := ctx.PostForm("password")
+	if l := len([]rune(password)); l > 16 {
+		util.RespErrorWithData(ctx, 400, "create room error", "password must be at most 16 characters")
+		return
+	}
+
+	var roomId string
+	for i := 0; i < roomCodeRetries && roomId == ""; i++ {
+		code, err := newRoomCode()
+		if err != nil {
+			log.Println("new room code err:", err)
+			break
+		}
+		meta := model.RoomMeta{Owner: uuid, Private: private, CreatedAt: time.Now().UnixMilli()}
+		if password != "" {
+			meta.Password = hashPassword(code, password)
+		}
+		ok, err := redis.CreateRoom(code, meta)
+		if err != nil {
+			break
+		}
+		if ok {
+			roomId = code
+		}
+	}
+	if roomId == "" {
+		util.RespError(ctx, 400, "create room error")
+		return
+	}
+
+	err = redis.SetVariant(roomId, variant)
+	if err == nil {
+		err = redis.SetClock(roomId, tc)
+	}
+	if err == nil {
+		err = redis.SetRated(roomId, rated)
+	}
+	if err != nil {
+		util.RespError(ctx, 400, "set room error")
+		return
+	}
+	util.RespSuccessfulWithData(ctx, "create room successful", gin.H{"room_id": roomId})
+}
+
+// listRooms 大厅中的公开房间，可按变体和状态筛选，新建的排在前面
+func listRooms(ctx *gin.Context) {
+	variant := ctx.Query("variant")
+	status := ctx.Query("status")
+	ids, err := redis.RoomIds()
+	if err != nil {
+		util.RespError(ctx, 400, "get rooms error")
+		return
+	}
+	infos := make([]roomInfo, 0, len(ids))
+	for _, id := range ids {
+		info, ok, err := loadRoomInfo(id)
+		if err != nil {
+			util.RespError(ctx, 400, "get room error")
+			return
+		}
+		if !ok || info.Private {
+			continue
+		}
+		if variant != "" && variant != strconv.Itoa(info.Variant) {
+			continue
+		}
+		if status != "" && status != info.Status {
+			continue
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].CreatedAt != infos[j].CreatedAt {
+			return infos[i].CreatedAt > infos[j].CreatedAt
+		}
+		return infos[i].RoomId < infos[j].RoomId
+	})
+	if err := fillRoomNames(infos); err != nil {
+		util.RespError(ctx, 400, "select user error")
+		return
+	}
+	util.RespSuccessfulWithData(ctx, "get rooms successful", infos)
+}
+
+// getRoom 房间详情，私有房间知道房间号即可查看
+func getRoom(ctx *gin.Context) {
+	info, ok, err := loadRoomInfo(ctx.Param("room_id"))
+	if err != nil {
+		util.RespError(ctx, 400, "get room error")
+		return
+	}
+	if !ok {
+		util.RespErrorWithData(ctx, 400, "room error", "room does not exist")
+		return
+	}
+	infos := []roomInfo{info}
+	if err := fillRoomNames(infos); err != nil {
+		util.RespError(ctx, 400, "select user error")
+		return
+	}
+	util.RespSuccessfulWithData(ctx, "get room successful", infos[0])
+}
+
+// loadRoomInfo 从redis读取房间设置和座位，已清理或空置的临时房间返回false
+func loadRoomInfo(roomId string) (roomInfo, bool, error) {
+	alive, err := redis.IsAliveRoom(roomId)
+	if err != nil || !alive {
+		return roomInfo{}, false, err
+	}
+	meta, err := redis.GetRoomMeta(roomId)
+	if err != nil {
+		return roomInfo{}, false, err
+	}
+	members, err := redis.RoomMembers(roomId)
+	if err != nil {
+		return roomInfo{}, false, err
+	}
+	if len(members) == 0 && meta.CreatedAt == 0 {
+		return roomInfo{}, false, nil
+	}
+	ready, err := redis.ReadyMembers(roomId)
+	if err != nil {
+		return roomInfo{}, false, err
+	}
+	variant, err := redis.GetVariant(roomId)
+	if err != nil {
+		return roomInfo{}, false, err
+	}
+	tc, err := redis.GetClock(roomId)
+	if err != nil {
+		return roomInfo{}, false, err
+	}
+	rated, err := redis.GetRated(roomId)
+	if err != nil {
+		return roomInfo{}, false, err
+	}
+
+	info := roomInfo{
+		RoomId:      roomId,
+		Owner:       meta.Owner,
+		Variant:     variant,
+		TimeControl: tc,
+		Rated:       rated,
+		Private:     meta.Private,
+		HasPassword: meta.Password != "",
+		Locked:      meta.Locked,
+		Status:      model.RoomWaiting,
+		Players:     make([]roomPlayer, 0, len(members)),
+		CreatedAt:   meta.CreatedAt,
+	}
+	switch {
+	case meta.Playing:
+		info.Status = model.RoomPlaying
+	case len(members) >= 2:
+		info.Status = model.RoomFull
+	}
+	category := rating.Category(tc)
+	sort.Strings(members)
+	for _, uuid := range members {
+		p := roomPlayer{Uuid: uuid, Rating: math.Round(rating.Default().Rating), Ready: containsString(ready, uuid)}
+		if !isBot(uuid) {
+			r, ok, err := mysql.SelectRating(uuid, category)
+			if err != nil {
+				return roomInfo{}, false, err
+			}
+			if ok {
+				p.Rating = math.Round(r.Rating)
+			}
+		}
+		info.Players = append(info.Players, p)
+	}
+	return info, true, nil
+}
+
+// fillRoomNames 批量查询房主和选手的用户名
+func fillRoomNames(infos []roomInfo) error {
+	var uuids []string
+	for _, info := range infos {
+		if info.Owner != "" {
+			uuids = append(uuids, info.Owner)
+		}
+		for _, p := range info.Players {
+			uuids = append(uuids, p.Uuid)
+		}
+	}
+	if len(uuids) == 0 {
+		return nil
+	}
+	names, err := mysql.SelectUserNamesByUuids(uuids)
+	if err != nil {
+		return err
+	}
+	for i := range infos {
+		infos[i].OwnerName = names[infos[i].Owner]
+		for j := range infos[i].Players {
+			infos[i].Players[j].Name = displayName(names, infos[i].Players[j].Uuid)
+		}
+	}
+	return nil
+}
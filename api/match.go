@@ -0,0 +1,414 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	uuid "github.com/satori/go.uuid"
+	"go-chess/dao/mysql"
+	"go-chess/dao/redis"
+	"go-chess/model"
+	"go-chess/rating"
+	"go-chess/util"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	matchInterval = time.Second //撮合的间隔
+	baseWindow    = 100         //刚加入时可以匹配的等级分差
+	windowGrowth  = 10          //每等待一秒放宽的等级分差
+	maxWindow     = 400         //等级分差的上限
+	ticketRefresh = 5 * time.Second
+	ticketTTL     = 3 * ticketRefresh //超过这么久没有续期的票据视为持有连接的实例已经下线
+	maxMisses     = 2                 //连续几次续期时票据都不在队列中，说明已被清理，通知用户重新匹配
+	joinTimeout   = 30 * time.Second  //匹配成功后双方进入房间的时限，超时后释放座位，进了房间的一方重新排队
+)
+
+// waiter 本实例上等待匹配的一个连接和它在redis中的票据
+type waiter struct {
+	conn   *connection
+	ticket model.MatchTicket
+	misses int //连续续期失败的次数，匹配成功时连接会立即被取走，不会累计
+}
+
+// matchQueue 本实例上等待匹配的连接，匹配结果通过redis发布后由这里通知用户
+type matchQueue struct {
+	sync.Mutex
+	conns map[string]*waiter
+}
+
+var waiting = matchQueue{conns: make(map[string]*waiter)}
+
+func (q *matchQueue) add(c *connection, ticket model.MatchTicket) bool {
+	q.Lock()
+	defer q.Unlock()
+	if q.conns[c.uuid] != nil {
+		return false
+	}
+	q.conns[c.uuid] = &waiter{conn: c, ticket: ticket}
+	return true
+}
+
+// remove 连接仍在等待时移出，返回false说明已经匹配成功或被移出过
+func (q *matchQueue) remove(c *connection) bool {
+	q.Lock()
+	defer q.Unlock()
+	if w := q.conns[c.uuid]; w == nil || w.conn != c {
+		return false
+	}
+	delete(q.conns, c.uuid)
+	return true
+}
+
+func (q *matchQueue) take(uuid string) *connection {
+	q.Lock()
+	defer q.Unlock()
+	w := q.conns[uuid]
+	if w == nil {
+		return nil
+	}
+	delete(q.conns, uuid)
+	return w.conn
+}
+
+func (q *matchQueue) tickets() []model.MatchTicket {
+	q.Lock()
+	defer q.Unlock()
+	tickets := make([]model.MatchTicket, 0, len(q.conns))
+	for _, w := range q.conns {
+		tickets = append(tickets, w.ticket)
+	}
+	return tickets
+}
+
+// missed 记录一次续期结果，连续失败maxMisses次时移出并返回连接
+func (q *matchQueue) missed(uuid string, ok bool) *connection {
+	q.Lock()
+	defer q.Unlock()
+	w := q.conns[uuid]
+	if w == nil {
+		return nil
+	}
+	if ok {
+		w.misses = 0
+		return nil
+	}
+	w.misses++
+	if w.misses < maxMisses {
+		return nil
+	}
+	delete(q.conns, uuid)
+	return w.conn
+}
+
+// matchWs 以WebSocket加入匹配队列，连接保持到匹配成功或客户端断开
+func matchWs(ctx *gin.Context) {
+	Iuuid, _ := ctx.Get("uuid")
+	uid := Iuuid.(string)
+	tc, err := parseTimeControl(ctx.DefaultQuery)
+	if err != nil {
+		util.RespErrorWithData(ctx, 400, "match error", err.Error())
+		return
+	}
+	rated, err := strconv.ParseBool(ctx.DefaultQuery("rated", "false"))
+	if err != nil {
+		util.RespErrorWithData(ctx, 400, "match error", "rated must be true or false")
+		return
+	}
+	name, err := mysql.SelectUserNameByUUId(uid)
+	if err != nil {
+		util.RespError(ctx, 400, "select user error")
+		return
+	}
+	r, ok, err := mysql.SelectRating(uid, rating.Category(tc))
+	if err != nil {
+		util.RespError(ctx, 400, "select rating error")
+		return
+	}
+	if !ok {
+		r.Rating = rating.Default().Rating
+	}
+
+	ws, err := upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
+	if err != nil {
+		log.Println("upgrade err:", err)
+		return
+	}
+	c := &connection{send: make(chan []byte, 8), ws: ws, name: name, uuid: uid}
+	m := message{conn: c}
+	go m.writePump()
+	now := time.Now().UnixMilli()
+	ticket := model.MatchTicket{
+		Uuid:        uid,
+		Name:        name,
+		TimeControl: tc,
+		Rated:       rated,
+		Rating:      r.Rating,
+		JoinedAt:    now,
+		SeenAt:      now,
+	}
+	if !waiting.add(c, ticket) {
+		sendTo(c, encodeFrame(TypeError, 0, "", errorPayload{ErrInQueue, "already waiting for a match", 0}))
+		close(c.send)
+		return
+	}
+	if err := redis.JoinMatch(ticket); err != nil {
+		waiting.remove(c)
+		sendTo(c, encodeFrame(TypeError, 0, "", errorPayload{ErrMatchFailed, "join match queue error", 0}))
+		close(c.send)
+		return
+	}
+	sendTo(c, encodeFrame(TypeMatchQueued, 0, "", matchQueuedPayload{tc, rated, math.Round(r.Rating)}))
+	go c.waitMatch()
+}
+
+// waitMatch 客户端断开时离开匹配队列，等待期间客户端不需要发送消息
+func (c *connection) waitMatch() {
+	c.ws.SetReadLimit(maxMessageSize)
+	_ = c.ws.SetReadDeadline(time.Now().Add(pongWait))
+	c.ws.SetPongHandler(func(string) error {
+		return c.ws.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	for {
+		if _, _, err := c.ws.ReadMessage(); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway) {
+				log.Println("unexpected close error:", err)
+			}
+			break
+		}
+	}
+	if waiting.remove(c) {
+		_ = redis.LeaveMatch(c.uuid)
+		close(c.send)
+	}
+}
+
+// matchLoop 定时撮合队列，并把匹配结果通知本实例上等待的用户
+func matchLoop() {
+	go func() {
+		for match := range redis.SubscribeMatch() {
+			notifyMatch(match)
+		}
+	}()
+	go refreshTickets()
+	ticker := time.NewTicker(matchInterval)
+	for now := range ticker.C {
+		ok, err := redis.LockMatch(matchInterval - 100*time.Millisecond)
+		if err != nil || !ok {
+			continue
+		}
+		tickets, err := redis.MatchTickets()
+		if err != nil {
+			continue
+		}
+		pairs, stale := pairTickets(tickets, now)
+		for _, t := range stale {
+			_ = redis.LeaveMatch(t.Uuid)
+		}
+		for _, pair := range pairs {
+			startMatch(pair[0], pair[1], now)
+		}
+		expireMatches(now)
+	}
+}
+
+// refreshTickets 定时为本实例上等待的用户续期票据，实例下线后它的票据会过期被清理
+func refreshTickets() {
+	ticker := time.NewTicker(ticketRefresh)
+	for now := range ticker.C {
+		for _, t := range waiting.tickets() {
+			t.SeenAt = now.UnixMilli()
+			ok, err := redis.TouchMatch(t)
+			if err != nil {
+				continue
+			}
+			if c := waiting.missed(t.Uuid, ok); c != nil {
+				sendTo(c, encodeFrame(TypeError, 0, "", errorPayload{ErrMatchFailed, "match ticket expired", 0}))
+				close(c.send)
+			}
+		}
+	}
+}
+
+// matchWindow 可以匹配的等级分差，随等待时间放宽
+func matchWindow(wait time.Duration) float64 {
+	window := baseWindow + windowGrowth*wait.Seconds()
+	return math.Min(window, maxWindow)
+}
+
+// pairTickets 等待最久的用户优先，在用时和计分设置相同、等级分差在其范围内的用户中找分差最小的；
+// 超过ticketTTL没有续期的票据不参与撮合，作为stale返回由调用方清理
+func pairTickets(tickets []model.MatchTicket, now time.Time) (pairs [][2]model.MatchTicket, stale []model.MatchTicket) {
+	live := tickets[:0]
+	for _, t := range tickets {
+		if now.Sub(time.UnixMilli(t.SeenAt)) > ticketTTL {
+			stale = append(stale, t)
+			continue
+		}
+		live = append(live, t)
+	}
+	tickets = live
+	sort.Slice(tickets, func(i, j int) bool {
+		return tickets[i].JoinedAt < tickets[j].JoinedAt
+	})
+	paired := make([]bool, len(tickets))
+	for i, a := range tickets {
+		if paired[i] {
+			continue
+		}
+		window := matchWindow(now.Sub(time.UnixMilli(a.JoinedAt)))
+		best := -1
+		for j := i + 1; j < len(tickets); j++ {
+			b := tickets[j]
+			if paired[j] || b.TimeControl != a.TimeControl || b.Rated != a.Rated {
+				continue
+			}
+			diff := math.Abs(a.Rating - b.Rating)
+			if diff > window {
+				continue
+			}
+			if best == -1 || diff < math.Abs(a.Rating-tickets[best].Rating) {
+				best = j
+			}
+		}
+		if best != -1 {
+			paired[i], paired[best] = true, true
+			pairs = append(pairs, [2]model.MatchTicket{a, tickets[best]})
+		}
+	}
+	return pairs, stale
+}
+
+// startMatch 为匹配成功的双方新建房间并占好座位，然后发布匹配结果
+func startMatch(a, b model.MatchTicket, now time.Time) {
+	ok, err := redis.TakeMatch(a.Uuid, b.Uuid)
+	if err != nil || !ok {
+		return
+	}
+	roomId := uuid.NewV4().String()
+	err = createMatchRoom(roomId, a, b, now.Add(joinTimeout))
+	if err != nil {
+		//建房失败时放回队列，下次重新撮合
+		_ = redis.JoinMatch(a)
+		_ = redis.JoinMatch(b)
+		return
+	}
+	_ = redis.PublishMatch(model.Match{
+		RoomId:      roomId,
+		Uuids:       [2]string{a.Uuid, b.Uuid},
+		Names:       [2]string{a.Name, b.Name},
+		Ratings:     [2]float64{a.Rating, b.Rating},
+		TimeControl: a.TimeControl,
+		Rated:       a.Rated,
+	})
+}
+
+// createMatchRoom 新建匹配的房间，双方需要在deadline之前进入
+func createMatchRoom(roomId string, a, b model.MatchTicket, deadline time.Time) error {
+	if err := redis.AddRoomId(roomId); err != nil {
+		return err
+	}
+	for _, uid := range []string{a.Uuid, b.Uuid} {
+		if err := redis.AddRoom(roomId, uid); err != nil {
+			return err
+		}
+	}
+	if err := redis.SetClock(roomId, a.TimeControl); err != nil {
+		return err
+	}
+	if err := redis.SetRated(roomId, a.Rated); err != nil {
+		return err
+	}
+	pending := model.PendingMatch{RoomId: roomId, Tickets: [2]model.MatchTicket{a, b}, Deadline: deadline.UnixMilli()}
+	if err := redis.AddPendingMatch(pending); err != nil {
+		return err
+	}
+	return redis.SetRoomMatched(roomId) //没有房主，锁定设置以免一方在对手进房前改掉匹配条件
+}
+
+// expireMatches 超过joinTimeout还有人没进房间的匹配作废：释放双方的座位，进了房间的一方重新排队
+func expireMatches(now time.Time) {
+	pendings, err := redis.PendingMatches()
+	if err != nil {
+		return
+	}
+	for _, p := range pendings {
+		if now.UnixMilli() < p.Deadline {
+			continue
+		}
+		arrived, ok, err := redis.TakePendingMatch(p.RoomId)
+		if err != nil || !ok {
+			continue
+		}
+		for _, t := range p.Tickets {
+			_ = redis.DeleteUser(p.RoomId, t.Uuid)
+			_ = redis.CancelReady(p.RoomId, t.Uuid)
+		}
+		for _, t := range p.Tickets {
+			for _, uid := range arrived {
+				if uid == t.Uuid {
+					t.SeenAt = now.UnixMilli()
+					sendRequeue(p.RoomId, t)
+				}
+			}
+		}
+	}
+}
+
+// postRequeue 把进了房间的一方交给房间放回匹配队列，选手已经离开时房间可能不在了，不用再排队
+func postRequeue(roomId string, t model.MatchTicket) {
+	hubs.post(roomId, false, func(h *roomHub) {
+		h.requeue(t)
+	})
+}
+
+// requeue 对手没有按时进入房间，选手的连接离开房间、回到匹配队列，保留原来的等待时间，下次匹配成功后关闭
+func (h *roomHub) requeue(t model.MatchTicket) {
+	h.unready(t.Uuid)
+	c := h.seatedConn(t.Uuid, nil)
+	if c == nil || h.game != nil {
+		return
+	}
+	delete(h.conns, c)
+	delMsg := "系统消息：" + c.name + "离开了" + h.id + "聊天室"
+	h.sendRoom(h.frame(TypeSystem, systemPayload{EventLeave, delMsg}))
+	h.dropBots()
+	//先发完提示再交给队列，之后连接由队列关闭
+	sendSystem(c, h.id, EventRequeued, "对手没有进入房间，已重新加入匹配队列")
+	if !waiting.add(c, t) {
+		//本实例上已经有这个用户的匹配连接
+		close(c.send)
+		return
+	}
+	sendTo(c, encodeFrame(TypeMatchQueued, 0, "", matchQueuedPayload{t.TimeControl, t.Rated, math.Round(t.Rating)}))
+	writeRoom(h.id, func() {
+		if err := redis.JoinMatch(t); err != nil {
+			if waiting.remove(c) {
+				sendTo(c, encodeFrame(TypeError, 0, "", errorPayload{ErrMatchFailed, "join match queue error", 0}))
+				close(c.send)
+			}
+		}
+	})
+}
+
+// notifyMatch 通知本实例上等待的用户匹配成功，之后用户用room_id加入房间
+func notifyMatch(match model.Match) {
+	for i, uid := range match.Uuids {
+		c := waiting.take(uid)
+		if c == nil {
+			continue
+		}
+		sendTo(c, encodeFrame(TypeMatched, 0, match.RoomId, matchedPayload{
+			RoomId:         match.RoomId,
+			Opponent:       match.Names[1-i],
+			OpponentRating: math.Round(match.Ratings[1-i]),
+			TimeControl:    match.TimeControl,
+			Rated:          match.Rated,
+		}))
+		close(c.send)
+	}
+}
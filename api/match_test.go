@@ -0,0 +1,177 @@
+package api
+
+import (
+	"bytes"
+	"go-chess/model"
+	"testing"
+	"time"
+)
+
+func TestPairTickets(t *testing.T) {
+	now := time.UnixMilli(1_000_000_000)
+	blitz := model.TimeControl{Mode: model.ClockFischer, Base: 300, Increment: 3}
+	rapid := model.TimeControl{Mode: model.ClockFischer, Base: 900, Increment: 10}
+	ticket := func(uid string, tc model.TimeControl, rated bool, r float64, waited time.Duration) model.MatchTicket {
+		at := now.Add(-waited).UnixMilli()
+		return model.MatchTicket{Uuid: uid, TimeControl: tc, Rated: rated, Rating: r, JoinedAt: at, SeenAt: now.UnixMilli()}
+	}
+	tests := []struct {
+		name    string
+		tickets []model.MatchTicket
+		pairs   [][2]string
+		stale   []string
+	}{
+		{
+			name:    "empty",
+			tickets: nil,
+		},
+		{
+			name: "closest rating wins",
+			tickets: []model.MatchTicket{
+				ticket("a", blitz, true, 1500, 0),
+				ticket("b", blitz, true, 1580, 0),
+				ticket("c", blitz, true, 1520, 0),
+			},
+			pairs: [][2]string{{"a", "c"}},
+		},
+		{
+			name: "longest waiting goes first",
+			tickets: []model.MatchTicket{
+				ticket("a", blitz, true, 1500, time.Second),
+				ticket("b", blitz, true, 1530, 5*time.Second),
+				ticket("c", blitz, true, 1540, 0),
+			},
+			pairs: [][2]string{{"b", "c"}},
+		},
+		{
+			name: "different settings never pair",
+			tickets: []model.MatchTicket{
+				ticket("a", blitz, true, 1500, 0),
+				ticket("b", rapid, true, 1500, 0),
+				ticket("c", blitz, false, 1500, 0),
+			},
+		},
+		{
+			name: "window grows with waiting time",
+			tickets: []model.MatchTicket{
+				ticket("a", blitz, true, 1500, 0),
+				ticket("b", blitz, true, 1650, 0),
+			},
+		},
+		{
+			name: "wider window after waiting",
+			tickets: []model.MatchTicket{
+				ticket("a", blitz, true, 1500, 10*time.Second),
+				ticket("b", blitz, true, 1650, 0),
+			},
+			pairs: [][2]string{{"a", "b"}},
+		},
+		{
+			name: "window is capped",
+			tickets: []model.MatchTicket{
+				ticket("a", blitz, true, 1500, time.Hour),
+				ticket("b", blitz, true, 1950, 0),
+			},
+		},
+		{
+			name: "stale tickets are dropped",
+			tickets: []model.MatchTicket{
+				ticket("a", blitz, true, 1500, 0),
+				func() model.MatchTicket {
+					t := ticket("b", blitz, true, 1500, time.Minute)
+					t.SeenAt = now.Add(-ticketTTL - time.Second).UnixMilli()
+					return t
+				}(),
+				ticket("c", blitz, true, 1510, 0),
+			},
+			pairs: [][2]string{{"a", "c"}},
+			stale: []string{"b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pairs, stale := pairTickets(tt.tickets, now)
+			if len(pairs) != len(tt.pairs) {
+				t.Fatalf("got %d pairs, want %d", len(pairs), len(tt.pairs))
+			}
+			for i, p := range pairs {
+				if p[0].Uuid != tt.pairs[i][0] || p[1].Uuid != tt.pairs[i][1] {
+					t.Errorf("pair %d = %s-%s, want %s-%s", i, p[0].Uuid, p[1].Uuid, tt.pairs[i][0], tt.pairs[i][1])
+				}
+			}
+			if len(stale) != len(tt.stale) {
+				t.Fatalf("got %d stale tickets, want %d", len(stale), len(tt.stale))
+			}
+			for i, s := range stale {
+				if s.Uuid != tt.stale[i] {
+					t.Errorf("stale %d = %s, want %s", i, s.Uuid, tt.stale[i])
+				}
+			}
+		})
+	}
+}
+
+// TestRequeueAfterJoinTimeout 对手超时没有进入匹配的房间，进了房间的选手离开房间回到匹配队列
+func TestRequeueAfterJoinTimeout(t *testing.T) {
+	const roomId = "requeue-room"
+	early := &connection{send: make(chan []byte, 16), name: "early", uuid: "uuid-early"} //测试中读取收到的消息
+	viewer := newTestConn("viewer")
+	viewer.spectator = true
+	joinRoom(message{nil, roomId, early.name, early, ""})
+	joinRoom(message{nil, roomId, viewer.name, viewer, ""})
+	waitRoom(t, roomId)
+	for len(early.send) > 0 {
+		<-early.send
+	}
+
+	ticket := model.MatchTicket{Uuid: early.uuid, Name: early.name, Rating: 1500, JoinedAt: 1, SeenAt: 2}
+	postRequeue(roomId, ticket)
+	//没进房间的选手什么也不做
+	postRequeue(roomId, model.MatchTicket{Uuid: "uuid-absent"})
+	inspect(t, roomId, func(h *roomHub) {
+		if h.conns[early] {
+			t.Error("requeued connection is still in the room")
+		}
+		//回到队列的连接发来的聊天不再转发给房间
+		h.chat(message{[]byte("hi"), roomId, early.name, early, ""})
+		if len(h.chats) != 0 {
+			t.Error("chat from a requeued connection reached the room")
+		}
+	})
+	waiting.Lock()
+	w, absent := waiting.conns[early.uuid], waiting.conns["uuid-absent"]
+	waiting.Unlock()
+	if w == nil || w.conn != early || w.ticket != ticket {
+		t.Fatal("connection is not waiting with its ticket")
+	}
+	if absent != nil {
+		t.Error("player who never joined was queued")
+	}
+	var got []string
+	for len(early.send) > 0 {
+		data := <-early.send
+		for _, typ := range []string{EventRequeued, TypeMatchQueued} {
+			if bytes.Contains(data, []byte(`"`+typ+`"`)) {
+				got = append(got, typ)
+			}
+		}
+	}
+	if len(got) != 2 || got[0] != EventRequeued || got[1] != TypeMatchQueued {
+		t.Errorf("early player got %v, want requeued notice then match_queued", got)
+	}
+
+	//断开时离开队列并关闭连接
+	hubs.post(roomId, false, func(h *roomHub) {
+		h.leave(early)
+	})
+	waitRoom(t, roomId)
+	if waiting.remove(early) {
+		t.Error("connection is still waiting after it dropped")
+	}
+	if _, ok := <-early.send; ok {
+		t.Error("send queue of the dropped connection is still open")
+	}
+
+	leaveRoom(message{nil, roomId, viewer.name, viewer, ""})
+	waitGone(t, roomId)
+}
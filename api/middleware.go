@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
+	"go-chess/global"
 	"go-chess/rpc/user/model"
 	"go-chess/util"
 )
@@ -48,3 +49,17 @@ func CORS() gin.HandlerFunc {
 		ctx.Next()
 	}
 }
+
+// AdminAuth 只允许配置文件中的管理员访问，需放在JWTAuth之后
+func AdminAuth(ctx *gin.Context) {
+	Iuuid, _ := ctx.Get("uuid")
+	uuid, _ := Iuuid.(string)
+	for _, admin := range global.Settings.ModerationInfo.Admins {
+		if admin == uuid {
+			ctx.Next()
+			return
+		}
+	}
+	util.RespErrorWithData(ctx, 403, "admin error", "you are not an admin")
+	ctx.Abort()
+}
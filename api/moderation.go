@@ -0,0 +1,136 @@
+package api
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go-chess/dao/mysql"
+	"go-chess/dao/redis"
+	"go-chess/global"
+	"go-chess/util"
+	"log"
+	"time"
+)
+
+// checkPenalty 禁止聊天或禁言期间提示用户，返回是否可以发言；redis出错时放行，不影响正常聊天
+func checkPenalty(m message) bool {
+	c := m.conn
+	p, err := redis.GetPenalty(c.uuid)
+	if err != nil {
+		return true
+	}
+	var event, text string
+	switch {
+	case p.BanLeft > 0:
+		event, text = EventBanned, fmt.Sprintf("您已被禁止聊天，%s后解除！！！", leftText(p.BanLeft))
+	case p.MuteLeft > 0:
+		event, text = EventMuted, fmt.Sprintf("您还在禁言中,%s后才能发送信息！！！", leftText(p.MuteLeft))
+	default:
+		return true
+	}
+	hubs.post(m.roomId, false, func(h *roomHub) {
+		h.notify(c, event, text)
+	})
+	return false
+}
+
+// punish 记一次违规并按次数升级处罚：禁言，踢出房间，禁止聊天
+func punish(m message) {
+	c := m.conn
+	cfg := global.Settings.ModerationInfo
+	strikes, err := redis.AddStrike(c.uuid, time.Duration(cfg.StrikeWindow)*time.Second)
+	if err != nil {
+		return
+	}
+	mute := time.Duration(cfg.Mute) * time.Second
+	switch {
+	case strikes >= int64(cfg.BanStrikes):
+		ban := time.Duration(cfg.Ban) * time.Second
+		if redis.BanChat(c.uuid, ban) != nil {
+			return
+		}
+		log.Println("chat banned", c.name, strikes)
+		text := fmt.Sprintf("由于您多次发送不合法信息,已被禁止聊天%s！！！", leftText(int64(cfg.Ban)))
+		hubs.post(m.roomId, false, func(h *roomHub) {
+			h.notify(c, EventBanned, text)
+			h.kickout(c)
+		})
+	case strikes >= int64(cfg.KickStrikes):
+		if redis.MuteUser(c.uuid, mute) != nil {
+			return
+		}
+		log.Println("素质太低，给你踢出去", c.name, strikes)
+		hubs.post(m.roomId, false, func(h *roomHub) {
+			h.kickout(c)
+		})
+	default:
+		if redis.MuteUser(c.uuid, mute) != nil {
+			return
+		}
+		text := fmt.Sprintf("警告:您发布不合法信息，将禁言%s，第%d次将被踢出房间，第%d次将被禁止聊天！！！",
+			leftText(int64(cfg.Mute)), cfg.KickStrikes, cfg.BanStrikes)
+		hubs.post(m.roomId, false, func(h *roomHub) {
+			h.notify(c, EventWarning, text)
+		})
+	}
+}
+
+// leftText 剩余时间，按天、小时、分钟、秒中最大的单位显示
+func leftText(sec int64) string {
+	switch {
+	case sec >= 86400:
+		return fmt.Sprintf("%d天", (sec+86399)/86400)
+	case sec >= 3600:
+		return fmt.Sprintf("%d小时", (sec+3599)/3600)
+	case sec >= 60:
+		return fmt.Sprintf("%d分钟", (sec+59)/60)
+	}
+	return fmt.Sprintf("%d秒", sec)
+}
+
+// listPenalties 管理员查看所有还有违规记录或处罚的用户
+func listPenalties(ctx *gin.Context) {
+	penalties, err := redis.ListPenalties()
+	if err != nil {
+		util.RespError(ctx, 400, "list penalties error")
+		return
+	}
+	uuids := make([]string, 0, len(penalties))
+	for _, p := range penalties {
+		uuids = append(uuids, p.Uuid)
+	}
+	names, err := mysql.SelectUserNamesByUuids(uuids)
+	if err != nil {
+		util.RespError(ctx, 400, "select user error")
+		return
+	}
+	for i := range penalties {
+		penalties[i].Name = names[penalties[i].Uuid]
+	}
+	util.RespSuccessfulWithData(ctx, "list penalties successful", penalties)
+}
+
+// getPenalty 管理员查看一名用户的违规记录和处罚
+func getPenalty(ctx *gin.Context) {
+	uuid := ctx.Param("uuid")
+	p, err := redis.GetPenalty(uuid)
+	if err != nil {
+		util.RespError(ctx, 400, "get penalty error")
+		return
+	}
+	p.Name, err = userName(uuid)
+	if err != nil {
+		util.RespError(ctx, 400, "select user error")
+		return
+	}
+	util.RespSuccessfulWithData(ctx, "get penalty successful", p)
+}
+
+// liftPenalty 管理员解除一名用户的禁言、禁止聊天并清空违规次数
+func liftPenalty(ctx *gin.Context) {
+	err := redis.LiftPenalty(ctx.Param("uuid"))
+	if err != nil {
+		util.RespError(ctx, 400, "lift penalty error")
+		return
+	}
+	util.RespSuccessful(ctx, "lift penalty successful")
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"errors"
+	"go-chess/engine"
+	"time"
+)
+
+const (
+	maxOffers     = 3                //每局每方最多提和、悔棋的次数
+	offerInterval = 30 * time.Second //同一方两次提和或悔棋的最短间隔
+)
+
+// offerLimit 提和、悔棋的频率限制
+type offerLimit struct {
+	count int
+	last  time.Time
+}
+
+// allow 检查是否还能发起请求，可以时记下这次请求
+func (l *offerLimit) allow(now time.Time) bool {
+	if l.count >= maxOffers || now.Sub(l.last) < offerInterval {
+		return false
+	}
+	l.count++
+	l.last = now
+	return true
+}
+
+// resign 认输，对方获胜
+func (h *roomHub) resign(r request) {
+	g, sd := h.player(r)
+	if g == nil {
+		return
+	}
+	h.endGame(1-sd, engine.ReasonResign)
+}
+
+// offerDraw 提和，对方已经提和时直接成和
+func (h *roomHub) offerDraw(r request) {
+	g, sd := h.player(r)
+	if g == nil {
+		return
+	}
+	if g.drawOffer == 1-sd {
+		h.endGame(engine.WinnerDraw, engine.ReasonAgreed)
+		return
+	}
+	if g.drawOffer == sd {
+		sendError(r, ErrOfferPending, "draw offer is pending")
+		return
+	}
+	if !g.drawLimits[sd].allow(time.Now()) {
+		sendError(r, ErrRateLimited, "too many draw offers")
+		return
+	}
+	g.drawOffer = sd
+	h.sendRoom(h.frame(TypeDrawOffer, offerPayload{r.name, sd}))
+	h.botReply(g, 1-sd, TypeDrawReply, false)
+}
+
+// replyDraw 答复对方的提和
+func (h *roomHub) replyDraw(r request) {
+	g, sd := h.player(r)
+	if g == nil {
+		return
+	}
+	if g.drawOffer != 1-sd {
+		sendError(r, ErrNoOffer, "no draw offer to reply")
+		return
+	}
+	g.drawOffer = -1
+	h.sendRoom(h.frame(TypeDrawReply, replyPayload{r.name, r.accept}))
+	if r.accept {
+		h.endGame(engine.WinnerDraw, engine.ReasonAgreed)
+	}
+}
+
+// offerTakeback 请求悔棋，悔掉自己的上一步，已轮到自己走时连同对方的应着一起悔
+func (h *roomHub) offerTakeback(r request) {
+	g, sd := h.player(r)
+	if g == nil {
+		return
+	}
+	if g.takebackPlies(sd) == 0 {
+		sendError(r, ErrNoTakeback, "nothing to take back")
+		return
+	}
+	if g.takebackOffer >= 0 {
+		sendError(r, ErrOfferPending, "takeback request is pending")
+		return
+	}
+	if !g.takebackLimits[sd].allow(time.Now()) {
+		sendError(r, ErrRateLimited, "too many takeback requests")
+		return
+	}
+	g.takebackOffer = sd
+	h.sendRoom(h.frame(TypeTakebackOffer, offerPayload{r.name, sd}))
+	h.botReply(g, 1-sd, TypeTakebackReply, true)
+}
+
+// replyTakeback 答复对方的悔棋请求，同意后在服务端的局面上悔棋
+func (h *roomHub) replyTakeback(r request) {
+	g, sd := h.player(r)
+	if g == nil {
+		return
+	}
+	if g.takebackOffer != 1-sd {
+		sendError(r, ErrNoOffer, "no takeback request to reply")
+		return
+	}
+	g.takebackOffer = -1
+	h.sendRoom(h.frame(TypeTakebackReply, replyPayload{r.name, r.accept}))
+	if !r.accept {
+		return
+	}
+
+	now := time.Now()
+	if g.clock.left(now) <= 0 {
+		h.flagFall(g)
+		return
+	}
+	plies := g.takebackPlies(1 - sd)
+	if err := g.replay(len(g.moves) - plies); err != nil {
+		sendError(r, ErrNoTakeback, err.Error())
+		return
+	}
+	g.drawOffer = -1
+	recordTakeback(g)
+	g.clock.rewind(g.game.Side(), now)
+	h.armClock(g, now)
+	h.sendRoom(h.frame(TypeTakeback, takebackPayload{
+		Plies: plies,
+		Fen:   g.game.Fen(),
+		Side:  g.game.Side(),
+		Moves: g.moves,
+		Clock: g.clock.snapshot(now),
+	}))
+	h.botTurn(g)
+}
+
+// takebackPlies sd方悔棋要退回的步数，没有可悔的着法时返回0
+func (g *roomGame) takebackPlies(sd int) int {
+	plies := 1
+	if g.game.Side() == sd {
+		plies = 2
+	}
+	//红方先走，第n步(从0开始)是n%2方走的
+	if len(g.moves) < plies || (len(g.moves)-plies)%2 != sd {
+		return 0
+	}
+	return plies
+}
+
+// replay 按开局设置重新开局并走到第n步
+func (g *roomGame) replay(n int) error {
+	game, err := engine.NewGame(g.variant, g.handicap, g.removed, g.seed)
+	if err != nil {
+		return err
+	}
+	for _, mv := range g.moves[:n] {
+		if !game.PlayMove(mv) {
+			return errors.New("replay move " + mv + " failed")
+		}
+	}
+	g.game = game
+	g.moves = g.moves[:n]
+	return nil
+}
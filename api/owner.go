@@ -0,0 +1,245 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"go-chess/dao/mysql"
+	"go-chess/dao/redis"
+	"go-chess/model"
+	"go-chess/util"
+	"log"
+	"strconv"
+)
+
+// ownerEvent 房主操作和房间设置变化，由房间执行踢人并通知房间里的人
+type ownerEvent struct {
+	roomId string
+	event  string
+	uuid   string //被踢出的用户
+	text   string
+}
+
+// ownerOnly 只有房主可以操作，返回房间信息
+func ownerOnly(ctx *gin.Context, roomId, uuid, desc string) (model.RoomMeta, bool) {
+	meta, err := redis.GetRoomMeta(roomId)
+	if err != nil {
+		util.RespError(ctx, 400, "get room error")
+		return meta, false
+	}
+	if meta.Owner == "" || meta.Owner != uuid {
+		util.RespErrorWithData(ctx, 400, desc, "you are not the room owner")
+		return meta, false
+	}
+	return meta, true
+}
+
+// checkRoomSetter 有房主的房间只有房主能修改设置，没有房主的房间由选手修改；匹配的房间和对局中不能修改
+func checkRoomSetter(ctx *gin.Context, roomId, uuid, desc string) bool {
+	meta, err := redis.GetRoomMeta(roomId)
+	if err != nil {
+		util.RespError(ctx, 400, "get room error")
+		return false
+	}
+	if meta.Matched {
+		util.RespErrorWithData(ctx, 400, desc, "cannot change settings of a matched room")
+		return false
+	}
+	if meta.Owner != "" {
+		if meta.Owner != uuid {
+			util.RespErrorWithData(ctx, 400, desc, "only the room owner can change settings")
+			return false
+		}
+	} else {
+		flag, err := redis.IsInRoom(roomId, uuid)
+		if err != nil {
+			log.Println(err)
+			util.RespError(ctx, 400, "judge in the room err")
+			return false
+		}
+		if !flag {
+			util.RespErrorWithData(ctx, 400, desc, "you are not in the room")
+			return false
+		}
+	}
+	if meta.Playing {
+		util.RespErrorWithData(ctx, 400, desc, "cannot change settings during a game")
+		return false
+	}
+	return true
+}
+
+// notifySettings 设置修改后双方需要重新准备，避免按旧设置准备后直接开局
+func notifySettings(roomId, setting string) {
+	sendOwnerEvent(ownerEvent{roomId: roomId, event: EventSettings, text: "系统消息：房间的" + setting + "已修改，请重新准备"})
+}
+
+// userName 用户名，AI不在用户表中
+func userName(uuid string) (string, error) {
+	names, err := mysql.SelectUserNamesByUuids([]string{uuid})
+	if err != nil {
+		return "", err
+	}
+	return displayName(names, uuid), nil
+}
+
+// kickUser 房主把选手或观众踢出房间，被踢出的用户不能再进入；对局中不能踢选手
+func kickUser(ctx *gin.Context) {
+	Iuuid, _ := ctx.Get("uuid")
+	uuid := Iuuid.(string)
+	roomId := ctx.Param("room_id")
+	target := ctx.PostForm("uuid")
+	if target == "" || target == uuid {
+		util.RespErrorWithData(ctx, 400, "kick error", "uuid must be another user")
+		return
+	}
+	meta, ok := ownerOnly(ctx, roomId, uuid, "kick error")
+	if !ok {
+		return
+	}
+	seated, err := redis.IsInRoom(roomId, target)
+	if err != nil {
+		util.RespError(ctx, 400, "judge in the room err")
+		return
+	}
+	if seated && meta.Playing {
+		util.RespErrorWithData(ctx, 400, "kick error", "cannot kick a player during a game")
+		return
+	}
+	name, err := userName(target)
+	if err != nil {
+		util.RespError(ctx, 400, "select user error")
+		return
+	}
+
+	err = redis.BanUser(roomId, target)
+	if err != nil {
+		util.RespError(ctx, 400, "ban user error")
+		return
+	}
+	sendOwnerEvent(ownerEvent{roomId, EventKicked, target, "系统消息：" + name + "被房主踢出房间"})
+	util.RespSuccessful(ctx, "kick successful")
+}
+
+// lockRoom 房主锁定或解锁房间
+func lockRoom(ctx *gin.Context) {
+	Iuuid, _ := ctx.Get("uuid")
+	uuid := Iuuid.(string)
+	roomId := ctx.Param("room_id")
+	locked, err := strconv.ParseBool(ctx.DefaultPostForm("locked", "true"))
+	if err != nil {
+		util.RespErrorWithData(ctx, 400, "lock error", "locked must be true or false")
+		return
+	}
+	if _, ok := ownerOnly(ctx, roomId, uuid, "lock error"); !ok {
+		return
+	}
+
+	err = redis.SetRoomLocked(roomId, locked)
+	if err != nil {
+		util.RespError(ctx, 400, "set locked error")
+		return
+	}
+	text := "系统消息：房主解锁了房间"
+	if locked {
+		text = "系统消息：房主锁定了房间，新用户不能进入"
+	}
+	sendOwnerEvent(ownerEvent{roomId: roomId, event: EventLocked, text: text})
+	util.RespSuccessful(ctx, "set locked successful")
+}
+
+// transferOwner 房主把房间转给房间里的另一名选手
+func transferOwner(ctx *gin.Context) {
+	Iuuid, _ := ctx.Get("uuid")
+	uuid := Iuuid.(string)
+	roomId := ctx.Param("room_id")
+	target := ctx.PostForm("uuid")
+	if target == "" || target == uuid || isBot(target) {
+		util.RespErrorWithData(ctx, 400, "transfer error", "uuid must be another player")
+		return
+	}
+	if _, ok := ownerOnly(ctx, roomId, uuid, "transfer error"); !ok {
+		return
+	}
+	flag, err := redis.IsInRoom(roomId, target)
+	if err != nil {
+		util.RespError(ctx, 400, "judge in the room err")
+		return
+	}
+	if !flag {
+		util.RespErrorWithData(ctx, 400, "transfer error", "the user is not a player in the room")
+		return
+	}
+	name, err := userName(target)
+	if err != nil {
+		util.RespError(ctx, 400, "select user error")
+		return
+	}
+
+	err = redis.SetRoomOwner(roomId, target)
+	if err != nil {
+		util.RespError(ctx, 400, "set owner error")
+		return
+	}
+	sendOwnerEvent(ownerEvent{roomId: roomId, event: EventOwner, text: "系统消息：" + name + "成为房主"})
+	util.RespSuccessful(ctx, "transfer owner successful")
+}
+
+// ownerAction 房间执行房主操作
+func (h *roomHub) ownerAction(e ownerEvent) {
+	switch e.event {
+	case EventKicked:
+		h.kick(e)
+		return
+	case EventSettings:
+		h.ready = nil
+		roomId := h.id
+		writeRoom(roomId, func() {
+			err := redis.ResetReady(roomId)
+			if err != nil {
+				log.Println(err)
+			}
+		})
+		for _, con := range h.players() {
+			h.sendRoom(h.frame(TypeReady, readyPayload{con.name, false}))
+		}
+		h.readyBots()
+	}
+	h.sendRoom(h.frame(TypeSystem, systemPayload{e.event, e.text}))
+}
+
+// kick 断开被踢用户在房间里的所有连接并让出座位，之后房间也不再接受该用户进入
+func (h *roomHub) kick(e ownerEvent) {
+	h.bans[e.uuid] = true
+	spectator := false
+	for con := range h.conns {
+		if con.uuid != e.uuid {
+			continue
+		}
+		sendSystem(con, h.id, EventKicked, "您已被房主踢出房间！！！")
+		h.leaveGame(con)
+		delete(h.conns, con)
+		close(con.send)
+		spectator = spectator || con.spectator
+	}
+	h.leaveSeat(e.uuid)
+	h.sendRoom(h.frame(TypeSystem, systemPayload{EventLeave, e.text}))
+	if spectator {
+		h.sendViewers()
+	}
+	h.dropBots()
+}
+
+// leaveSeat 让出座位并取消准备，redis交给写队列
+func (h *roomHub) leaveSeat(uuid string) {
+	h.unready(uuid)
+	roomId := h.id
+	writeRoom(roomId, func() {
+		err := redis.DeleteUser(roomId, uuid)
+		if err != nil {
+			log.Println(err)
+		}
+		err = redis.CancelReady(roomId, uuid)
+		if err != nil {
+			log.Println(err)
+		}
+	})
+}
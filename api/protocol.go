@@ -0,0 +1,398 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"go-chess/model"
+	"log"
+	"unicode/utf8"
+)
+
+// ProtocolVersion WebSocket消息协议版本，客户端消息的version必须与之一致
+const ProtocolVersion = 1
+
+// 消息类型
+const (
+	TypeChat      = "chat"       //聊天
+	TypeSystem    = "system"     //系统消息，如进出房间、禁言警告
+	TypeMove      = "move"       //走棋
+	TypeReady     = "ready"      //准备/取消准备
+	TypeGameStart = "game_start" //开局
+	TypeGameOver  = "game_over"  //对局结束
+	TypeError     = "error"      //错误
+	TypePing      = "ping"       //心跳
+	TypeSnapshot  = "snapshot"   //重连或观众进入时的对局快照
+	TypeViewers   = "viewers"    //观看人数
+
+	TypeResign        = "resign"         //认输
+	TypeDrawOffer     = "draw_offer"     //提和
+	TypeDrawReply     = "draw_reply"     //答复提和
+	TypeTakebackOffer = "takeback_offer" //请求悔棋
+	TypeTakebackReply = "takeback_reply" //答复悔棋
+	TypeTakeback      = "takeback"       //悔棋后的局面
+
+	TypeMatchQueued = "match_queued" //已加入匹配队列
+	TypeMatched     = "matched"      //匹配成功
+)
+
+// ChannelSpectator 观众聊天频道，只发给观众
+const ChannelSpectator = "spectator"
+
+// 错误码
+const (
+	ErrBadFrame     = "bad_frame"     //不是合法的JSON消息
+	ErrBadVersion   = "bad_version"   //协议版本不一致
+	ErrUnknownType  = "unknown_type"  //客户端不能发送该类型
+	ErrBadPayload   = "bad_payload"   //payload不合法
+	ErrWrongRoom    = "wrong_room"    //room_id与所在房间不一致
+	ErrReady        = "ready_failed"  //准备状态切换失败
+	ErrNoGame       = "no_game"       //没有进行中的对局
+	ErrNotPlayer    = "not_player"    //不是对局双方
+	ErrNotTurn      = "not_turn"      //没轮到走棋
+	ErrIllegalMove  = "illegal_move"  //着法不合法
+	ErrSpectator    = "spectator"     //观众不能走棋和准备
+	ErrNoOffer      = "no_offer"      //没有需要答复的提和或悔棋
+	ErrOfferPending = "offer_pending" //上一次请求还没有答复
+	ErrRateLimited  = "rate_limited"  //提和、悔棋过于频繁
+	ErrNoTakeback   = "no_takeback"   //没有可以悔的着法
+	ErrInQueue      = "in_queue"      //已经在匹配队列中
+	ErrMatchFailed  = "match_failed"  //加入匹配队列失败
+)
+
+// 系统消息事件
+const (
+	EventJoin     = "join"     //进入房间
+	EventLeave    = "leave"    //离开房间
+	EventWarning  = "warning"  //发送违规消息被警告
+	EventMuted    = "muted"    //禁言中
+	EventBanned   = "banned"   //被禁止聊天
+	EventKicked   = "kicked"   //被踢出房间
+	EventNotice   = "notice"   //全员公告
+	EventOffline  = "offline"  //对局中断线，等待重连
+	EventOnline   = "online"   //断线后重连
+	EventOwner    = "owner"    //房主变更
+	EventLocked   = "locked"   //房间锁定或解锁
+	EventSettings = "settings" //房间设置修改
+	EventRequeued = "requeued" //匹配的对手没有进入房间，已重新排队
+)
+
+// maxChatLength 单条聊天消息的最大字数
+const maxChatLength = 200
+
+// recentChats 重连时补发的聊天条数
+const recentChats = 20
+
+// envelope 所有WebSocket消息的外层结构
+// 服务端广播的消息seq在房间内递增，单独回复某个连接的消息seq为0；客户端的seq由客户端自行递增，用于对应回复
+type envelope struct {
+	Type    string          `json:"type"`
+	Seq     int64           `json:"seq"`
+	RoomId  string          `json:"room_id"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Version int             `json:"version"`
+}
+
+type chatPayload struct {
+	Name    string `json:"name,omitempty"`
+	Text    string `json:"text"`
+	Channel string `json:"channel,omitempty"` //为空时发给整个房间，spectator只发给观众
+}
+
+type systemPayload struct {
+	Event string `json:"event"`
+	Text  string `json:"text"`
+}
+
+type movePayload struct {
+	Move         string        `json:"move"`
+	Side         int           `json:"side"`
+	Fen          string        `json:"fen,omitempty"`
+	RevealSquare string        `json:"reveal_square,omitempty"`
+	RevealPiece  string        `json:"reveal_piece,omitempty"`
+	Clock        *clockPayload `json:"clock,omitempty"`
+}
+
+type readyPayload struct {
+	Name  string `json:"name,omitempty"`
+	Ready bool   `json:"ready"`
+}
+
+type gameStartPayload struct {
+	Variant     int               `json:"variant"`
+	Red         string            `json:"red"`
+	Black       string            `json:"black"`
+	Fen         string            `json:"fen"`
+	TimeControl model.TimeControl `json:"time_control"`
+	Clock       clockPayload      `json:"clock"`
+}
+
+type gameOverPayload struct {
+	Winner  int             `json:"winner"`
+	Reason  int             `json:"reason"`
+	Ratings []ratingPayload `json:"ratings,omitempty"` //计分对局双方的等级分变化
+}
+
+type snapshotPayload struct {
+	Variant     int               `json:"variant"`
+	Red         string            `json:"red"`
+	Black       string            `json:"black"`
+	Fen         string            `json:"fen"`
+	Side        int               `json:"side"`
+	Moves       []string          `json:"moves"`
+	TimeControl model.TimeControl `json:"time_control"`
+	Clock       clockPayload      `json:"clock"`
+	Chats       []chatPayload     `json:"chats"`
+	Viewers     int               `json:"viewers"`
+}
+
+type offerPayload struct {
+	Name string `json:"name"`
+	Side int    `json:"side"`
+}
+
+type replyPayload struct {
+	Name   string `json:"name,omitempty"`
+	Accept bool   `json:"accept"`
+}
+
+type takebackPayload struct {
+	Plies int          `json:"plies"`
+	Fen   string       `json:"fen"`
+	Side  int          `json:"side"`
+	Moves []string     `json:"moves"`
+	Clock clockPayload `json:"clock"`
+}
+
+type viewersPayload struct {
+	Count int `json:"count"`
+}
+
+type matchQueuedPayload struct {
+	TimeControl model.TimeControl `json:"time_control"`
+	Rated       bool              `json:"rated"`
+	Rating      float64           `json:"rating"`
+}
+
+type matchedPayload struct {
+	RoomId         string            `json:"room_id"`
+	Opponent       string            `json:"opponent"`
+	OpponentRating float64           `json:"opponent_rating"`
+	TimeControl    model.TimeControl `json:"time_control"`
+	Rated          bool              `json:"rated"`
+}
+
+type errorPayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	ReplyTo int64  `json:"reply_to"`
+}
+
+type pingPayload struct {
+	ReplyTo int64 `json:"reply_to"`
+}
+
+func (p chatPayload) validate() error {
+	if p.Text == "" {
+		return errors.New("text is empty")
+	}
+	if utf8.RuneCountInString(p.Text) > maxChatLength {
+		return errors.New("text is too long")
+	}
+	if p.Channel != "" && p.Channel != ChannelSpectator {
+		return errors.New("unknown channel")
+	}
+	return nil
+}
+
+func (p movePayload) validate() error {
+	if len(p.Move) != 2 && len(p.Move) != 4 {
+		return errors.New("move must be like h2e2")
+	}
+	return nil
+}
+
+// protoError 返回给客户端的错误
+type protoError struct {
+	code string
+	text string
+}
+
+func (e *protoError) Error() string {
+	return e.code + ": " + e.text
+}
+
+// request 客户端发来的需要房间处理的消息
+type request struct {
+	message
+	typ    string
+	seq    int64
+	move   string
+	accept bool
+	err    *protoError
+}
+
+// parseFrame 解析并校验客户端消息，返回消息外层和对应类型的payload
+func parseFrame(data []byte, roomId string) (envelope, interface{}, *protoError) {
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return env, nil, &protoError{ErrBadFrame, "frame must be a json envelope"}
+	}
+	if env.Version != ProtocolVersion {
+		return env, nil, &protoError{ErrBadVersion, "unsupported protocol version"}
+	}
+	if env.RoomId != "" && env.RoomId != roomId {
+		return env, nil, &protoError{ErrWrongRoom, "room_id does not match the connection"}
+	}
+
+	switch env.Type {
+	case TypeChat:
+		var p chatPayload
+		if err := decodePayload(env.Payload, &p); err != nil {
+			return env, nil, err
+		}
+		if err := p.validate(); err != nil {
+			return env, nil, &protoError{ErrBadPayload, err.Error()}
+		}
+		return env, p, nil
+	case TypeMove:
+		var p movePayload
+		if err := decodePayload(env.Payload, &p); err != nil {
+			return env, nil, err
+		}
+		if err := p.validate(); err != nil {
+			return env, nil, &protoError{ErrBadPayload, err.Error()}
+		}
+		return env, p, nil
+	case TypeDrawReply, TypeTakebackReply:
+		var p replyPayload
+		if err := decodePayload(env.Payload, &p); err != nil {
+			return env, nil, err
+		}
+		return env, p, nil
+	case TypeReady, TypePing, TypeResign, TypeDrawOffer, TypeTakebackOffer:
+		return env, nil, nil
+	}
+	return env, nil, &protoError{ErrUnknownType, "clients cannot send type " + env.Type}
+}
+
+func decodePayload(raw json.RawMessage, v interface{}) *protoError {
+	if len(raw) == 0 {
+		return &protoError{ErrBadPayload, "payload is required"}
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return &protoError{ErrBadPayload, err.Error()}
+	}
+	return nil
+}
+
+// encodeFrame 编码服务端消息
+func encodeFrame(typ string, seq int64, roomId string, payload interface{}) []byte {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		log.Println("marshal payload err:", err)
+	}
+	data, err := json.Marshal(envelope{
+		Type:    typ,
+		Seq:     seq,
+		RoomId:  roomId,
+		Payload: raw,
+		Version: ProtocolVersion,
+	})
+	if err != nil {
+		log.Println("marshal frame err:", err)
+	}
+	return data
+}
+
+// frame 房间广播消息，seq在房间内递增
+func (h *roomHub) frame(typ string, payload interface{}) []byte {
+	h.seq++
+	return encodeFrame(typ, h.seq, h.id, payload)
+}
+
+// sendRoom 把消息发给房间内所有连接，跟不上的连接被断开
+func (h *roomHub) sendRoom(data []byte) {
+	for con := range h.conns {
+		h.send(con, data)
+	}
+}
+
+// sendTo 单独回复一个连接，发送队列已满时丢弃
+func sendTo(c *connection, data []byte) {
+	select {
+	case c.send <- data:
+	default:
+		log.Println("send queue full, drop frame to", c.name)
+	}
+}
+
+func sendSystem(c *connection, roomId, event, text string) {
+	sendTo(c, encodeFrame(TypeSystem, 0, roomId, systemPayload{event, text}))
+}
+
+func sendError(r request, code, text string) {
+	sendTo(r.conn, encodeFrame(TypeError, 0, r.roomId, errorPayload{code, text, r.seq}))
+}
+
+// keepChat 保存房间最近的聊天，重连时补发
+func (h *roomHub) keepChat(chat chatPayload) {
+	chats := append(h.chats, chat)
+	if len(chats) > recentChats {
+		chats = chats[len(chats)-recentChats:]
+	}
+	h.chats = chats
+}
+
+// players 房间内的选手连接，不含观众
+func (h *roomHub) players() []*connection {
+	var players []*connection
+	for con := range h.conns {
+		if !con.spectator {
+			players = append(players, con)
+		}
+	}
+	return players
+}
+
+// viewers 房间内的观众人数
+func (h *roomHub) viewers() int {
+	return len(h.conns) - len(h.players())
+}
+
+// sendViewers 观众进出时广播观看人数
+func (h *roomHub) sendViewers() {
+	h.sendRoom(h.frame(TypeViewers, viewersPayload{h.viewers()}))
+}
+
+// handle 处理客户端的走棋、准备、心跳和错误消息
+func (h *roomHub) handle(r request) {
+	if _, ok := h.conns[r.conn]; !ok {
+		//连接已被踢出或断开，send已关闭
+		return
+	}
+	switch r.typ {
+	case TypeMove, TypeResign, TypeDrawOffer, TypeDrawReply, TypeTakebackOffer, TypeTakebackReply:
+		if r.conn.spectator {
+			sendError(r, ErrSpectator, "spectators cannot play")
+			return
+		}
+	}
+	switch r.typ {
+	case TypeMove:
+		h.playMove(r)
+	case TypeResign:
+		h.resign(r)
+	case TypeDrawOffer:
+		h.offerDraw(r)
+	case TypeDrawReply:
+		h.replyDraw(r)
+	case TypeTakebackOffer:
+		h.offerTakeback(r)
+	case TypeTakebackReply:
+		h.replyTakeback(r)
+	case TypePing:
+		sendTo(r.conn, encodeFrame(TypePing, 0, r.roomId, pingPayload{r.seq}))
+	case TypeError:
+		sendError(r, r.err.code, r.err.text)
+	}
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"hash/fnv"
+	"log"
+	"sync"
+)
+
+// jobQueue 按顺序执行的后台任务，提交方不阻塞；任务从不丢弃，积压超过上限时只记日志，
+// 写库和redis写入丢了就无法补回，宁可多占内存
+type jobQueue struct {
+	sync.Mutex
+	name  string
+	jobs  []func()
+	limit int
+	warn  int //上次记日志时的积压数，积压每多limit个再记一次
+	wake  chan struct{}
+}
+
+// shardedQueue 按房间号分片的任务队列，同一房间的任务在同一分片上按提交顺序执行，一个慢任务只拖住自己的分片
+type shardedQueue []*jobQueue
+
+func newShardedQueue(name string, shards, limit int) shardedQueue {
+	q := make(shardedQueue, shards)
+	for i := range q {
+		q[i] = &jobQueue{name: name, limit: limit, wake: make(chan struct{}, 1)}
+	}
+	return q
+}
+
+// start 每个分片一个worker
+func (q shardedQueue) start() {
+	for _, jq := range q {
+		go jq.run()
+	}
+}
+
+// push 把任务放进房间所在的分片
+func (q shardedQueue) push(roomId string, job func()) {
+	f := fnv.New32a()
+	_, _ = f.Write([]byte(roomId))
+	q[f.Sum32()%uint32(len(q))].push(job)
+}
+
+// backlog 所有分片积压的任务数
+func (q shardedQueue) backlog() int {
+	n := 0
+	for _, jq := range q {
+		jq.Lock()
+		n += len(jq.jobs)
+		jq.Unlock()
+	}
+	return n
+}
+
+func (jq *jobQueue) push(job func()) {
+	jq.Lock()
+	jq.jobs = append(jq.jobs, job)
+	n := len(jq.jobs)
+	overLimit := n >= jq.warn+jq.limit
+	if overLimit {
+		jq.warn = n
+	}
+	jq.Unlock()
+	if overLimit {
+		log.Println(jq.name, "queue backlog:", n)
+	}
+	select {
+	case jq.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (jq *jobQueue) run() {
+	for range jq.wake {
+		for {
+			jq.Lock()
+			if len(jq.jobs) == 0 {
+				jq.Unlock()
+				break
+			}
+			job := jq.jobs[0]
+			jq.jobs[0] = nil
+			jq.jobs = jq.jobs[1:]
+			if len(jq.jobs) == 0 {
+				jq.warn = 0
+			}
+			jq.Unlock()
+			job()
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestShardedQueueKeepsEveryJob 积压远超上限时任务也不丢，同一房间按提交顺序执行
+func TestShardedQueueKeepsEveryJob(t *testing.T) {
+	q := newShardedQueue("test", 4, 16)
+	const jobs = 1000
+	got := make(chan int, jobs)
+	block := make(chan struct{})
+	q.push("room", func() { <-block })
+	for i := 0; i < jobs; i++ {
+		i := i
+		q.push("room", func() { got <- i })
+	}
+	if n := q.backlog(); n < jobs {
+		t.Fatalf("backlog = %d, want at least %d", n, jobs)
+	}
+	q.start()
+	close(block)
+	for want := 0; want < jobs; want++ {
+		select {
+		case i := <-got:
+			if i != want {
+				t.Fatalf("job %d ran at position %d", i, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d of %d jobs ran", want, jobs)
+		}
+	}
+}
+
+func TestRetryRecord(t *testing.T) {
+	backoff := recordBackoff
+	recordBackoff = time.Millisecond
+	defer func() { recordBackoff = backoff }()
+	tries := 0
+	err := retryRecord("room", "test", func() error {
+		tries++
+		if tries < 3 {
+			return errors.New("db down")
+		}
+		return nil
+	})
+	if err != nil || tries != 3 {
+		t.Fatalf("retryRecord = %v after %d tries, want success after 3", err, tries)
+	}
+	tries = 0
+	err = retryRecord("room", "test", func() error {
+		tries++
+		return errors.New("db down")
+	})
+	if err == nil || tries != recordAttempts {
+		t.Fatalf("retryRecord = %v after %d tries, want failure after %d", err, tries, recordAttempts)
+	}
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"go-chess/dao/mysql"
+	"go-chess/dao/redis"
+	"go-chess/engine"
+	"go-chess/model"
+	"go-chess/rating"
+	"go-chess/util"
+	"math"
+	"time"
+)
+
+// minRatedMoves 少于这个步数就结束的对局不计算等级分，避免开局就离开也改变等级分
+const minRatedMoves = 2
+
+// ratingPayload game_over中一方的等级分变化
+type ratingPayload struct {
+	Side     int     `json:"side"`
+	Category string  `json:"category"`
+	Rating   float64 `json:"rating"`
+	Delta    float64 `json:"delta"`
+}
+
+// ratingInfo 个人资料中某个类别的等级分
+type ratingInfo struct {
+	Category   string    `json:"category"`
+	Rating     float64   `json:"rating"`
+	Deviation  float64   `json:"deviation"`
+	Volatility float64   `json:"volatility"`
+	Games      int       `json:"games"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type profileInfo struct {
+	Uuid    string       `json:"uuid"`
+	Name    string       `json:"name"`
+	Ratings []ratingInfo `json:"ratings"`
+}
+
+// loadRatings 开局前读取双方的等级分，只有设置了计分且不让子的标准象棋才计算
+func loadRatings(roomId string, uuids [2]string, s *gameSettings) {
+	rated, err := redis.GetRated(roomId)
+	if err != nil || !rated {
+		return
+	}
+	if s.variant != engine.VariantStandard || s.handicap != engine.HandicapNone {
+		return
+	}
+	if isBot(uuids[0]) || isBot(uuids[1]) {
+		return
+	}
+	category := rating.Category(s.tc)
+	for sd, uuid := range uuids {
+		r, ok, err := mysql.SelectRating(uuid, category)
+		if err != nil {
+			return
+		}
+		if !ok {
+			d := rating.Default()
+			r = model.Rating{
+				Uuid:       uuid,
+				Category:   category,
+				Rating:     d.Rating,
+				Deviation:  d.Deviation,
+				Volatility: d.Volatility,
+			}
+		}
+		s.ratings[sd] = r
+	}
+	s.rated = true
+}
+
+// updateRatings 对局结束后按开局时的等级分估算双方的变化给客户端显示，写库时在事务中按最新的等级分重新计算
+func updateRatings(g *roomGame, winner int, now time.Time) []ratingPayload {
+	if !g.rated || len(g.moves) < minRatedMoves {
+		return nil
+	}
+	var scores [2]float64
+	switch winner {
+	case engine.WinnerRed:
+		scores = [2]float64{1, 0}
+	case engine.WinnerBlack:
+		scores = [2]float64{0, 1}
+	default:
+		scores = [2]float64{0.5, 0.5}
+	}
+	recordRatings(g, scores, now)
+
+	nexts, deltas := rateGame(g.ratings, scores, now)
+	payloads := make([]ratingPayload, 0, 2)
+	for sd, r := range nexts {
+		payloads = append(payloads, ratingPayload{
+			Side:     sd,
+			Category: r.Category,
+			Rating:   math.Round(r.Rating),
+			Delta:    math.Round(deltas[sd]),
+		})
+	}
+	return payloads
+}
+
+// rateGame 按一局的结果计算双方的新等级分和变化，scores为双方的得分
+func rateGame(olds [2]model.Rating, scores [2]float64, now time.Time) ([2]model.Rating, [2]float64) {
+	var gs [2]rating.Glicko2
+	for sd, r := range olds {
+		gs[sd] = rating.Glicko2{Rating: r.Rating, Deviation: r.Deviation, Volatility: r.Volatility}
+	}
+	nexts := olds
+	var deltas [2]float64
+	for sd := range olds {
+		next := rating.Update(gs[sd], gs[1-sd], scores[sd])
+		deltas[sd] = next.Rating - olds[sd].Rating
+		r := &nexts[sd]
+		r.Rating, r.Deviation, r.Volatility = next.Rating, next.Deviation, next.Volatility
+		r.Games++
+		r.UpdatedAt = now
+	}
+	return nexts, deltas
+}
+
+// profile 查看用户的各类别等级分，不传uuid时查自己
+func profile(ctx *gin.Context) {
+	Iuuid, _ := ctx.Get("uuid")
+	uuid := ctx.DefaultQuery("uuid", Iuuid.(string))
+
+	names, err := mysql.SelectUserNamesByUuids([]string{uuid})
+	if err != nil {
+		util.RespError(ctx, 400, "select user error")
+		return
+	}
+	name, ok := names[uuid]
+	if !ok {
+		util.RespErrorWithData(ctx, 400, "profile error", "user does not exist")
+		return
+	}
+	ratings, err := mysql.SelectRatings(uuid)
+	if err != nil {
+		util.RespError(ctx, 400, "select ratings error")
+		return
+	}
+
+	info := profileInfo{Uuid: uuid, Name: name, Ratings: make([]ratingInfo, 0, len(ratings))}
+	for _, r := range ratings {
+		info.Ratings = append(info.Ratings, ratingInfo{
+			Category:   r.Category,
+			Rating:     math.Round(r.Rating),
+			Deviation:  math.Round(r.Deviation),
+			Volatility: r.Volatility,
+			Games:      r.Games,
+			UpdatedAt:  r.UpdatedAt,
+		})
+	}
+	util.RespSuccessfulWithData(ctx, "get profile successful", info)
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"fmt"
+	"go-chess/dao/mysql"
+	"go-chess/dao/redis"
+	"go-chess/model"
+	"log"
+	"strings"
+	"time"
+)
+
+// records 对局的写库任务，同一房间的任务按顺序执行，房间不用等待数据库
+var records = newShardedQueue("record", 8, 4096)
+
+// roomWrites 房间的redis写入，同一房间的写入按顺序执行，房间广播时不用等待redis
+var roomWrites = newShardedQueue("room write", 16, 4096)
+
+const recordAttempts = 5 //写库失败时的尝试次数
+
+// recordBackoff 第一次重试前等待的时间，之后每次翻倍
+var recordBackoff = 500 * time.Millisecond
+
+// retryRecord 写库失败时退避重试，期间阻塞所在分片，同一房间后面的记录不会越过它先写；
+// 全部失败时记日志并返回错误
+func retryRecord(roomId, desc string, write func() error) error {
+	backoff := recordBackoff
+	var err error
+	for i := 0; i < recordAttempts; i++ {
+		if err = write(); err == nil {
+			return nil
+		}
+		if i < recordAttempts-1 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	log.Println("record", desc, "failed, room:", roomId, "err:", err)
+	return err
+}
+
+// recorded 开局记录是否已经写入，没有写入时后面的记录无处挂靠，记日志后跳过
+func recorded(rec *model.Game, desc string) bool {
+	if rec.Id == 0 {
+		log.Println("record", desc, "skipped, game was not saved, room:", rec.RoomId)
+		return false
+	}
+	return true
+}
+
+// writeRoom 把房间的redis写入放进队列，同一房间的写入按提交顺序执行
+func writeRoom(roomId string, job func()) {
+	roomWrites.push(roomId, job)
+}
+
+// recordStart 开局时写入对局，之后的着法和结果都挂在这条记录上
+func recordStart(g *roomGame, now time.Time) {
+	rec := &model.Game{
+		RoomId:         g.roomId,
+		RedUuid:        g.uuids[0],
+		BlackUuid:      g.uuids[1],
+		Variant:        g.variant,
+		Handicap:       g.handicap,
+		Removed:        strings.Join(g.removed, ","),
+		ClockMode:      g.tc.Mode,
+		ClockBase:      g.tc.Base,
+		ClockIncrement: g.tc.Increment,
+		ClockPeriods:   g.tc.Periods,
+		StartFen:       g.game.Fen(),
+		Seed:           g.seed,
+		Result:         model.ResultPlaying,
+		StartedAt:      now,
+	}
+	g.record = rec
+	records.push(rec.RoomId, func() {
+		_ = retryRecord(rec.RoomId, "game", func() error {
+			return mysql.CreateGame(rec)
+		})
+	})
+}
+
+// recordMove 每走一步就写入，服务端崩溃也不会丢掉已走的着法
+func recordMove(g *roomGame, sd int, mv string, clock clockPayload, now time.Time) {
+	rec := g.record
+	move := &model.GameMove{
+		Ply:       len(g.moves),
+		Side:      sd,
+		Move:      mv,
+		Fen:       g.game.Fen(),
+		Hash:      fmt.Sprintf("%016x", g.game.Hash()),
+		ClockMs:   clock.Remain[sd],
+		CreatedAt: now,
+	}
+	records.push(rec.RoomId, func() {
+		if !recorded(rec, "move") {
+			return
+		}
+		move.GameId = rec.Id
+		_ = retryRecord(rec.RoomId, "move", func() error {
+			return mysql.AddGameMove(move)
+		})
+	})
+}
+
+// recordTakeback 悔棋后删除退回的着法
+func recordTakeback(g *roomGame) {
+	rec := g.record
+	fromPly := len(g.moves) + 1
+	records.push(rec.RoomId, func() {
+		if !recorded(rec, "takeback") {
+			return
+		}
+		_ = retryRecord(rec.RoomId, "takeback", func() error {
+			return mysql.DeleteGameMoves(rec.Id, fromPly)
+		})
+	})
+}
+
+// recordEnd 写入结果和结束原因
+func recordEnd(g *roomGame, winner, reason int, now time.Time) {
+	rec := g.record
+	moveCount := len(g.moves)
+	records.push(rec.RoomId, func() {
+		if !recorded(rec, "result") {
+			return
+		}
+		_ = retryRecord(rec.RoomId, "result", func() error {
+			return mysql.FinishGame(rec.Id, winner, reason, moveCount, now)
+		})
+	})
+}
+
+// recordRatings 在事务中锁住双方最新的等级分再计算，同一用户同时下的两局计分棋不会互相覆盖，写库成功后再更新排行榜
+func recordRatings(g *roomGame, scores [2]float64, now time.Time) {
+	rec := g.record
+	starts := g.ratings
+	records.push(rec.RoomId, func() {
+		if !recorded(rec, "ratings") {
+			return
+		}
+		var nexts [2]model.Rating
+		var deltas [2]float64
+		//失败时事务整体回滚，重试时重新锁行计算
+		err := retryRecord(rec.RoomId, "ratings", func() error {
+			return mysql.UpdateRatings(starts, func(curs [2]model.Rating) ([2]model.Rating, [2]model.RatingHistory) {
+				nexts, deltas = rateGame(curs, scores, now)
+				var histories [2]model.RatingHistory
+				for sd, r := range nexts {
+					histories[sd] = model.RatingHistory{
+						Uuid:       r.Uuid,
+						Category:   r.Category,
+						GameId:     rec.Id,
+						Rating:     r.Rating,
+						Deviation:  r.Deviation,
+						Volatility: r.Volatility,
+						Delta:      deltas[sd],
+						CreatedAt:  now,
+					}
+				}
+				return nexts, histories
+			})
+		})
+		if err != nil {
+			return
+		}
+		for sd, r := range nexts {
+			_ = retryRecord(rec.RoomId, "leaderboard", func() error {
+				return redis.UpdateLeaderboard(r.Uuid, r.Category, r.Rating, deltas[sd], now)
+			})
+		}
+	})
+}
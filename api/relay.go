@@ -0,0 +1,333 @@
+package api
+
+import (
+	"encoding/json"
+	uuid "github.com/satori/go.uuid"
+	"go-chess/broker"
+	"go-chess/dao/redis"
+	"go-chess/global"
+	"go-chess/model"
+	"hash/fnv"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	nodeTTL     = time.Minute      //房间归属实例的有效期，实例宕机后房间在这段时间后由其他实例接管
+	nodeRefresh = 20 * time.Second //续期的间隔
+)
+
+// 实例之间转发的消息类型
+const (
+	kindJoin    = "join"    //连接进入其他实例上的房间
+	kindFrame   = "frame"   //客户端发来的消息
+	kindLeave   = "leave"   //客户端断开
+	kindDeliver = "deliver" //发给客户端的消息
+	kindClose   = "close"   //房间所在实例关闭了连接
+	kindReady   = "ready"   //准备状态变化
+	kindOwner   = "owner"   //房主操作和设置变化
+	kindBot     = "bot"     //AI加入房间
+	kindRequeue = "requeue" //匹配的对手没有进入房间，选手重新排队
+)
+
+// nodeMessage 实例之间转发的消息，每个实例只订阅自己的topic
+type nodeMessage struct {
+	Kind       string             `json:"kind"`
+	From       string             `json:"from"`
+	RoomId     string             `json:"room_id"`
+	ConnId     string             `json:"conn_id,omitempty"`
+	Uuid       string             `json:"uuid,omitempty"`
+	Name       string             `json:"name,omitempty"`
+	Spectator  bool               `json:"spectator,omitempty"`
+	Data       []byte             `json:"data,omitempty"`
+	Ready      bool               `json:"ready,omitempty"`
+	ReadyUuids []string           `json:"ready_uuids,omitempty"`
+	Event      string             `json:"event,omitempty"`
+	Text       string             `json:"text,omitempty"`
+	Level      int                `json:"level,omitempty"`
+	Ticket     *model.MatchTicket `json:"ticket,omitempty"`
+}
+
+// connTable 按编号查找跨实例转发的连接
+type connTable struct {
+	sync.Mutex
+	conns map[string]*connection
+}
+
+func (t *connTable) add(c *connection) {
+	t.Lock()
+	defer t.Unlock()
+	t.conns[c.id] = c
+}
+
+func (t *connTable) get(id string) *connection {
+	t.Lock()
+	defer t.Unlock()
+	return t.conns[id]
+}
+
+// remove 连接仍在表中时移出，返回false说明已经被移出过
+func (t *connTable) remove(c *connection) bool {
+	t.Lock()
+	defer t.Unlock()
+	if t.conns[c.id] != c {
+		return false
+	}
+	delete(t.conns, c.id)
+	return true
+}
+
+// deliver 连接仍在表中时发给它，查找和发送都在锁内，不会和drop关闭发送队列交错
+func (t *connTable) deliver(id string, data []byte) {
+	t.Lock()
+	defer t.Unlock()
+	if c := t.conns[id]; c != nil {
+		sendTo(c, data)
+	}
+}
+
+// drop 连接仍在表中时移出并关闭发送队列，返回false说明已经被移出过；表中连接的发送队列只由这里关闭
+func (t *connTable) drop(c *connection) bool {
+	t.Lock()
+	defer t.Unlock()
+	if t.conns[c.id] != c {
+		return false
+	}
+	delete(t.conns, c.id)
+	close(c.send)
+	return true
+}
+
+var (
+	nodeId     string //本实例的编号
+	roomBroker broker.Broker
+
+	relays  = connTable{conns: make(map[string]*connection)} //本实例上、房间在其他实例的连接
+	proxies = connTable{conns: make(map[string]*connection)} //其他实例上的连接在本实例的代理
+)
+
+// initBroker 按配置选择broker并订阅本实例的topic
+func initBroker() {
+	nodeId = global.Settings.BrokerInfo.Node
+	if nodeId == "" {
+		nodeId = uuid.NewV4().String()
+	}
+	switch global.Settings.BrokerInfo.Type {
+	case "memory":
+		roomBroker = broker.NewMemory()
+	default:
+		roomBroker = redis.Broker{}
+	}
+	ch, err := roomBroker.Subscribe(nodeTopic(nodeId))
+	if err != nil {
+		log.Println("subscribe broker err:", err)
+		return
+	}
+	go relayLoop(ch)
+}
+
+func nodeTopic(node string) string {
+	return "relay_" + node
+}
+
+func publishNode(node string, msg nodeMessage) {
+	msg.From = nodeId
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Println("marshal node message err:", err)
+		return
+	}
+	_ = roomBroker.Publish(nodeTopic(node), data)
+}
+
+// nodeLocks 按房间号分段加锁，认领房间进入和房间退出后释放归属互斥，释放前确认房间确实不在本实例上
+var nodeLocks [64]sync.Mutex
+
+func nodeLock(roomId string) *sync.Mutex {
+	f := fnv.New32a()
+	_, _ = f.Write([]byte(roomId))
+	return &nodeLocks[f.Sum32()%uint32(len(nodeLocks))]
+}
+
+// enterRoom 客户端进入房间：认领房间，房间属于其他实例时转发过去
+func enterRoom(m message) {
+	mu := nodeLock(m.roomId)
+	mu.Lock()
+	defer mu.Unlock()
+	node, err := redis.ClaimRoomNode(m.roomId, nodeId, nodeTTL)
+	if err == nil && node != nodeId {
+		//房间的对局和广播由其他实例负责，本实例只转发
+		joinRemote(node, m)
+		return
+	}
+	joinRoom(m)
+}
+
+// joinLocal 其他实例转发来的连接进入本实例上的房间；房间已在本实例上退出时重新认领，已被其他实例接管时返回false
+func joinLocal(m message) bool {
+	mu := nodeLock(m.roomId)
+	mu.Lock()
+	defer mu.Unlock()
+	if !hubs.has(m.roomId) {
+		node, err := redis.ClaimRoomNode(m.roomId, nodeId, nodeTTL)
+		if err == nil && node != nodeId {
+			return false
+		}
+	}
+	joinRoom(m)
+	return true
+}
+
+// roomRemote 房间属于其他实例时返回该实例，属于本实例或没有归属时为空
+func roomRemote(roomId string) string {
+	node, err := redis.GetRoomNode(roomId)
+	if err != nil || node == nodeId {
+		return ""
+	}
+	return node
+}
+
+// joinRemote 连接进入其他实例上的房间，之后客户端的消息都转发过去
+func joinRemote(node string, m message) {
+	c := m.conn
+	c.id = uuid.NewV4().String()
+	c.relayTo = node
+	relays.add(c)
+	publishNode(node, nodeMessage{Kind: kindJoin, RoomId: m.roomId, ConnId: c.id, Uuid: c.uuid, Name: c.name, Spectator: c.spectator})
+}
+
+func relayFrame(m message, msg []byte) {
+	publishNode(m.conn.relayTo, nodeMessage{Kind: kindFrame, RoomId: m.roomId, ConnId: m.conn.id, Data: msg})
+}
+
+// leave 客户端断开，房间在其他实例上时通知那边
+func (m message) leave() {
+	c := m.conn
+	if c.relayTo == "" {
+		leaveRoom(m)
+		return
+	}
+	if relays.drop(c) {
+		publishNode(c.relayTo, nodeMessage{Kind: kindLeave, RoomId: m.roomId, ConnId: c.id})
+	}
+}
+
+// newProxyConn 其他实例上的连接在本实例的代理，房间发给它的消息转发回连接所在的实例
+func newProxyConn(msg nodeMessage) *connection {
+	c := &connection{
+		send:      make(chan []byte, 256),
+		name:      msg.Name,
+		uuid:      msg.Uuid,
+		spectator: msg.Spectator,
+		id:        msg.ConnId,
+		origin:    msg.From,
+	}
+	proxies.add(c)
+	go func() {
+		for data := range c.send {
+			publishNode(c.origin, nodeMessage{Kind: kindDeliver, RoomId: msg.RoomId, ConnId: c.id, Data: data})
+		}
+		proxies.remove(c)
+		publishNode(c.origin, nodeMessage{Kind: kindClose, RoomId: msg.RoomId, ConnId: c.id})
+	}()
+	return c
+}
+
+// relayLoop 按顺序处理其他实例转发来的消息，同一连接的消息顺序不变
+func relayLoop(ch <-chan []byte) {
+	for data := range ch {
+		var msg nodeMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			log.Println("unmarshal node message err:", err)
+			continue
+		}
+		handleNodeMessage(msg)
+	}
+}
+
+func handleNodeMessage(msg nodeMessage) {
+	switch msg.Kind {
+	case kindJoin:
+		c := newProxyConn(msg)
+		if !joinLocal(message{nil, msg.RoomId, c.name, c, ""}) {
+			//房间已被其他实例接管，关闭连接让客户端重新进入
+			close(c.send)
+		}
+	case kindFrame:
+		if c := proxies.get(msg.ConnId); c != nil {
+			message{nil, msg.RoomId, c.name, c, ""}.handleFrame(msg.Data)
+		}
+	case kindLeave:
+		if c := proxies.get(msg.ConnId); c != nil {
+			leaveRoom(message{nil, msg.RoomId, c.name, c, ""})
+		}
+	case kindDeliver:
+		relays.deliver(msg.ConnId, msg.Data)
+	case kindClose:
+		if c := relays.get(msg.ConnId); c != nil {
+			relays.drop(c)
+		}
+	case kindReady:
+		postReady(readyEvent{msg.RoomId, msg.Uuid, msg.Name, msg.Ready, msg.ReadyUuids})
+	case kindOwner:
+		postOwnerEvent(ownerEvent{msg.RoomId, msg.Event, msg.Uuid, msg.Text})
+	case kindBot:
+		registerBot(msg.RoomId, msg.Level)
+	case kindRequeue:
+		if msg.Ticket != nil {
+			postRequeue(msg.RoomId, *msg.Ticket)
+		}
+	}
+}
+
+// sendReady 把准备状态的变化交给房间所在的实例
+func sendReady(e readyEvent) {
+	if node := roomRemote(e.roomId); node != "" {
+		publishNode(node, nodeMessage{Kind: kindReady, RoomId: e.roomId, Uuid: e.uuid, Name: e.name, Ready: e.ready, ReadyUuids: e.readyUuids})
+		return
+	}
+	postReady(e)
+}
+
+// sendOwnerEvent 把房主操作交给房间所在的实例
+func sendOwnerEvent(e ownerEvent) {
+	if node := roomRemote(e.roomId); node != "" {
+		publishNode(node, nodeMessage{Kind: kindOwner, RoomId: e.roomId, Event: e.event, Uuid: e.uuid, Text: e.text})
+		return
+	}
+	postOwnerEvent(e)
+}
+
+// sendRequeue 让房间所在的实例把进了房间的一方放回匹配队列
+func sendRequeue(roomId string, t model.MatchTicket) {
+	if node := roomRemote(roomId); node != "" {
+		publishNode(node, nodeMessage{Kind: kindRequeue, RoomId: roomId, Ticket: &t})
+		return
+	}
+	postRequeue(roomId, t)
+}
+
+// registerBot AI加入房间所在实例上的房间
+func registerBot(roomId string, level int) {
+	if node := roomRemote(roomId); node != "" {
+		publishNode(node, nodeMessage{Kind: kindBot, RoomId: roomId, Level: level})
+		return
+	}
+	c := newBotConn(level)
+	if !joinLocal(message{nil, roomId, c.name, c, ""}) {
+		close(c.send)
+		log.Println("room moved to another node, bot not added", roomId)
+	}
+}
+
+// releaseNode 房间在本实例上空了，之后进入的用户可以由任意实例接管；释放前房间又在本实例上创建时不释放
+func releaseNode(roomId string) {
+	mu := nodeLock(roomId)
+	mu.Lock()
+	defer mu.Unlock()
+	if hubs.has(roomId) {
+		return
+	}
+	_ = redis.ReleaseRoomNode(roomId, nodeId)
+}
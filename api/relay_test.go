@@ -0,0 +1,159 @@
+package api
+
+import (
+	"encoding/json"
+	"go-chess/broker"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var relayOnce sync.Once
+
+// startMemoryRelay 本测试进程作为实例A，测试代码扮演实例B，两边通过同一个进程内broker转发
+func startMemoryRelay(t *testing.T) <-chan []byte {
+	relayOnce.Do(func() {
+		nodeId = "node-a"
+		roomBroker = broker.NewMemory()
+		ch, err := roomBroker.Subscribe(nodeTopic(nodeId))
+		if err != nil {
+			t.Fatal(err)
+		}
+		go relayLoop(ch)
+	})
+	ch, err := roomBroker.Subscribe(nodeTopic("node-b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ch
+}
+
+// publishFromB 实例B发给实例A的消息
+func publishFromB(t *testing.T, msg nodeMessage) {
+	msg.From = "node-b"
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := roomBroker.Publish(nodeTopic(nodeId), data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// receiveOnB 等待实例B收到指定类型的消息，跳过其他消息
+func receiveOnB(t *testing.T, ch <-chan []byte, kind string, match func(nodeMessage) bool) nodeMessage {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case data := <-ch:
+			var msg nodeMessage
+			if err := json.Unmarshal(data, &msg); err != nil {
+				t.Fatal(err)
+			}
+			if msg.Kind == kind && (match == nil || match(msg)) {
+				return msg
+			}
+		case <-timeout:
+			t.Fatalf("node-b did not receive %s", kind)
+		}
+	}
+}
+
+// TestRelayRemoteClient 实例B上的客户端进入实例A上的房间：心跳转发过来，回复和关闭转发回去
+func TestRelayRemoteClient(t *testing.T) {
+	b := startMemoryRelay(t)
+	local := newTestConn("local")
+	joinRoom(message{nil, "relay-room", local.name, local, ""})
+	waitRoom(t, "relay-room")
+
+	publishFromB(t, nodeMessage{Kind: kindJoin, RoomId: "relay-room", ConnId: "remote-1", Uuid: "uuid-remote", Name: "remote"})
+	receiveOnB(t, b, kindDeliver, func(msg nodeMessage) bool {
+		return strings.Contains(string(msg.Data), "欢迎新伙伴remote")
+	})
+
+	ping := `{"type":"ping","seq":7,"room_id":"relay-room","version":1}`
+	publishFromB(t, nodeMessage{Kind: kindFrame, RoomId: "relay-room", ConnId: "remote-1", Data: []byte(ping)})
+	pong := receiveOnB(t, b, kindDeliver, func(msg nodeMessage) bool {
+		return strings.Contains(string(msg.Data), `"type":"ping"`)
+	})
+	if pong.ConnId != "remote-1" {
+		t.Fatalf("pong delivered to %q", pong.ConnId)
+	}
+
+	//房间关闭代理连接后通知实例B
+	hubs.post("relay-room", false, func(h *roomHub) {
+		for c := range h.conns {
+			if c.id == "remote-1" {
+				h.kickout(c)
+			}
+		}
+	})
+	receiveOnB(t, b, kindClose, func(msg nodeMessage) bool {
+		return msg.ConnId == "remote-1"
+	})
+	if proxies.get("remote-1") != nil {
+		t.Fatal("proxy should be removed after close")
+	}
+
+	leaveRoom(message{nil, "relay-room", local.name, local, ""})
+	waitGone(t, "relay-room")
+}
+
+// TestRelayLocalClient 实例A上的客户端进入实例B上的房间：消息转发到B，B发回的消息交给客户端
+func TestRelayLocalClient(t *testing.T) {
+	b := startMemoryRelay(t)
+	c := &connection{send: make(chan []byte, 16), name: "traveler", uuid: "uuid-traveler"}
+	m := message{nil, "remote-room", c.name, c, ""}
+	joinRemote("node-b", m)
+	join := receiveOnB(t, b, kindJoin, nil)
+	if join.RoomId != "remote-room" || join.Uuid != "uuid-traveler" {
+		t.Fatalf("unexpected join %+v", join)
+	}
+
+	relayFrame(m, []byte("hello"))
+	frame := receiveOnB(t, b, kindFrame, nil)
+	if frame.ConnId != join.ConnId || string(frame.Data) != "hello" {
+		t.Fatalf("unexpected frame %+v", frame)
+	}
+
+	publishFromB(t, nodeMessage{Kind: kindDeliver, RoomId: "remote-room", ConnId: join.ConnId, Data: []byte("welcome")})
+	select {
+	case data := <-c.send:
+		if string(data) != "welcome" {
+			t.Fatalf("got %q", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("deliver not relayed to the client")
+	}
+
+	publishFromB(t, nodeMessage{Kind: kindClose, RoomId: "remote-room", ConnId: join.ConnId})
+	select {
+	case _, ok := <-c.send:
+		if ok {
+			t.Fatal("send should be closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("close not relayed to the client")
+	}
+}
+
+// TestRelayDeliverWhileLeaving 其他实例转发来的消息和客户端断开同时发生时不能往已关闭的发送队列里写
+func TestRelayDeliverWhileLeaving(t *testing.T) {
+	startMemoryRelay(t)
+	for i := 0; i < 200; i++ {
+		c := &connection{send: make(chan []byte, 1), id: "relay-conn", relayTo: "node-b"}
+		relays.add(c)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for j := 0; j < 100; j++ {
+				handleNodeMessage(nodeMessage{Kind: kindDeliver, ConnId: c.id, Data: []byte("frame")})
+			}
+		}()
+		//客户端断开，同时那边的实例也关闭了代理
+		message{conn: c, roomId: "relay-room"}.leave()
+		handleNodeMessage(nodeMessage{Kind: kindClose, ConnId: c.id})
+		<-done
+	}
+}
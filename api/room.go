@@ -0,0 +1,162 @@
+package api
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go-chess/dao/redis"
+	"go-chess/engine"
+	"go-chess/model"
+	"go-chess/util"
+	"strconv"
+	"strings"
+)
+
+// setHandicap 设置房间的让子，handicap为让子预设，removed为额外去掉的棋子(ICCS坐标，逗号分隔)
+func setHandicap(ctx *gin.Context) {
+	Iuuid, _ := ctx.Get("uuid")
+	uuid := Iuuid.(string)
+	roomId := ctx.Param("room_id")
+	handicap, err := strconv.Atoi(ctx.DefaultPostForm("handicap", "0"))
+	if err != nil {
+		util.RespErrorWithData(ctx, 400, "handicap error", "handicap must be a number")
+		return
+	}
+	removed := ctx.PostForm("removed")
+
+	if !checkRoomSetter(ctx, roomId, uuid, "handicap error") {
+		return
+	}
+
+	_, err = engine.NewHandicapPosition(handicap, splitSquares(removed))
+	if err != nil {
+		util.RespErrorWithData(ctx, 400, "handicap error", err.Error())
+		return
+	}
+	variant, err := redis.GetVariant(roomId)
+	if err != nil {
+		util.RespError(ctx, 400, "get variant error")
+		return
+	}
+	if variant == engine.VariantJieqi && (handicap != engine.HandicapNone || removed != "") {
+		util.RespErrorWithData(ctx, 400, "handicap error", "jieqi does not support handicap")
+		return
+	}
+
+	err = redis.SetHandicap(roomId, handicap, removed)
+	if err != nil {
+		util.RespError(ctx, 400, "set handicap error")
+		return
+	}
+	notifySettings(roomId, "让子")
+	util.RespSuccessful(ctx, "set handicap successful")
+}
+
+// setVariant 设置房间的变体，0为标准象棋，1为揭棋，2为暗棋
+func setVariant(ctx *gin.Context) {
+	Iuuid, _ := ctx.Get("uuid")
+	uuid := Iuuid.(string)
+	roomId := ctx.Param("room_id")
+	variant, err := strconv.Atoi(ctx.DefaultPostForm("variant", "0"))
+	if err != nil || variant < engine.VariantStandard || variant > engine.VariantBanqi {
+		util.RespErrorWithData(ctx, 400, "variant error", "unknown variant")
+		return
+	}
+
+	if !checkRoomSetter(ctx, roomId, uuid, "variant error") {
+		return
+	}
+
+	if variant == engine.VariantJieqi && hasBot(roomId) {
+		util.RespErrorWithData(ctx, 400, "variant error", "AI cannot play jieqi")
+		return
+	}
+	if variant == engine.VariantJieqi {
+		handicap, removed, err := redis.GetHandicap(roomId)
+		if err != nil {
+			util.RespError(ctx, 400, "get handicap error")
+			return
+		}
+		if handicap != engine.HandicapNone || removed != "" {
+			util.RespErrorWithData(ctx, 400, "variant error", "jieqi does not support handicap, clear the handicap first")
+			return
+		}
+	}
+
+	err = redis.SetVariant(roomId, variant)
+	if err != nil {
+		util.RespError(ctx, 400, "set variant error")
+		return
+	}
+	notifySettings(roomId, "变体")
+	util.RespSuccessful(ctx, "set variant successful")
+}
+
+// setRated 设置房间是否计算等级分，只有不让子的标准象棋计算
+func setRated(ctx *gin.Context) {
+	Iuuid, _ := ctx.Get("uuid")
+	uuid := Iuuid.(string)
+	roomId := ctx.Param("room_id")
+	rated, err := strconv.ParseBool(ctx.DefaultPostForm("rated", "false"))
+	if err != nil {
+		util.RespErrorWithData(ctx, 400, "rated error", "rated must be true or false")
+		return
+	}
+
+	if !checkRoomSetter(ctx, roomId, uuid, "rated error") {
+		return
+	}
+
+	err = redis.SetRated(roomId, rated)
+	if err != nil {
+		util.RespError(ctx, 400, "set rated error")
+		return
+	}
+	notifySettings(roomId, "计分")
+	util.RespSuccessful(ctx, "set rated successful")
+}
+
+// setClock 设置房间的用时，mode为计时方式，base、increment单位为秒
+func setClock(ctx *gin.Context) {
+	Iuuid, _ := ctx.Get("uuid")
+	uuid := Iuuid.(string)
+	roomId := ctx.Param("room_id")
+	tc, err := parseTimeControl(ctx.DefaultPostForm)
+	if err != nil {
+		util.RespErrorWithData(ctx, 400, "clock error", err.Error())
+		return
+	}
+
+	if !checkRoomSetter(ctx, roomId, uuid, "clock error") {
+		return
+	}
+
+	err = redis.SetClock(roomId, tc)
+	if err != nil {
+		util.RespError(ctx, 400, "set clock error")
+		return
+	}
+	notifySettings(roomId, "用时")
+	util.RespSuccessful(ctx, "set clock successful")
+}
+
+// parseTimeControl 从表单或query中读取用时设置
+func parseTimeControl(get func(key, defaultValue string) string) (model.TimeControl, error) {
+	tc := model.TimeControl{Mode: get("mode", model.ClockFischer)}
+	var errs [3]error
+	tc.Base, errs[0] = strconv.Atoi(get("base", "600"))
+	tc.Increment, errs[1] = strconv.Atoi(get("increment", "0"))
+	tc.Periods, errs[2] = strconv.Atoi(get("periods", "0"))
+	for _, err := range errs {
+		if err != nil {
+			return tc, errors.New("base, increment and periods must be numbers")
+		}
+	}
+	return tc, checkTimeControl(tc)
+}
+
+func splitSquares(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
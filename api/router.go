@@ -8,9 +8,17 @@ import (
 )
 
 func InitEngine() {
+	if err := initInvite(); err != nil {
+		log.Fatalln("init invite err:", err)
+	}
 	engine := gin.Default()
 	engine.Use(CORS())
-	go h.run()
+	initBroker()
+	go leaseLoop()
+	roomWrites.start()
+	records.start()
+	go matchLoop()
+	botLoop()
 
 	userGroup := engine.Group("/user")
 	{
@@ -19,14 +27,58 @@ func InitEngine() {
 		{
 			userGroup.Use(JWTAuth)
 			userGroup.PUT("/password", changePassword)
+			userGroup.GET("/profile", profile)
+			userGroup.GET("/stats", userStats)
 		}
 	}
 
+	gameGroup := engine.Group("/game")
+	{
+		gameGroup.Use(JWTAuth)
+		gameGroup.GET("", listGames)
+		gameGroup.GET("/:game_id", getGame)
+		gameGroup.GET("/:game_id/export", exportGame)
+	}
+
+	leaderboardGroup := engine.Group("/leaderboard")
+	{
+		leaderboardGroup.Use(JWTAuth)
+		leaderboardGroup.GET("/:category", topLeaderboard)
+		leaderboardGroup.GET("/:category/around", aroundLeaderboard)
+	}
+
+	roomGroup := engine.Group("/rooms")
+	{
+		roomGroup.Use(JWTAuth)
+		roomGroup.POST("", createRoom)
+		roomGroup.GET("", listRooms)
+		roomGroup.GET("/:room_id", getRoom)
+		roomGroup.POST("/:room_id/invite", createInvite)
+		roomGroup.GET("/:room_id/invite/qr", inviteQRCode)
+		roomGroup.POST("/:room_id/kick", kickUser)
+		roomGroup.POST("/:room_id/lock", lockRoom)
+		roomGroup.POST("/:room_id/owner", transferOwner)
+	}
+
+	adminGroup := engine.Group("/admin")
+	{
+		adminGroup.Use(JWTAuth, AdminAuth)
+		adminGroup.GET("/penalties", listPenalties)
+		adminGroup.GET("/penalties/:uuid", getPenalty)
+		adminGroup.DELETE("/penalties/:uuid", liftPenalty)
+	}
+
 	wsGroup := engine.Group("/")
 	{
 		wsGroup.Use(JWTAuth)
 		wsGroup.GET("/", serverWs)
+		wsGroup.GET("/match", matchWs)
 		wsGroup.GET("/ready/:room_id", ready)
+		wsGroup.POST("/handicap/:room_id", setHandicap)
+		wsGroup.POST("/variant/:room_id", setVariant)
+		wsGroup.POST("/clock/:room_id", setClock)
+		wsGroup.POST("/rated/:room_id", setRated)
+		wsGroup.POST("/bot/:room_id", addBot)
 	}
 
 	err := engine.Run(fmt.Sprintf(":%d", global.Settings.Port))
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"go-chess/dao/mysql"
+	"go-chess/engine"
+	"go-chess/model"
+	"go-chess/util"
+	"math"
+	"sort"
+	"strings"
+)
+
+const (
+	openingPlies = 2 //按红方第一步和黑方应着统计开局
+	topOpenings  = 5
+)
+
+type resultStats struct {
+	Games  int `json:"games"`
+	Wins   int `json:"wins"`
+	Draws  int `json:"draws"`
+	Losses int `json:"losses"`
+}
+
+type openingStats struct {
+	Moves string `json:"moves"`
+	resultStats
+}
+
+type statsInfo struct {
+	Uuid                  string         `json:"uuid"`
+	Name                  string         `json:"name"`
+	Total                 resultStats    `json:"total"`
+	Red                   resultStats    `json:"red"`
+	Black                 resultStats    `json:"black"`
+	AverageMoves          float64        `json:"average_moves"`    //平均步数(单方一步算一步)
+	AverageDuration       float64        `json:"average_duration"` //平均时长(秒)
+	Openings              []openingStats `json:"openings"`
+	LongestWinStreak      int            `json:"longest_win_streak"`
+	LongestLossStreak     int            `json:"longest_loss_streak"`
+	LongestUnbeatenStreak int            `json:"longest_unbeaten_streak"`
+}
+
+// add 记一局的结果，score为1胜 0.5和 0负
+func (s *resultStats) add(score float64) {
+	s.Games++
+	switch score {
+	case 1:
+		s.Wins++
+	case 0.5:
+		s.Draws++
+	default:
+		s.Losses++
+	}
+}
+
+// userStats 用户已结束对局的统计，不传uuid时查自己
+func userStats(ctx *gin.Context) {
+	Iuuid, _ := ctx.Get("uuid")
+	uuid := ctx.DefaultQuery("uuid", Iuuid.(string))
+
+	names, err := mysql.SelectUserNamesByUuids([]string{uuid})
+	if err != nil {
+		util.RespError(ctx, 400, "select user error")
+		return
+	}
+	name, ok := names[uuid]
+	if !ok {
+		util.RespErrorWithData(ctx, 400, "stats error", "user does not exist")
+		return
+	}
+	games, err := mysql.SelectFinishedGames(uuid)
+	if err != nil {
+		util.RespError(ctx, 400, "select games error")
+		return
+	}
+	openings, err := loadOpenings(games)
+	if err != nil {
+		util.RespError(ctx, 400, "select moves error")
+		return
+	}
+	util.RespSuccessfulWithData(ctx, "get stats successful", newStatsInfo(uuid, name, games, openings))
+}
+
+// loadOpenings 标准开局(不让子)对局的开局着法，key为对局id
+func loadOpenings(games []model.Game) (map[int64]string, error) {
+	startFen := engine.NewPosition().Fen()
+	var ids []int64
+	for _, game := range games {
+		if game.Variant == engine.VariantStandard && game.Handicap == engine.HandicapNone && game.StartFen == startFen && game.MoveCount >= openingPlies {
+			ids = append(ids, game.Id)
+		}
+	}
+	moves, err := mysql.SelectOpeningMoves(ids, openingPlies)
+	if err != nil {
+		return nil, err
+	}
+	plies := make(map[int64][]string, len(ids))
+	for _, mv := range moves {
+		plies[mv.GameId] = append(plies[mv.GameId], mv.Move)
+	}
+	openings := make(map[int64]string, len(plies))
+	for id, mvs := range plies {
+		if len(mvs) == openingPlies {
+			openings[id] = strings.Join(mvs, " ")
+		}
+	}
+	return openings, nil
+}
+
+// newStatsInfo 按开局时间顺序统计，连胜、连败、不败从前往后计算
+func newStatsInfo(uuid, name string, games []model.Game, openings map[int64]string) statsInfo {
+	info := statsInfo{Uuid: uuid, Name: name, Openings: []openingStats{}}
+	byMoves := make(map[string]*openingStats)
+	var totalMoves, totalSeconds float64
+	var wins, losses, unbeaten int
+	for _, game := range games {
+		side := engine.WinnerRed
+		if game.BlackUuid == uuid {
+			side = engine.WinnerBlack
+		}
+		score := 0.0
+		switch game.Result {
+		case side:
+			score = 1
+		case engine.WinnerDraw:
+			score = 0.5
+		}
+
+		info.Total.add(score)
+		if side == engine.WinnerRed {
+			info.Red.add(score)
+		} else {
+			info.Black.add(score)
+		}
+		totalMoves += float64(game.MoveCount)
+		if game.EndedAt != nil {
+			totalSeconds += game.EndedAt.Sub(game.StartedAt).Seconds()
+		}
+		if moves, ok := openings[game.Id]; ok {
+			o := byMoves[moves]
+			if o == nil {
+				o = &openingStats{Moves: moves}
+				byMoves[moves] = o
+			}
+			o.add(score)
+		}
+
+		wins, losses, unbeaten = streak(wins, score == 1), streak(losses, score == 0), streak(unbeaten, score > 0)
+		if wins > info.LongestWinStreak {
+			info.LongestWinStreak = wins
+		}
+		if losses > info.LongestLossStreak {
+			info.LongestLossStreak = losses
+		}
+		if unbeaten > info.LongestUnbeatenStreak {
+			info.LongestUnbeatenStreak = unbeaten
+		}
+	}
+	if n := float64(len(games)); n > 0 {
+		info.AverageMoves = math.Round(totalMoves/n*10) / 10
+		info.AverageDuration = math.Round(totalSeconds / n)
+	}
+
+	for _, o := range byMoves {
+		info.Openings = append(info.Openings, *o)
+	}
+	sort.Slice(info.Openings, func(i, j int) bool {
+		if info.Openings[i].Games != info.Openings[j].Games {
+			return info.Openings[i].Games > info.Openings[j].Games
+		}
+		return info.Openings[i].Moves < info.Openings[j].Moves
+	})
+	if len(info.Openings) > topOpenings {
+		info.Openings = info.Openings[:topOpenings]
+	}
+	return info
+}
+
+func streak(n int, keep bool) int {
+	if keep {
+		return n + 1
+	}
+	return 0
+}
@@ -6,10 +6,10 @@ import (
 	"github.com/gorilla/websocket"
 	"go-chess/dao/mysql"
 	"go-chess/dao/redis"
+	"go-chess/filter"
 	"go-chess/util"
 	"log"
 	"net/http"
-	"strings"
 	"time"
 )
 
@@ -21,40 +21,23 @@ const (
 )
 
 type connection struct {
-	ws            *websocket.Conn
-	send          chan []byte
-	limitNum      int
-	forbiddenWord bool
-	timeLog       int64
+	ws        *websocket.Conn
+	send      chan []byte
+	name      string
+	uuid      string
+	spectator bool
+	bot       int    //AI的难度，0表示真人
+	id        string //跨实例转发时连接的编号
+	relayTo   string //房间在其他实例上时，客户端消息转发到的实例
+	origin    string //其他实例上的连接在本实例的代理，origin为连接所在的实例
 }
 
 type message struct {
-	data   []byte
-	roomId string
-	name   string
-	conn   *connection
-}
-
-type hub struct {
-	rooms       map[string]map[*connection]bool
-	broadcast   chan message
-	broadcastss chan message
-	warnings    chan message
-	register    chan message
-	unregister  chan message
-	kickoutroom chan message
-	warnmsg     chan message
-}
-
-var h = hub{
-	broadcast:   make(chan message),
-	broadcastss: make(chan message),
-	warnings:    make(chan message),
-	warnmsg:     make(chan message),
-	register:    make(chan message),
-	unregister:  make(chan message),
-	kickoutroom: make(chan message),
-	rooms:       make(map[string]map[*connection]bool),
+	data    []byte
+	roomId  string
+	name    string
+	conn    *connection
+	channel string
 }
 
 func serverWs(ctx *gin.Context) {
@@ -63,17 +46,49 @@ func serverWs(ctx *gin.Context) {
 		fmt.Println("err:", err)
 		return
 	}
-	ws, err := upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
-	roomId := ctx.Request.Form["room_id"][0]
+	roomId := ctx.Request.Form.Get("room_id")
 	Iuuid, _ := ctx.Get("uuid")
 	uuid := Iuuid.(string)
+	//断线重连的玩家仍占着座位
+	inRoom, _ := redis.IsInRoom(roomId, uuid)
+	if !inRoom {
+		meta, err := redis.GetRoomMeta(roomId)
+		if err != nil {
+			util.RespError(ctx, 400, "get room error")
+			return
+		}
+		banned, err := redis.IsBanned(roomId, uuid)
+		if err != nil {
+			util.RespError(ctx, 400, "judge banned error")
+			return
+		}
+		if banned {
+			util.RespErrorWithData(ctx, 400, "join error", "you have been kicked out of the room")
+			return
+		}
+		if meta.Locked && meta.Owner != uuid {
+			util.RespErrorWithData(ctx, 400, "join error", "the room is locked")
+			return
+		}
+		if !checkRoomAccess(meta, roomId, uuid, ctx.Request.Form.Get("password"), ctx.Request.Form.Get("invite")) {
+			util.RespErrorWithData(ctx, 400, "join error", "wrong room password or invite")
+			return
+		}
+	}
+	ws, err := upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
 	num, err := redis.RoomNum(roomId)
 
-	if num == 2 || num == -1 {
-		log.Println("room is full or err")
-		util.RespError(ctx, 400, "room is full")
+	if num == -1 {
+		log.Println("room num err")
+		util.RespError(ctx, 400, "room num err")
 		return
 	}
+	//座位已满或主动观战时以观众身份进入，观众不占座位
+	spectator := !inRoom && (num >= 2 || ctx.Request.Form.Get("role") == "spectator")
+	if inRoom {
+		//匹配的房间双方都进入后不再等待
+		_ = redis.ArriveMatch(roomId, uuid, 2*joinTimeout)
+	}
 
 	flag, err := redis.IsAliveRoom(roomId)
 	if !flag {
@@ -83,9 +98,15 @@ func serverWs(ctx *gin.Context) {
 			util.RespError(ctx, 400, err)
 			return
 		}
+		err = redis.InitRoomOwner(roomId, uuid)
+		if err != nil {
+			log.Println(err)
+		}
 	}
 
-	err = redis.AddRoom(roomId, uuid)
+	if !spectator {
+		err = redis.AddRoom(roomId, uuid)
+	}
 
 	name, err := mysql.SelectUserNameByUUId(uuid)
 
@@ -94,10 +115,10 @@ func serverWs(ctx *gin.Context) {
 		return
 	}
 
-	c := &connection{send: make(chan []byte, 256), ws: ws}
-	m := message{nil, roomId, name, c}
+	c := &connection{send: make(chan []byte, 256), ws: ws, name: name, uuid: uuid, spectator: spectator}
+	m := message{nil, roomId, name, c, ""}
 
-	h.register <- m
+	enterRoom(m)
 
 	go m.writePump()
 	go m.readPump()
@@ -125,6 +146,12 @@ func ready(ctx *gin.Context) {
 		return
 	}
 
+	name, err := mysql.SelectUserNameByUUId(uuid)
+	if err != nil {
+		log.Println(err)
+	}
+	notifyReady(roomId, uuid, name, key == 1)
+
 	if key == 1 {
 		util.RespSuccessful(ctx, "ready successful")
 		return
@@ -149,7 +176,7 @@ func (m message) readPump() {
 	c := m.conn
 
 	defer func() {
-		h.unregister <- m
+		m.leave()
 		_ = c.ws.Close()
 	}()
 
@@ -176,48 +203,81 @@ func (m message) readPump() {
 			fmt.Println("err:", err)
 			break
 		}
-		go m.Limit(msg)
+		if c.relayTo != "" {
+			//房间在其他实例上，消息转发过去处理
+			relayFrame(m, msg)
+			continue
+		}
+		m.handleFrame(msg)
 	}
 }
 
-// Limit 发言违规进行时间限制禁言,超过三次禁言
-func (m message) Limit(msg []byte) {
+// handleFrame 处理客户端发来的一条消息，其他实例转发来的消息也在这里处理
+func (m message) handleFrame(msg []byte) {
 	c := m.conn
-	//查看是否禁言，并判断是否超过了禁言时间
-	timeNow := time.Now().Unix()
-	if timeNow-c.timeLog < 300 {
-		h.warnmsg <- m
+	env, payload, perr := parseFrame(msg, m.roomId)
+	r := request{message: m, typ: env.Type, seq: env.Seq}
+	if perr != nil {
+		r.typ, r.err = TypeError, perr
+		postRequest(r)
+		return
 	}
-
-	// 不合法信息3次，判断是否有不合法信息，没有进行信息发布
-	if c.limitNum >= 3 {
-		h.kickoutroom <- m
-		log.Println("素质太低，给你踢出去")
-		_ = c.ws.Close() //
-	} else //没有超过三次，可以继续
-	{
-		baseStr := "死傻操" //违法字符
-		testStr := string(msg[:])
-		for _, word := range testStr {
-			//遍历是否有违法字符
-			res := strings.Contains(baseStr, string(word))
-			if res == true {
-				c.limitNum += 1
-				c.forbiddenWord = true //禁言
-				//记录禁言开始时间
-				c.timeLog = time.Now().Unix()
-				h.warnings <- m
-				break
-			}
+	switch env.Type {
+	case TypeChat:
+		chat := payload.(chatPayload)
+		if chat.Channel == ChannelSpectator && !c.spectator {
+			r.typ, r.err = TypeError, &protoError{ErrBadPayload, "only spectators can use the spectator channel"}
+			postRequest(r)
+			return
 		}
-		// 不禁言，消息合法 可以发送
-		if c.forbiddenWord != true {
-			// 通过所有检查，进行广播
-
-			m := message{msg, m.roomId, m.name, c}
-			h.broadcast <- m
+		cm := m
+		cm.channel = chat.Channel
+		//在读消息的goroutine里检查，同一连接的违规按顺序计数
+		cm.Limit([]byte(chat.Text))
+	case TypeMove:
+		//着法交给房间裁判
+		r.move = payload.(movePayload).Move
+		postRequest(r)
+	case TypeReady:
+		if c.spectator {
+			r.typ, r.err = TypeError, &protoError{ErrSpectator, "spectators cannot get ready"}
+			postRequest(r)
+			return
 		}
+		err, key := redis.ReadySet(m.roomId, c.uuid)
+		if err != nil || key == 2 {
+			r.typ, r.err = TypeError, &protoError{ErrReady, "ready or cancel ready error"}
+			postRequest(r)
+			return
+		}
+		notifyReady(m.roomId, c.uuid, c.name, key == 1)
+	case TypeDrawReply, TypeTakebackReply:
+		r.accept = payload.(replyPayload).Accept
+		postRequest(r)
+	case TypePing, TypeResign, TypeDrawOffer, TypeTakebackOffer:
+		postRequest(r)
+	}
+}
+
+// Limit 检查聊天消息，违规时按次数禁言、踢出房间或禁止聊天
+func (m message) Limit(msg []byte) {
+	c := m.conn
+	//禁言和禁止聊天保存在redis中，重连后仍然有效，到期自动解除
+	if !checkPenalty(m) {
+		return
+	}
+	//按词表检查，mask模式下敏感词替换成*后照常发送
+	res := filter.Check(string(msg))
+	if res.Blocked {
+		log.Println("filter blocked", c.name, res.Words)
+		punish(m)
+		return
 	}
+	// 通过所有检查，进行广播
+	m.data = []byte(res.Text)
+	hubs.offer(m.roomId, func(h *roomHub) {
+		h.chat(m)
+	})
 }
 
 func (c *connection) write(mt int, payload []byte) error {
@@ -252,122 +312,3 @@ func (m *message) writePump() {
 		}
 	}
 }
-
-func (h *hub) run() {
-	for {
-		select {
-		case m := <-h.register:
-			conns := h.rooms[m.roomId]
-			if conns == nil {
-				conns = make(map[*connection]bool)
-				h.rooms[m.roomId] = conns
-			}
-			h.rooms[m.roomId][m.conn] = true
-
-			for con := range conns {
-				sysmsg := "系统消息：欢迎新伙伴" + m.name + "加入" + m.roomId + "聊天室！！！"
-				data := []byte(sysmsg)
-				select {
-				case con.send <- data:
-				}
-			}
-
-		case m := <-h.unregister: //断开链接
-			conns := h.rooms[m.roomId]
-			if conns != nil {
-				if _, ok := conns[m.conn]; ok {
-					delete(conns, m.conn) //删除链接
-					close(m.conn.send)
-					for con := range conns {
-						delMsg := "系统消息：" + m.name + "离开了" + m.roomId + "聊天室"
-						uuid, err := mysql.SelectUuidByName(m.name)
-						err = redis.DeleteUser(m.roomId, uuid)
-						if err != nil {
-							log.Println(err)
-						}
-						data := []byte(delMsg)
-						select {
-						case con.send <- data:
-						}
-						if len(conns) == 0 {
-							delete(h.rooms, m.roomId)
-						}
-					}
-				}
-			}
-
-		case m := <-h.kickoutroom: //3次不合法信息后，被踢出群聊
-			conns := h.rooms[m.roomId]
-			notice := "由于您多次发送不合法信息,已被踢出群聊！！！"
-			select {
-			case m.conn.send <- []byte(notice):
-			}
-			if conns != nil {
-				if _, ok := conns[m.conn]; ok {
-					delete(conns, m.conn)
-					close(m.conn.send)
-					if len(conns) == 0 {
-						delete(h.rooms, m.roomId)
-					}
-				}
-			}
-
-		case m := <-h.warnings:
-			conns := h.rooms[m.roomId]
-			if conns != nil {
-				if _, ok := conns[m.conn]; ok {
-					notice := "警告:您发布不合法信息，将禁言5分钟，三次后将被踢出群聊！！！"
-					select {
-					case m.conn.send <- []byte(notice):
-					}
-				}
-			}
-
-		case m := <-h.warnmsg: //禁言中提示
-			conns := h.rooms[m.roomId]
-			if conns != nil {
-				if _, ok := conns[m.conn]; ok {
-					notice := "您还在禁言中,暂时不能发送信息！！！"
-					select {
-					case m.conn.send <- []byte(notice):
-					}
-				}
-			}
-
-		case m := <-h.broadcast: //传输群信息/房间信息
-			conns := h.rooms[m.roomId]
-			for con := range conns {
-				if con == m.conn { //自己发送的信息，不用再发给自己
-					continue
-				}
-				select {
-				case con.send <- m.data:
-				default:
-					close(con.send)
-					delete(conns, con)
-					if len(conns) == 0 {
-						delete(h.rooms, m.roomId)
-					}
-				}
-			}
-
-		case m := <-h.broadcastss: //传输全员广播信息
-			for _, conns := range h.rooms {
-				for con := range conns {
-					if con == m.conn { //自己发送的信息，不用再发给自己
-						continue
-					}
-					select {
-					case con.send <- m.data:
-					default:
-						close(con.send)
-						delete(conns, con)
-						if len(conns) == 0 {
-							delete(h.rooms, m.roomId)
-						}
-					}
-				}
-			}
-		}
-	}
-}
@@ -0,0 +1,8 @@
+package broker
+
+// Broker 在多个api实例之间转发房间消息，每个实例订阅以自己编号为名的topic
+// 投递最多一次，订阅方处理不过来时消息可能丢失，和redis发布订阅一致
+type Broker interface {
+	Publish(topic string, data []byte) error
+	Subscribe(topic string) (<-chan []byte, error)
+}
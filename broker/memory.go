@@ -0,0 +1,40 @@
+package broker
+
+import (
+	"log"
+	"sync"
+)
+
+const memoryQueueSize = 1024
+
+// Memory 进程内的broker，单实例部署和测试时使用，不能跨实例
+type Memory struct {
+	sync.Mutex
+	subs map[string][]chan []byte
+}
+
+func NewMemory() *Memory {
+	return &Memory{subs: make(map[string][]chan []byte)}
+}
+
+// Publish 订阅方的队列已满时丢弃，发布方不阻塞
+func (m *Memory) Publish(topic string, data []byte) error {
+	m.Lock()
+	defer m.Unlock()
+	for _, ch := range m.subs[topic] {
+		select {
+		case ch <- data:
+		default:
+			log.Println("memory broker queue full, drop message to", topic)
+		}
+	}
+	return nil
+}
+
+func (m *Memory) Subscribe(topic string) (<-chan []byte, error) {
+	m.Lock()
+	defer m.Unlock()
+	ch := make(chan []byte, memoryQueueSize)
+	m.subs[topic] = append(m.subs[topic], ch)
+	return ch, nil
+}
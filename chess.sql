@@ -10,3 +10,76 @@ create table user
 )
     charset = utf8mb4;
 
+
+create table game
+(
+    id              bigint auto_increment
+        primary key,
+    room_id         varchar(64)  not null,
+    red_uuid        varchar(36)  not null,
+    black_uuid      varchar(36)  not null,
+    variant         int          not null default 0,
+    handicap        int          not null default 0,
+    removed         varchar(64)  not null default '',
+    clock_mode      varchar(10)  not null,
+    clock_base      int          not null,
+    clock_increment int          not null,
+    clock_periods   int          not null,
+    start_fen       varchar(100) not null,
+    seed            bigint       not null,
+    result          int          not null default -1,
+    reason          int          not null default 0,
+    move_count      int          not null default 0,
+    started_at      datetime     not null,
+    ended_at        datetime     null,
+    index idx_red (red_uuid),
+    index idx_black (black_uuid)
+)
+    charset = utf8mb4;
+
+create table game_move
+(
+    id         bigint auto_increment
+        primary key,
+    game_id    bigint       not null,
+    ply        int          not null,
+    side       int          not null,
+    move       varchar(4)   not null,
+    fen        varchar(100) not null,
+    hash       char(16)     not null default '',
+    clock_ms   bigint       not null,
+    created_at datetime     not null,
+    index idx_game_ply (game_id, ply)
+)
+    charset = utf8mb4;
+
+create table rating
+(
+    id         bigint auto_increment
+        primary key,
+    uuid       varchar(36) not null,
+    category   varchar(10) not null,
+    rating     double      not null,
+    deviation  double      not null,
+    volatility double      not null,
+    games      int         not null default 0,
+    updated_at datetime    not null,
+    unique index idx_uuid_category (uuid, category)
+)
+    charset = utf8mb4;
+
+create table rating_history
+(
+    id         bigint auto_increment
+        primary key,
+    uuid       varchar(36) not null,
+    category   varchar(10) not null,
+    game_id    bigint      not null,
+    rating     double      not null,
+    deviation  double      not null,
+    volatility double      not null,
+    delta      double      not null,
+    created_at datetime    not null,
+    index idx_uuid_category (uuid, category)
+)
+    charset = utf8mb4;
@@ -4,6 +4,7 @@ type ServerConfig struct {
 	Name      string      `mapstructure:"name"`
 	Port      int         `mapstructure:"port"`
 	RedisInfo RedisConfig `mapstructure:"redis"`
+	RuleInfo  RuleConfig  `mapstructure:"rule"`
 }
 
 type RedisConfig struct {
@@ -12,3 +13,8 @@ type RedisConfig struct {
 	Password string `mapstructure:"password"`
 	DB       int    `mapstructure:"db"`
 }
+
+type RuleConfig struct {
+	MoveLimit    int  `mapstructure:"moveLimit"`    //自然限着，每方多少回合未吃子判和
+	Insufficient bool `mapstructure:"insufficient"` //双方均无进攻子力时判和
+}
@@ -119,6 +119,38 @@ const (
 	HashPV = 3
 )
 
+//对局结束原因
+const (
+	//ReasonNone 对局未结束
+	ReasonNone = 0
+	//ReasonMate 将死
+	ReasonMate = 1
+	//ReasonStalemate 困毙
+	ReasonStalemate = 2
+	//ReasonRepetition 重复局面判和
+	ReasonRepetition = 3
+	//ReasonPerpetual 长将判负
+	ReasonPerpetual = 4
+	//ReasonMoveLimit 自然限着判和
+	ReasonMoveLimit = 5
+	//ReasonInsufficient 双方子力不足判和
+	ReasonInsufficient = 6
+)
+
+//让子预设，均由红方让子
+const (
+	//HandicapNone 不让子
+	HandicapNone = 0
+	//HandicapOneMa 让单马
+	HandicapOneMa = 1
+	//HandicapTwoMa 让双马
+	HandicapTwoMa = 2
+	//HandicapOneJu 让一车
+	HandicapOneJu = 3
+	//HandicapNine 让九子
+	HandicapNine = 4
+)
+
 //cucMvvLva MVV/LVA每种子力的价值
 var cucMvvLva = [24]int{
 	0, 0, 0, 0, 0, 0, 0, 0,
@@ -266,6 +298,15 @@ var cucpcStartup = [256]int{
 	0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
 	0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
 
+//各让子预设开局时去掉的格子
+var ccHandicapSquares = map[int][]int{
+	HandicapNone:  {},
+	HandicapOneMa: {0xc4},                                                 //左马
+	HandicapTwoMa: {0xc4, 0xca},                                           //双马
+	HandicapOneJu: {0xc3},                                                 //左车
+	HandicapNine:  {0xc3, 0xcb, 0xc4, 0xca, 0xa4, 0xaa, 0x95, 0x97, 0x99}, //双车双马双炮和三七路及中兵
+}
+
 //子力位置价值表
 var cucvlPiecePos = [7][256]int{
 	{ //帅(将)
@@ -517,3 +558,16 @@ func move(sqSrc, sqDst int) int {
 func mirrorMove(mv int) int {
 	return move(mirrorSquare(src(mv)), mirrorSquare(dst(mv)))
 }
+
+//ICCS坐标(如"b0")转换为格子，非法时返回0
+func iccsSquare(s string) int {
+	if len(s) != 2 || s[0] < 'a' || s[0] > 'i' || s[1] < '0' || s[1] > '9' {
+		return 0
+	}
+	return squareXY(int(s[0]-'a')+Left, Bottom-int(s[1]-'0'))
+}
+
+//格子转换为ICCS坐标
+func squareIccs(sq int) string {
+	return string([]byte{byte(getX(sq)-Left) + 'a', byte(Bottom-getY(sq)) + '0'})
+}
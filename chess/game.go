@@ -24,17 +24,20 @@ type Game struct {
 	bFlipped       bool                  //是否翻转棋盘
 	bGameOver      bool                  //是否游戏结束
 	showValue      string                //显示内容
+	nReason        int                   //对局结束原因
 	images         map[int]*ebiten.Image //图片资源
 	audios         map[int]*audio.Player //音效
 	audioContext   *audio.Context        //音效器
 	singlePosition *PositionStruct       //棋局单例
+	sqsHandicap    []int                 //让子时开局去掉的格子
 }
 
-func NewGame() bool {
+func NewGame(sqsHandicap []int) bool {
 	game := &Game{
 		images:         make(map[int]*ebiten.Image),
 		audios:         make(map[int]*audio.Player),
 		singlePosition: NewPositionStruct(),
+		sqsHandicap:    sqsHandicap,
 	}
 	if game == nil || game.singlePosition == nil {
 		return false
@@ -53,7 +56,7 @@ func NewGame() bool {
 	}
 
 	game.singlePosition.loadBook()
-	game.singlePosition.startup()
+	game.singlePosition.startupHandicap(game.sqsHandicap)
 
 	ebiten.SetWindowSize(BoardWidth, BoardHeight)
 	ebiten.SetWindowTitle("中国象棋")
@@ -70,9 +73,10 @@ func (g *Game) Update(screen *ebiten.Image) error {
 		if g.bGameOver {
 			g.bGameOver = false
 			g.showValue = ""
+			g.nReason = ReasonNone
 			g.sqSelected = 0
 			g.mvLast = 0
-			g.singlePosition.startup()
+			g.singlePosition.startupHandicap(g.sqsHandicap)
 		} else {
 			X, Y = ebiten.CursorPosition()
 			X = Left + (X-BoardEdge)/SquareSize
@@ -179,12 +183,22 @@ func (g *Game) clickSquare(sq int) {
 			if g.singlePosition.makeMove(mv) {
 				g.mvLast = mv
 				g.sqSelected = 0
+				//吃子后重新开始计算自然着法和重复局面
+				bCaptured := g.singlePosition.captured()
+				if bCaptured {
+					g.singlePosition.setIrrev()
+				}
 				//检查重复局面
 				vlRep := g.singlePosition.repStatus(3)
 				if g.singlePosition.isMate() {
 					//如果分出胜负，那么播放胜负的声音，并且弹出不带声音的提示框
 					g.playAudio()
 					g.showValue = "Your Win!"
+					if g.singlePosition.inCheck() {
+						g.nReason = ReasonMate
+					} else {
+						g.nReason = ReasonStalemate
+					}
 					g.bGameOver = true
 
 				} else if vlRep > 0 {
@@ -192,31 +206,31 @@ func (g *Game) clickSquare(sq int) {
 					if vlRep > WinValue {
 						g.playAudio()
 						g.showValue = "Your Lose!"
-
+						g.nReason = ReasonPerpetual
 					} else {
 						if vlRep < -WinValue {
 							g.playAudio()
 							g.showValue = "Your Win!"
-
+							g.nReason = ReasonPerpetual
 						} else {
 							g.playAudio()
 							g.showValue = "Your Draw!"
-
+							g.nReason = ReasonRepetition
 						}
 					}
 					g.bGameOver = true
-				} else if g.singlePosition.nMoveNum > 100 {
+				} else if reason := g.singlePosition.drawReason(Settings.RuleInfo); reason != ReasonNone {
 					g.playAudio()
 					g.showValue = "Your Draw!"
+					g.nReason = reason
 					g.bGameOver = true
 					return
 				} else {
 					if g.singlePosition.checked() {
 						g.playAudio()
 					} else {
-						if g.singlePosition.captured() {
+						if bCaptured {
 							g.playAudio()
-							g.singlePosition.setIrrev()
 						} else {
 							g.playAudio()
 						}
@@ -246,5 +260,17 @@ func (g *Game) messageBox(screen *ebiten.Image) {
 	})
 
 	text.Draw(screen, g.showValue, arcadeFont, 180, 288, color.White)
+	text.Draw(screen, reasonText[g.nReason], arcadeFont, 180, 304, color.White)
 	text.Draw(screen, "Click mouse to restart", arcadeFont, 100, 320, color.White)
 }
+
+// reasonText 结束原因的提示文字，字体只支持ASCII
+var reasonText = map[int]string{
+	ReasonNone:         "",
+	ReasonMate:         "Checkmate",
+	ReasonStalemate:    "Stalemate",
+	ReasonRepetition:   "Repetition",
+	ReasonPerpetual:    "Perpetual check",
+	ReasonMoveLimit:    "Move limit",
+	ReasonInsufficient: "No attackers",
+}
@@ -11,6 +11,9 @@ func InitConfig() {
 	v := viper.New()
 	//文件的路径如何设置
 	v.SetConfigFile("./setting-dev.yaml")
+	//和棋规则默认值，按中国象棋竞赛规则60回合不吃子判和
+	v.SetDefault("rule.moveLimit", 60)
+	v.SetDefault("rule.insufficient", true)
 	err := v.ReadInConfig()
 	if err != nil {
 		log.Println(err)
@@ -25,7 +25,16 @@ func main() {
 		}
 		err, readyNum := ReadyNum(roomId)
 		if readyNum == 2 {
-			NewGame()
+			err, handicap, removed := Handicap(roomId)
+			if err != nil {
+				continue
+			}
+			sqsHandicap, err := handicapSquares(handicap, removed)
+			if err != nil {
+				log.Println("handicap error, err:", err)
+				continue
+			}
+			NewGame(sqsHandicap)
 		} else {
 			fmt.Println("the room is not full")
 		}
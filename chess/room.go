@@ -2,6 +2,8 @@ package main
 
 import (
 	"log"
+	"strconv"
+	"strings"
 )
 
 func ReadyNum(roomId string) (error, int) {
@@ -12,3 +14,16 @@ func ReadyNum(roomId string) (error, int) {
 	}
 	return nil, len(es)
 }
+
+func Handicap(roomId string) (error, int, []string) {
+	val, err := rdb.HGetAll("handicap_" + roomId).Result()
+	if err != nil {
+		log.Println("handicap cache get error:", err)
+		return err, 0, nil
+	}
+	handicap, _ := strconv.Atoi(val["preset"])
+	if val["removed"] == "" {
+		return nil, handicap, nil
+	}
+	return nil, handicap, strings.Split(val["removed"], ",")
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"sort"
 	"strconv"
@@ -29,6 +31,17 @@ func (r *RC4Struct) initZero() {
 	}
 }
 
+func (r *RC4Struct) initKey(key []byte) {
+	j := 0
+	for i := 0; i < 256; i++ {
+		r.s[i] = i
+	}
+	for i := 0; i < 256; i++ {
+		j = (j + r.s[i] + int(key[i%len(key)])) & 255
+		r.s[i], r.s[j] = r.s[j], r.s[i]
+	}
+}
+
 func (r *RC4Struct) nextByte() uint32 {
 	r.x = (r.x + 1) & 255
 	r.y = (r.y + r.s[r.x]) & 255
@@ -44,20 +57,28 @@ func (r *RC4Struct) nextLong() uint32 {
 	return uc0 + (uc1 << 8) + (uc2 << 16) + (uc3 << 24)
 }
 
+// zobristHighKey 生成校验码高32位的RC4密钥，修改后已保存的局面校验码全部失效
+var zobristHighKey = []byte("go-chess")
+
+// nextLong64 低32位沿用原先零密钥RC4序列，保证与旧版开局库的32位校验码一致
+func nextLong64(rc4Low, rc4High *RC4Struct) uint64 {
+	return uint64(rc4Low.nextLong()) | uint64(rc4High.nextLong())<<32
+}
+
 type ZobristStruct struct {
-	dwKey   uint32
-	dwLock0 uint32
-	dwLock1 uint32
+	dwKey   uint64
+	dwLock0 uint64
+	dwLock1 uint64
 }
 
 func (z *ZobristStruct) initZero() {
 	z.dwKey, z.dwLock0, z.dwLock1 = 0, 0, 0
 }
 
-func (z *ZobristStruct) initRC4(rc4 *RC4Struct) {
-	z.dwKey = rc4.nextLong()
-	z.dwLock0 = rc4.nextLong()
-	z.dwLock1 = rc4.nextLong()
+func (z *ZobristStruct) initRC4(rc4Low, rc4High *RC4Struct) {
+	z.dwKey = nextLong64(rc4Low, rc4High)
+	z.dwLock0 = nextLong64(rc4Low, rc4High)
+	z.dwLock1 = nextLong64(rc4Low, rc4High)
 }
 
 func (z *ZobristStruct) xor1(zobr *ZobristStruct) {
@@ -78,13 +99,15 @@ type Zobrist struct {
 }
 
 func (z *Zobrist) initZobrist() {
-	rc4 := &RC4Struct{}
-	rc4.initZero()
-	z.Player.initRC4(rc4)
+	rc4Low := &RC4Struct{}
+	rc4Low.initZero()
+	rc4High := &RC4Struct{}
+	rc4High.initKey(zobristHighKey)
+	z.Player.initRC4(rc4Low, rc4High)
 	for i := 0; i < 14; i++ {
 		for j := 0; j < 256; j++ {
 			z.Table[i][j] = &ZobristStruct{}
-			z.Table[i][j].initRC4(rc4)
+			z.Table[i][j].initRC4(rc4Low, rc4High)
 		}
 	}
 }
@@ -93,10 +116,10 @@ type MoveStruct struct {
 	ucpcCaptured int  //是否吃子
 	ucbCheck     bool //是否将军
 	wmv          int  //走法
-	dwKey        uint32
+	dwKey        uint64
 }
 
-func (m *MoveStruct) set(mv, pcCaptured int, bCheck bool, dwKey uint32) {
+func (m *MoveStruct) set(mv, pcCaptured int, bCheck bool, dwKey uint64) {
 	m.wmv = mv
 	m.ucpcCaptured = pcCaptured
 	m.ucbCheck = bCheck
@@ -160,6 +183,8 @@ func (p *PositionStruct) loadBook() bool {
 	if reader == nil {
 		return false
 	}
+	//旧版book.dat只有32位校验码，全部不超过32位时按低32位查找
+	bLegacy := true
 
 	for {
 		line, _, err := reader.ReadLine()
@@ -175,12 +200,14 @@ func (p *PositionStruct) loadBook() bool {
 		tmpResult := strings.Split(tmpLine, ",")
 		if len(tmpResult) == 3 {
 			tmpItem := &BookItem{}
-			tmpdwLock, err := strconv.ParseUint(tmpResult[0], 10, 32)
+			tmpItem.dwLock, err = strconv.ParseUint(tmpResult[0], 10, 64)
 			if err != nil {
 				fmt.Print(err)
 				continue
 			}
-			tmpItem.dwLock = uint32(tmpdwLock)
+			if tmpItem.dwLock > math.MaxUint32 {
+				bLegacy = false
+			}
 			tmpwmv, err := strconv.ParseInt(tmpResult[1], 10, 32)
 			if err != nil {
 				fmt.Print(err)
@@ -197,9 +224,19 @@ func (p *PositionStruct) loadBook() bool {
 			p.search.BookTable = append(p.search.BookTable, tmpItem)
 		}
 	}
+	p.search.bLegacyBook = bLegacy
 	return true
 }
 
+// bookLock 开局库中查找局面用的校验码。随附的book.dat只存了32位校验码，多数局面无法由开局着法还原，
+// 不能换成64位重新生成；zobrist表的低32位沿用旧序列，按低32位查找即可继续使用
+func (p *PositionStruct) bookLock(zobr *ZobristStruct) uint64 {
+	if p.search.bLegacyBook {
+		return zobr.dwLock1 & math.MaxUint32
+	}
+	return zobr.dwLock1
+}
+
 func (p *PositionStruct) clearBoard() {
 	p.sdPlayer, p.vlRed, p.vlBlack, p.nDistance = 0, 0, 0, 0
 	for i := 0; i < 256; i++ {
@@ -214,17 +251,53 @@ func (p *PositionStruct) setIrrev() {
 }
 
 func (p *PositionStruct) startup() {
+	p.startupHandicap(nil)
+}
+
+// startupHandicap 去掉指定格子上的棋子后开局
+func (p *PositionStruct) startupHandicap(sqsRemoved []int) {
 	p.clearBoard()
 	pc := 0
 	for sq := 0; sq < 256; sq++ {
 		pc = cucpcStartup[sq]
-		if pc != 0 {
+		if pc != 0 && !containsSquare(sqsRemoved, sq) {
 			p.addPiece(sq, pc)
 		}
 	}
 	p.setIrrev()
 }
 
+func containsSquare(sqs []int, sq int) bool {
+	for _, v := range sqs {
+		if v == sq {
+			return true
+		}
+	}
+	return false
+}
+
+// handicapSquares 根据让子预设和自定义去掉的棋子(ICCS坐标)，得到开局时要去掉的格子
+func handicapSquares(nHandicap int, removed []string) ([]int, error) {
+	preset, ok := ccHandicapSquares[nHandicap]
+	if !ok {
+		return nil, fmt.Errorf("unknown handicap %d", nHandicap)
+	}
+	sqs := append([]int{}, preset...)
+	for _, v := range removed {
+		sq := iccsSquare(v)
+		if sq == 0 || cucpcStartup[sq] == 0 {
+			return nil, fmt.Errorf("no piece on %q", v)
+		}
+		if cucpcStartup[sq]&7 == PieceJiang {
+			return nil, errors.New("can not remove jiang")
+		}
+		if !containsSquare(sqs, sq) {
+			sqs = append(sqs, sq)
+		}
+	}
+	return sqs, nil
+}
+
 func (p *PositionStruct) changeSide() {
 	p.sdPlayer = 1 - p.sdPlayer
 	p.zobr.xor1(p.zobrist.Player)
@@ -691,6 +764,43 @@ func (p *PositionStruct) repValue(nRepStatus int) int {
 	return vlReturn
 }
 
+// hasAttacker 判断一方是否还有可以过河进攻的子力(车马炮兵)
+func (p *PositionStruct) hasAttacker(sd int) bool {
+	pcSelfSide := sideTag(sd)
+	for sq := 0; sq < 256; sq++ {
+		pc := p.ucpcSquares[sq]
+		if (pc & pcSelfSide) == 0 {
+			continue
+		}
+		switch pc - pcSelfSide {
+		case PieceMa, PieceJu, PiecePao, PieceBing:
+			return true
+		}
+	}
+	return false
+}
+
+// insufficientMaterial 双方都没有进攻子力，谁也无法将死对方
+func (p *PositionStruct) insufficientMaterial() bool {
+	return !p.hasAttacker(0) && !p.hasAttacker(1)
+}
+
+// drawReason 按和棋规则判断当前局面是否和棋，nMoveNum在吃子后由setIrrev重置，即为自然着法数
+func (p *PositionStruct) drawReason(rule RuleConfig) int {
+	if rule.Insufficient && p.insufficientMaterial() {
+		return ReasonInsufficient
+	}
+	//历史走法表长度有限，限着不能超过MaxMoves
+	nLimit := rule.MoveLimit * 2
+	if nLimit <= 0 || nLimit > MaxMoves-2 {
+		nLimit = MaxMoves - 2
+	}
+	if p.nMoveNum-1 >= nLimit {
+		return ReasonMoveLimit
+	}
+	return ReasonNone
+}
+
 func (p *PositionStruct) mirror(posMirror *PositionStruct) {
 	pc := 0
 	posMirror.clearBoard()
@@ -711,13 +821,13 @@ type HashItem struct {
 	ucFlag    int
 	svl       int
 	wmv       int
-	dwLock0   uint32
-	dwLock1   uint32
+	dwLock0   uint64
+	dwLock1   uint64
 	wReserved int
 }
 
 type BookItem struct {
-	dwLock uint32
+	dwLock uint64
 	wmv    int
 	wvl    int
 }
@@ -728,6 +838,7 @@ type Search struct {
 	mvKillers     [LimitDepth][2]int
 	hashTable     [HashSize]*HashItem
 	BookTable     []*BookItem
+	bLegacyBook   bool //开局库是否为32位校验码
 }
 
 func (p *PositionStruct) searchBook() int {
@@ -741,7 +852,7 @@ func (p *PositionStruct) searchBook() int {
 	}
 
 	bMirror := false
-	bkToSearch.dwLock = p.zobr.dwLock1
+	bkToSearch.dwLock = p.bookLock(p.zobr)
 	lpbk := sort.Search(bookSize, func(i int) bool {
 		return p.search.BookTable[i].dwLock >= bkToSearch.dwLock
 	})
@@ -750,7 +861,7 @@ func (p *PositionStruct) searchBook() int {
 		bMirror = true
 		posMirror := NewPositionStruct()
 		p.mirror(posMirror)
-		bkToSearch.dwLock = posMirror.zobr.dwLock1
+		bkToSearch.dwLock = p.bookLock(posMirror.zobr)
 		lpbk = sort.Search(bookSize, func(i int) bool {
 			return p.search.BookTable[i].dwLock >= bkToSearch.dwLock
 		})
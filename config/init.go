@@ -3,6 +3,7 @@ package config
 import (
 	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/viper"
+	"go-chess/filter"
 	"go-chess/global"
 	"go-chess/model"
 	"log"
@@ -13,6 +14,30 @@ func InitConfig() {
 	v := viper.New()
 	//文件的路径如何设置
 	v.SetConfigFile("./setting-dev.yaml")
+	//和棋规则默认值，按中国象棋竞赛规则60回合不吃子判和
+	v.SetDefault("rule.moveLimit", 60)
+	v.SetDefault("rule.insufficient", true)
+	//房间没有设置用时时的默认用时，10分钟每步加10秒
+	v.SetDefault("clock.mode", model.ClockFischer)
+	v.SetDefault("clock.base", 600)
+	v.SetDefault("clock.increment", 10)
+	v.SetDefault("clock.periods", 0)
+	v.SetDefault("room.grace", 60)
+	v.SetDefault("room.inviteUrl", "ws://42.192.155.29:6666/")
+	//邀请码密钥没有默认值，配置文件和环境变量都没有时api启动失败
+	_ = v.BindEnv("room.inviteSecret", "CHESS_INVITE_SECRET")
+	v.SetDefault("bot.workers", 4)
+	v.SetDefault("broker.type", "redis")
+	//聊天过滤默认拦下整条消息，词表按整词匹配，不会误伤"死活"、"打死"这样的正常用语
+	v.SetDefault("filter.mode", "block")
+	v.SetDefault("filter.words", []string{"傻逼", "操你妈", "草泥马", "去死", "死全家", "脑残"})
+	v.SetDefault("filter.aliases", map[string]string{"sb": "傻逼", "shabi": "傻逼", "煞笔": "傻逼", "nmsl": "你妈死了", "cnm": "操你妈"})
+	//违规一次禁言5分钟，一天内第3次踢出房间，第5次禁止聊天一天
+	v.SetDefault("moderation.strikeWindow", 86400)
+	v.SetDefault("moderation.mute", 300)
+	v.SetDefault("moderation.kickStrikes", 3)
+	v.SetDefault("moderation.banStrikes", 5)
+	v.SetDefault("moderation.ban", 86400)
 	err := v.ReadInConfig()
 	if err != nil {
 		log.Println(err)
@@ -25,10 +50,22 @@ func InitConfig() {
 	}
 	// 传递给全局变量
 	global.Settings = serverConfig
+	err = filter.Load(serverConfig.FilterInfo)
+	if err != nil {
+		log.Println("load filter err:", err)
+	}
 
-	//热重载配置
+	//热重载配置，聊天过滤的词表随配置文件更新
 	v.OnConfigChange(func(e fsnotify.Event) {
 		log.Printf("config file:%s Op:%s\n", e.Name, e.Op)
+		var filterConfig model.FilterConfig
+		err := v.UnmarshalKey("filter", &filterConfig)
+		if err == nil {
+			err = filter.Load(filterConfig)
+		}
+		if err != nil {
+			log.Println("reload filter err:", err)
+		}
 	})
 	v.WatchConfig()
 }
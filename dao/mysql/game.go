@@ -0,0 +1,138 @@
+package mysql
+
+import (
+	"go-chess/model"
+	"log"
+	"time"
+)
+
+func CreateGame(game *model.Game) error {
+	err := db.Create(game).Error
+	if err != nil {
+		log.Println("create game failed, err:", err)
+		return err
+	}
+	return nil
+}
+
+func AddGameMove(move *model.GameMove) error {
+	err := db.Create(move).Error
+	if err != nil {
+		log.Println("add game move failed, err:", err)
+		return err
+	}
+	return nil
+}
+
+func DeleteGameMoves(gameId int64, fromPly int) error { //悔棋后删除第fromPly步及之后的着法
+	err := db.Where("game_id = ? AND ply >= ?", gameId, fromPly).Delete(&model.GameMove{}).Error
+	if err != nil {
+		log.Println("delete game moves failed, err:", err)
+		return err
+	}
+	return nil
+}
+
+func FinishGame(gameId int64, result int, reason int, moveCount int, endedAt time.Time) error {
+	err := db.Model(&model.Game{}).Where("id = ?", gameId).Updates(map[string]interface{}{
+		"result":     result,
+		"reason":     reason,
+		"move_count": moveCount,
+		"ended_at":   endedAt,
+	}).Error
+	if err != nil {
+		log.Println("finish game failed, err:", err)
+		return err
+	}
+	return nil
+}
+
+func SelectGames(q model.GameQuery) ([]model.Game, int64, error) {
+	tx := db.Model(&model.Game{})
+	switch q.Color {
+	case "red":
+		tx = tx.Where("red_uuid = ?", q.Uuid)
+	case "black":
+		tx = tx.Where("black_uuid = ?", q.Uuid)
+	default:
+		tx = tx.Where("(red_uuid = ? OR black_uuid = ?)", q.Uuid, q.Uuid)
+	}
+	if q.Opponent != "" {
+		tx = tx.Where("((red_uuid = ? AND black_uuid = ?) OR (black_uuid = ? AND red_uuid = ?))", q.Uuid, q.Opponent, q.Uuid, q.Opponent)
+	}
+	switch q.Result {
+	case "win":
+		tx = tx.Where("((red_uuid = ? AND result = 0) OR (black_uuid = ? AND result = 1))", q.Uuid, q.Uuid)
+	case "loss":
+		tx = tx.Where("((red_uuid = ? AND result = 1) OR (black_uuid = ? AND result = 0))", q.Uuid, q.Uuid)
+	case "draw":
+		tx = tx.Where("result = 2")
+	}
+	if !q.From.IsZero() {
+		tx = tx.Where("started_at >= ?", q.From)
+	}
+	if !q.To.IsZero() {
+		tx = tx.Where("started_at < ?", q.To)
+	}
+	if q.FinishedOnly {
+		tx = tx.Where("result <> ?", model.ResultPlaying)
+	}
+
+	var total int64
+	err := tx.Count(&total).Error
+	if err != nil {
+		log.Println("count games failed, err:", err)
+		return nil, 0, err
+	}
+	var games []model.Game
+	err = tx.Order("id DESC").Offset((q.Page - 1) * q.Size).Limit(q.Size).Find(&games).Error
+	if err != nil {
+		log.Println("select games failed, err:", err)
+		return nil, 0, err
+	}
+	return games, total, nil
+}
+
+func SelectGameById(id int64) (model.Game, error) {
+	var game model.Game
+	err := db.Where("id = ?", id).First(&game).Error
+	if err != nil {
+		log.Println("select game failed, err:", err)
+		return game, err
+	}
+	return game, nil
+}
+
+func SelectGameMoves(gameId int64) ([]model.GameMove, error) {
+	var moves []model.GameMove
+	err := db.Where("game_id = ?", gameId).Order("ply").Find(&moves).Error
+	if err != nil {
+		log.Println("select game moves failed, err:", err)
+		return nil, err
+	}
+	return moves, nil
+}
+
+func SelectFinishedGames(uuid string) ([]model.Game, error) { //用户所有已结束的对局，按开局时间排序
+	var games []model.Game
+	err := db.Where("(red_uuid = ? OR black_uuid = ?) AND result <> ?", uuid, uuid, model.ResultPlaying).
+		Order("started_at, id").Find(&games).Error
+	if err != nil {
+		log.Println("select finished games failed, err:", err)
+		return nil, err
+	}
+	return games, nil
+}
+
+func SelectOpeningMoves(gameIds []int64, plies int) ([]model.GameMove, error) { //对局的前plies步
+	var moves []model.GameMove
+	if len(gameIds) == 0 {
+		return moves, nil
+	}
+	err := db.Where("game_id IN ? AND ply <= ?", gameIds, plies).Order("game_id, ply").Find(&moves).Error
+	if err != nil {
+		log.Println("select opening moves failed, err:", err)
+		return nil, err
+	}
+	return moves, nil
+}
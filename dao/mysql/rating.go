@@ -0,0 +1,99 @@
+package mysql
+
+import (
+	"errors"
+	"go-chess/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+	"time"
+)
+
+func SelectRating(uuid string, category string) (model.Rating, bool, error) { //没有记录时返回false
+	var rating model.Rating
+	err := db.Where("uuid = ? AND category = ?", uuid, category).First(&rating).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return rating, false, nil
+	}
+	if err != nil {
+		log.Println("select rating failed, err:", err)
+		return rating, false, err
+	}
+	return rating, true, nil
+}
+
+func SelectRatings(uuid string) ([]model.Rating, error) {
+	var ratings []model.Rating
+	err := db.Where("uuid = ?", uuid).Find(&ratings).Error
+	if err != nil {
+		log.Println("select ratings failed, err:", err)
+		return nil, err
+	}
+	return ratings, nil
+}
+
+func UpdateRatings(ratings [2]model.Rating, update func(curs [2]model.Rating) ([2]model.Rating, [2]model.RatingHistory)) error { //锁住双方的等级分，按最新值计算后写回并记录历史
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var curs [2]model.Rating
+		//按uuid顺序加锁，两局同时结束时不会死锁
+		order := [2]int{0, 1}
+		if ratings[1].Uuid < ratings[0].Uuid {
+			order = [2]int{1, 0}
+		}
+		for _, sd := range order {
+			r := ratings[sd]
+			if r.Id == 0 {
+				//第一次下计分棋的用户先插入默认等级分，已被另一局插入时忽略
+				r.UpdatedAt = time.Now()
+				if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&r).Error; err != nil {
+					return err
+				}
+			}
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("uuid = ? AND category = ?", r.Uuid, r.Category).
+				First(&curs[sd]).Error
+			if err != nil {
+				return err
+			}
+		}
+		nexts, histories := update(curs)
+		for sd := range nexts {
+			if err := tx.Save(&nexts[sd]).Error; err != nil {
+				return err
+			}
+			if err := tx.Create(&histories[sd]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("update ratings failed, err:", err)
+		return err
+	}
+	return nil
+}
+
+func SelectCategoryRatings(category string) ([]model.Rating, error) {
+	var ratings []model.Rating
+	err := db.Where("category = ?", category).Find(&ratings).Error
+	if err != nil {
+		log.Println("select category ratings failed, err:", err)
+		return nil, err
+	}
+	return ratings, nil
+}
+
+func SumRatingDeltas(category string, from time.Time) ([]model.RankEntry, error) { //from之后每个用户等级分的变化之和
+	var entries []model.RankEntry
+	err := db.Model(&model.RatingHistory{}).
+		Select("uuid, SUM(delta) AS score").
+		Where("category = ? AND created_at >= ?", category, from).
+		Group("uuid").
+		Scan(&entries).Error
+	if err != nil {
+		log.Println("sum rating deltas failed, err:", err)
+		return nil, err
+	}
+	return entries, nil
+}
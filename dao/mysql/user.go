@@ -23,5 +23,19 @@ func SelectUuidByName(name string) (string, error) {
 		log.Println("select failed, err:", err)
 		return "", err
 	}
-	return user.Name, nil
+	return user.Uuid, nil
+}
+
+func SelectUserNamesByUuids(uuids []string) (map[string]string, error) { //批量查询用户名，key为uuid
+	var users []model.User
+	err := db.Model(&model.User{}).Select("uuid", "name").Where("uuid IN ?", uuids).Find(&users).Error
+	if err != nil {
+		log.Println("select failed, err:", err)
+		return nil, err
+	}
+	names := make(map[string]string, len(users))
+	for _, user := range users {
+		names[user.Uuid] = user.Name
+	}
+	return names, nil
 }
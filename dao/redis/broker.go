@@ -0,0 +1,31 @@
+package redis
+
+import "log"
+
+// Broker 基于redis发布订阅的broker，多个api实例部署时使用
+type Broker struct{}
+
+func (Broker) Publish(topic string, data []byte) error {
+	err := rdb.Publish(topic, data).Err()
+	if err != nil {
+		log.Println("redis publish err:", err)
+		return err
+	}
+	return nil
+}
+
+func (Broker) Subscribe(topic string) (<-chan []byte, error) {
+	sub := rdb.Subscribe(topic)
+	//等订阅生效后再返回，之后发布的消息都能收到
+	if _, err := sub.Receive(); err != nil {
+		log.Println("redis subscribe err:", err)
+		return nil, err
+	}
+	ch := make(chan []byte)
+	go func() {
+		for msg := range sub.Channel() {
+			ch <- []byte(msg.Payload)
+		}
+	}()
+	return ch, nil
+}
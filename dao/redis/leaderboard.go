@@ -0,0 +1,110 @@
+package redis
+
+import (
+	"github.com/go-redis/redis"
+	"go-chess/model"
+	"go-chess/rating"
+	"log"
+	"time"
+)
+
+// periodTTL 周期排行榜在周期结束后保留一段时间再过期
+var periodTTL = map[string]time.Duration{
+	rating.PeriodWeek:  5 * 7 * 24 * time.Hour,
+	rating.PeriodMonth: 400 * 24 * time.Hour,
+}
+
+// LeaderboardKey 排行榜的有序集合，周期排行榜按周期开始的日期区分
+func LeaderboardKey(category, period string, now time.Time) string {
+	if period == rating.PeriodAll {
+		return "leaderboard_" + category
+	}
+	return "leaderboard_" + category + "_" + period + "_" + rating.PeriodStart(period, now).Format("20060102")
+}
+
+func UpdateLeaderboard(uuid, category string, score, delta float64, now time.Time) error { //一局计分对局结束后更新总榜和周期榜
+	pipe := rdb.TxPipeline()
+	pipe.ZAdd(LeaderboardKey(category, rating.PeriodAll, now), redis.Z{Score: score, Member: uuid})
+	for period, ttl := range periodTTL {
+		key := LeaderboardKey(category, period, now)
+		pipe.ZIncrBy(key, delta, uuid)
+		pipe.Expire(key, ttl)
+	}
+	_, err := pipe.Exec()
+	if err != nil {
+		log.Println("redis update leaderboard err:", err)
+		return err
+	}
+	return nil
+}
+
+func TopLeaderboard(key string, n int64) ([]model.RankEntry, error) {
+	zs, err := rdb.ZRevRangeWithScores(key, 0, n-1).Result()
+	if err != nil {
+		log.Println("redis get leaderboard err:", err)
+		return nil, err
+	}
+	return rankEntries(zs, 0), nil
+}
+
+func AroundLeaderboard(key string, uuid string, radius int64) ([]model.RankEntry, bool, error) { //用户前后各radius名，用户不在榜上时返回false
+	rank, err := rdb.ZRevRank(key, uuid).Result()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		log.Println("redis get rank err:", err)
+		return nil, false, err
+	}
+	start := rank - radius
+	if start < 0 {
+		start = 0
+	}
+	zs, err := rdb.ZRevRangeWithScores(key, start, rank+radius).Result()
+	if err != nil {
+		log.Println("redis get leaderboard err:", err)
+		return nil, false, err
+	}
+	return rankEntries(zs, start), true, nil
+}
+
+func RebuildLeaderboard(key string, entries []model.RankEntry, ttl time.Duration) error { //写入临时key后整体替换，重建期间排行榜仍可读
+	tmp := key + "_rebuild"
+	pipe := rdb.TxPipeline()
+	pipe.Del(tmp)
+	if len(entries) == 0 {
+		pipe.Del(key)
+	} else {
+		zs := make([]redis.Z, 0, len(entries))
+		for _, e := range entries {
+			zs = append(zs, redis.Z{Score: e.Score, Member: e.Uuid})
+		}
+		pipe.ZAdd(tmp, zs...)
+		pipe.Rename(tmp, key)
+		if ttl > 0 {
+			pipe.Expire(key, ttl)
+		}
+	}
+	_, err := pipe.Exec()
+	if err != nil {
+		log.Println("redis rebuild leaderboard err:", err)
+		return err
+	}
+	return nil
+}
+
+func PeriodTTL(period string) time.Duration {
+	return periodTTL[period]
+}
+
+func rankEntries(zs []redis.Z, start int64) []model.RankEntry {
+	entries := make([]model.RankEntry, 0, len(zs))
+	for i, z := range zs {
+		entries = append(entries, model.RankEntry{
+			Rank:  start + int64(i) + 1,
+			Uuid:  z.Member.(string),
+			Score: z.Score,
+		})
+	}
+	return entries
+}
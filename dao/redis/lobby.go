@@ -0,0 +1,121 @@
+package redis
+
+import (
+	"go-chess/model"
+	"log"
+	"strconv"
+)
+
+func CreateRoom(id string, meta model.RoomMeta) (bool, error) { //房间号已被占用时返回false
+	n, err := rdb.SAdd("room", id).Result()
+	if err != nil {
+		log.Println("redis create room err:", err)
+		return false, err
+	}
+	if n == 0 {
+		return false, nil
+	}
+	err = rdb.HMSet("meta_"+id, map[string]interface{}{
+		"owner":      meta.Owner,
+		"private":    meta.Private,
+		"password":   meta.Password,
+		"created_at": meta.CreatedAt,
+		"playing":    meta.Playing,
+	}).Err()
+	if err != nil {
+		log.Println("redis set room meta err:", err)
+		rdb.SRem("room", id)
+		return false, err
+	}
+	return true, nil
+}
+
+func GetRoomMeta(id string) (model.RoomMeta, error) { //没有通过大厅创建的房间没有created_at等字段
+	val, err := rdb.HGetAll("meta_" + id).Result()
+	if err != nil {
+		log.Println("redis get room meta err:", err)
+		return model.RoomMeta{}, err
+	}
+	meta := model.RoomMeta{
+		Owner:    val["owner"],
+		Private:  val["private"] == "1",
+		Password: val["password"],
+		Playing:  val["playing"] == "1",
+		Locked:   val["locked"] == "1",
+		Matched:  val["matched"] == "1",
+	}
+	meta.CreatedAt, _ = strconv.ParseInt(val["created_at"], 10, 64)
+	return meta, nil
+}
+
+func SetRoomPlaying(id string, playing bool) error {
+	err := rdb.HSet("meta_"+id, "playing", playing).Err()
+	if err != nil {
+		log.Println("redis set room playing err:", err)
+		return err
+	}
+	return nil
+}
+
+func SetRoomMatched(id string) error {
+	err := rdb.HSet("meta_"+id, "matched", true).Err()
+	if err != nil {
+		log.Println("redis set room matched err:", err)
+		return err
+	}
+	return nil
+}
+
+func RoomIds() ([]string, error) { //所有存活的房间
+	ids, err := rdb.SMembers("room").Result()
+	if err != nil {
+		log.Println("redis get rooms err:", err)
+		return nil, err
+	}
+	return ids, nil
+}
+
+func SetRoomOwner(id string, uuid string) error {
+	err := rdb.HSet("meta_"+id, "owner", uuid).Err()
+	if err != nil {
+		log.Println("redis set room owner err:", err)
+		return err
+	}
+	return nil
+}
+
+func InitRoomOwner(id string, uuid string) error { //按房间号直接进入新房间的用户成为房主，已有房主时不变
+	err := rdb.HSetNX("meta_"+id, "owner", uuid).Err()
+	if err != nil {
+		log.Println("redis init room owner err:", err)
+		return err
+	}
+	return nil
+}
+
+func SetRoomLocked(id string, locked bool) error {
+	err := rdb.HSet("meta_"+id, "locked", locked).Err()
+	if err != nil {
+		log.Println("redis set room locked err:", err)
+		return err
+	}
+	return nil
+}
+
+func BanUser(id string, uuid string) error { //被房主踢出的用户不能再进入该房间
+	err := rdb.SAdd("ban_"+id, uuid).Err()
+	if err != nil {
+		log.Println("redis ban user err:", err)
+		return err
+	}
+	return nil
+}
+
+func IsBanned(id string, uuid string) (bool, error) {
+	flag, err := rdb.SIsMember("ban_"+id, uuid).Result()
+	if err != nil {
+		log.Println("redis judge is banned err:", err)
+		return false, err
+	}
+	return flag, nil
+}
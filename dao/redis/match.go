@@ -0,0 +1,214 @@
+package redis
+
+import (
+	"encoding/json"
+	"github.com/go-redis/redis"
+	"go-chess/model"
+	"log"
+	"time"
+)
+
+const (
+	matchQueue   = "match_queue"   //uuid -> 匹配信息
+	matchLock    = "match_lock"    //同一时刻只有一个api实例在撮合
+	matchChannel = "match"         //匹配结果
+	matchPending = "match_pending" //房间号 -> 等待双方进入的匹配
+)
+
+// takeMatch 双方都还在队列中时一起移出，避免撮合时有人已经离开
+var takeMatch = `
+if redis.call("HEXISTS", KEYS[1], ARGV[1]) == 1 and redis.call("HEXISTS", KEYS[1], ARGV[2]) == 1 then
+	redis.call("HDEL", KEYS[1], ARGV[1], ARGV[2])
+	return 1
+end
+return 0
+`
+
+// touchMatch 票据仍在队列中时才续期，已被撮合取走或过期清理的票据不会被写回
+var touchMatch = `
+if redis.call("HEXISTS", KEYS[1], ARGV[1]) == 1 then
+	redis.call("HSET", KEYS[1], ARGV[1], ARGV[2])
+	return 1
+end
+return 0
+`
+
+// arriveMatch 记下进入房间的选手，双方都进入后匹配完成，不再等待
+var arriveMatch = `
+if redis.call("HEXISTS", KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call("SADD", KEYS[2], ARGV[2])
+redis.call("PEXPIRE", KEYS[2], ARGV[3])
+if redis.call("SCARD", KEYS[2]) >= 2 then
+	redis.call("HDEL", KEYS[1], ARGV[1])
+	redis.call("DEL", KEYS[2])
+end
+return 1
+`
+
+// takePending 超时的匹配只由一个实例处理，移出后返回已经进入房间的选手，已被取走时返回false
+var takePending = `
+if redis.call("HDEL", KEYS[1], ARGV[1]) == 0 then
+	return false
+end
+local arrived = redis.call("SMEMBERS", KEYS[2])
+redis.call("DEL", KEYS[2])
+return arrived
+`
+
+func JoinMatch(ticket model.MatchTicket) error {
+	data, err := json.Marshal(ticket)
+	if err != nil {
+		return err
+	}
+	err = rdb.HSet(matchQueue, ticket.Uuid, data).Err()
+	if err != nil {
+		log.Println("redis join match err:", err)
+		return err
+	}
+	return nil
+}
+
+func LeaveMatch(uuid string) error {
+	err := rdb.HDel(matchQueue, uuid).Err()
+	if err != nil {
+		log.Println("redis leave match err:", err)
+		return err
+	}
+	return nil
+}
+
+func TouchMatch(ticket model.MatchTicket) (bool, error) { //票据不在队列中时返回false
+	data, err := json.Marshal(ticket)
+	if err != nil {
+		return false, err
+	}
+	n, err := rdb.Eval(touchMatch, []string{matchQueue}, ticket.Uuid, data).Int()
+	if err != nil {
+		log.Println("redis touch match err:", err)
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func MatchTickets() ([]model.MatchTicket, error) {
+	val, err := rdb.HGetAll(matchQueue).Result()
+	if err != nil {
+		log.Println("redis get match queue err:", err)
+		return nil, err
+	}
+	tickets := make([]model.MatchTicket, 0, len(val))
+	for _, v := range val {
+		var ticket model.MatchTicket
+		if err := json.Unmarshal([]byte(v), &ticket); err != nil {
+			log.Println("unmarshal match ticket err:", err)
+			continue
+		}
+		tickets = append(tickets, ticket)
+	}
+	return tickets, nil
+}
+
+func TakeMatch(uuid1, uuid2 string) (bool, error) { //双方仍在队列中时移出并返回true
+	n, err := rdb.Eval(takeMatch, []string{matchQueue}, uuid1, uuid2).Int()
+	if err != nil {
+		log.Println("redis take match err:", err)
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func LockMatch(ttl time.Duration) (bool, error) {
+	ok, err := rdb.SetNX(matchLock, 1, ttl).Result()
+	if err != nil {
+		log.Println("redis lock match err:", err)
+		return false, err
+	}
+	return ok, nil
+}
+
+func PublishMatch(match model.Match) error {
+	data, err := json.Marshal(match)
+	if err != nil {
+		return err
+	}
+	err = rdb.Publish(matchChannel, data).Err()
+	if err != nil {
+		log.Println("redis publish match err:", err)
+		return err
+	}
+	return nil
+}
+
+func SubscribeMatch() <-chan model.Match { //所有api实例都会收到匹配结果，由持有连接的实例通知用户
+	matches := make(chan model.Match)
+	ch := rdb.Subscribe(matchChannel).Channel()
+	go func() {
+		for msg := range ch {
+			var match model.Match
+			if err := json.Unmarshal([]byte(msg.Payload), &match); err != nil {
+				log.Println("unmarshal match err:", err)
+				continue
+			}
+			matches <- match
+		}
+	}()
+	return matches
+}
+
+func AddPendingMatch(pending model.PendingMatch) error {
+	data, err := json.Marshal(pending)
+	if err != nil {
+		return err
+	}
+	err = rdb.HSet(matchPending, pending.RoomId, data).Err()
+	if err != nil {
+		log.Println("redis add pending match err:", err)
+		return err
+	}
+	return nil
+}
+
+func PendingMatches() ([]model.PendingMatch, error) {
+	val, err := rdb.HGetAll(matchPending).Result()
+	if err != nil {
+		log.Println("redis get pending matches err:", err)
+		return nil, err
+	}
+	pendings := make([]model.PendingMatch, 0, len(val))
+	for _, v := range val {
+		var pending model.PendingMatch
+		if err := json.Unmarshal([]byte(v), &pending); err != nil {
+			log.Println("unmarshal pending match err:", err)
+			continue
+		}
+		pendings = append(pendings, pending)
+	}
+	return pendings, nil
+}
+
+func ArriveMatch(roomId string, uuid string, ttl time.Duration) error { //不是等待中的匹配房间时什么也不做
+	err := rdb.Eval(arriveMatch, []string{matchPending, "arrived_" + roomId}, roomId, uuid, ttl.Milliseconds()).Err()
+	if err != nil {
+		log.Println("redis arrive match err:", err)
+		return err
+	}
+	return nil
+}
+
+func TakePendingMatch(roomId string) ([]string, bool, error) { //返回已经进入房间的选手，匹配已完成或已被其他实例取走时返回false
+	val, err := rdb.Eval(takePending, []string{matchPending, "arrived_" + roomId}, roomId).Result()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		log.Println("redis take pending match err:", err)
+		return nil, false, err
+	}
+	arrived := make([]string, 0, 2)
+	for _, v := range val.([]interface{}) {
+		arrived = append(arrived, v.(string))
+	}
+	return arrived, true, nil
+}
@@ -0,0 +1,96 @@
+package redis
+
+import (
+	"github.com/go-redis/redis"
+	"go-chess/model"
+	"log"
+	"time"
+)
+
+func AddStrike(uuid string, window time.Duration) (int64, error) { //记一次违规，返回统计周期内的违规次数，周期从最后一次违规算起
+	pipe := rdb.TxPipeline()
+	incr := pipe.Incr("strike_" + uuid)
+	pipe.Expire("strike_"+uuid, window)
+	pipe.SAdd("penalty", uuid)
+	_, err := pipe.Exec()
+	if err != nil {
+		log.Println("redis add strike err:", err)
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+func MuteUser(uuid string, d time.Duration) error { //禁言，到期自动解除
+	err := rdb.Set("mute_"+uuid, 1, d).Err()
+	if err != nil {
+		log.Println("redis mute user err:", err)
+		return err
+	}
+	return nil
+}
+
+func BanChat(uuid string, d time.Duration) error { //禁止聊天，所有房间都不能发言，到期自动解除
+	err := rdb.Set("chatban_"+uuid, 1, d).Err()
+	if err != nil {
+		log.Println("redis ban chat err:", err)
+		return err
+	}
+	return nil
+}
+
+func GetPenalty(uuid string) (model.Penalty, error) { //用户当前的违规次数和剩余的禁言、禁止聊天时间
+	pipe := rdb.Pipeline()
+	strikes := pipe.Get("strike_" + uuid)
+	mute := pipe.TTL("mute_" + uuid)
+	ban := pipe.TTL("chatban_" + uuid)
+	_, err := pipe.Exec()
+	if err != nil && err != redis.Nil {
+		log.Println("redis get penalty err:", err)
+		return model.Penalty{}, err
+	}
+	p := model.Penalty{Uuid: uuid}
+	p.Strikes, _ = strikes.Int64()
+	p.MuteLeft = remaining(mute.Val())
+	p.BanLeft = remaining(ban.Val())
+	return p, nil
+}
+
+func remaining(ttl time.Duration) int64 { //key不存在或没有过期时间时TTL为负数
+	if ttl < time.Second {
+		return 0
+	}
+	return int64(ttl / time.Second)
+}
+
+func ListPenalties() ([]model.Penalty, error) { //所有还有违规记录或处罚的用户，已全部过期的顺便移除
+	uuids, err := rdb.SMembers("penalty").Result()
+	if err != nil {
+		log.Println("redis list penalties err:", err)
+		return nil, err
+	}
+	penalties := make([]model.Penalty, 0, len(uuids))
+	for _, uuid := range uuids {
+		p, err := GetPenalty(uuid)
+		if err != nil {
+			return nil, err
+		}
+		if p.Strikes == 0 && p.MuteLeft == 0 && p.BanLeft == 0 {
+			rdb.SRem("penalty", uuid)
+			continue
+		}
+		penalties = append(penalties, p)
+	}
+	return penalties, nil
+}
+
+func LiftPenalty(uuid string) error { //解除禁言、禁止聊天并清空违规次数
+	pipe := rdb.TxPipeline()
+	pipe.Del("strike_"+uuid, "mute_"+uuid, "chatban_"+uuid)
+	pipe.SRem("penalty", uuid)
+	_, err := pipe.Exec()
+	if err != nil {
+		log.Println("redis lift penalty err:", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package redis
+
+import (
+	"github.com/go-redis/redis"
+	"log"
+	"time"
+)
+
+// refreshNode 房间没有所属实例或属于本实例时续期
+var refreshNode = `
+local node = redis.call("GET", KEYS[1])
+if node == false or node == ARGV[1] then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return 1
+end
+return 0
+`
+
+// releaseNode 房间仍属于本实例时才删除
+var releaseNode = `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`
+
+func ClaimRoomNode(roomId string, node string, ttl time.Duration) (string, error) { //房间没有所属实例时归本实例，返回房间所属的实例
+	ok, err := rdb.SetNX("node_"+roomId, node, ttl).Result()
+	if err != nil {
+		log.Println("redis claim room node err:", err)
+		return "", err
+	}
+	if ok {
+		return node, nil
+	}
+	return GetRoomNode(roomId)
+}
+
+func GetRoomNode(roomId string) (string, error) { //房间所属的实例，没有时为空
+	node, err := rdb.Get("node_" + roomId).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		log.Println("redis get room node err:", err)
+		return "", err
+	}
+	return node, nil
+}
+
+func RefreshRoomNodes(roomIds []string, node string, ttl time.Duration) error {
+	if len(roomIds) == 0 {
+		return nil
+	}
+	pipe := rdb.Pipeline()
+	for _, id := range roomIds {
+		pipe.Eval(refreshNode, []string{"node_" + id}, node, ttl.Milliseconds())
+	}
+	_, err := pipe.Exec()
+	if err != nil {
+		log.Println("redis refresh room nodes err:", err)
+		return err
+	}
+	return nil
+}
+
+func ReleaseRoomNode(roomId string, node string) error {
+	err := rdb.Eval(releaseNode, []string{"node_" + roomId}, node).Err()
+	if err != nil {
+		log.Println("redis release room node err:", err)
+		return err
+	}
+	return nil
+}
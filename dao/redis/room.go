@@ -1,9 +1,16 @@
 package redis
 
 import (
+	"github.com/go-redis/redis"
+	"go-chess/global"
+	"go-chess/model"
 	"log"
+	"strconv"
+	"time"
 )
 
+const emptyRoomGrace = 10 * time.Minute //新建房间在这段时间内没人也不清理
+
 func AddRoom(id string, uuid string) error {
 	err := rdb.SAdd("room_"+id, uuid).Err()
 	if err != nil {
@@ -22,6 +29,15 @@ func DeleteUser(id string, uuid string) error {
 	return nil
 }
 
+func RoomMembers(id string) ([]string, error) { //房间内占座的用户
+	es, err := rdb.SMembers("room_" + id).Result()
+	if err != nil {
+		log.Println("redis get room members err:", err)
+		return nil, err
+	}
+	return es, nil
+}
+
 func AddRoomId(id string) error {
 	err := rdb.SAdd("room", id).Err()
 	if err != nil {
@@ -72,6 +88,33 @@ func ReadySet(roomId string, uuid string) (error, int) {
 	}
 }
 
+func ReadyMembers(roomId string) ([]string, error) { //已准备的用户
+	uuids, err := rdb.SMembers("ready_" + roomId).Result()
+	if err != nil {
+		log.Println("get ready members error:", err)
+		return nil, err
+	}
+	return uuids, nil
+}
+
+func CancelReady(roomId string, uuid string) error {
+	err := rdb.SRem("ready_"+roomId, uuid).Err()
+	if err != nil {
+		log.Println("cancel ready error:", err)
+		return err
+	}
+	return nil
+}
+
+func ResetReady(roomId string) error { //对局结束后双方重新准备
+	err := rdb.Del("ready_" + roomId).Err()
+	if err != nil {
+		log.Println("reset ready error:", err)
+		return err
+	}
+	return nil
+}
+
 func IsInRoom(roomId string, uuid string) (bool, error) { //当前用户是否在此房间
 	flag, err := rdb.SIsMember("room_"+roomId, uuid).Result()
 	if err != nil {
@@ -93,9 +136,110 @@ func DeleteEmptyRoom() error {
 			log.Println(err)
 			return err
 		}
-		if len(es) <= 0 {
-			rdb.Del("room_" + v)
+		if len(es) > 0 {
+			continue
 		}
+		//大厅创建的房间留出时间等创建者进入
+		created, _ := rdb.HGet("meta_"+v, "created_at").Int64()
+		if time.Since(time.UnixMilli(created)) < emptyRoomGrace {
+			continue
+		}
+		rdb.Del("room_"+v, "ready_"+v, "handicap_"+v, "variant_"+v, "clock_"+v, "rated_"+v, "meta_"+v, "ban_"+v)
+		rdb.SRem("room", v)
 	}
 	return nil
 }
+
+func SetHandicap(roomId string, handicap int, removed string) error {
+	err := rdb.HMSet("handicap_"+roomId, map[string]interface{}{
+		"preset":  handicap,
+		"removed": removed,
+	}).Err()
+	if err != nil {
+		log.Println("redis set handicap err:", err)
+		return err
+	}
+	return nil
+}
+
+func GetHandicap(roomId string) (int, string, error) { //房间的让子设置，没有设置则不让子
+	val, err := rdb.HGetAll("handicap_" + roomId).Result()
+	if err != nil {
+		log.Println("redis get handicap err:", err)
+		return 0, "", err
+	}
+	handicap, _ := strconv.Atoi(val["preset"])
+	return handicap, val["removed"], nil
+}
+
+func SetVariant(roomId string, variant int) error {
+	err := rdb.Set("variant_"+roomId, variant, 0).Err()
+	if err != nil {
+		log.Println("redis set variant err:", err)
+		return err
+	}
+	return nil
+}
+
+func GetVariant(roomId string) (int, error) { //房间的变体，没有设置则为标准象棋
+	variant, err := rdb.Get("variant_" + roomId).Int()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		log.Println("redis get variant err:", err)
+		return 0, err
+	}
+	return variant, nil
+}
+
+func SetClock(roomId string, tc model.TimeControl) error {
+	err := rdb.HMSet("clock_"+roomId, map[string]interface{}{
+		"mode":      tc.Mode,
+		"base":      tc.Base,
+		"increment": tc.Increment,
+		"periods":   tc.Periods,
+	}).Err()
+	if err != nil {
+		log.Println("redis set clock err:", err)
+		return err
+	}
+	return nil
+}
+
+func GetClock(roomId string) (model.TimeControl, error) { //房间的用时，没有设置则使用配置文件中的默认用时
+	val, err := rdb.HGetAll("clock_" + roomId).Result()
+	if err != nil {
+		log.Println("redis get clock err:", err)
+		return global.Settings.ClockInfo, err
+	}
+	if len(val) == 0 {
+		return global.Settings.ClockInfo, nil
+	}
+	tc := model.TimeControl{Mode: val["mode"]}
+	tc.Base, _ = strconv.Atoi(val["base"])
+	tc.Increment, _ = strconv.Atoi(val["increment"])
+	tc.Periods, _ = strconv.Atoi(val["periods"])
+	return tc, nil
+}
+
+func SetRated(roomId string, rated bool) error {
+	err := rdb.Set("rated_"+roomId, rated, 0).Err()
+	if err != nil {
+		log.Println("redis set rated err:", err)
+		return err
+	}
+	return nil
+}
+
+func GetRated(roomId string) (bool, error) { //房间是否计算等级分，没有设置则不计算
+	rated, err := rdb.Get("rated_" + roomId).Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		log.Println("redis get rated err:", err)
+		return false, err
+	}
+	return rated == "1", nil
+}
@@ -0,0 +1,514 @@
+package engine
+
+import (
+	"go-chess/model"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 暗棋(翻翻棋)在4x8的半张棋盘上进行，32个棋子全部背面朝上打乱摆放。
+// 每步可以翻开一个暗子，或者把己方明子横竖走一格；吃子按等级大吃小，
+// 帅(将)不能吃兵(卒)而兵(卒)可以吃帅(将)，炮隔一子吃任意明子。
+// 简化规则：红方先走并固定执红，一方棋子被吃光或无棋可走判负。
+
+// 暗棋棋盘
+const (
+	BanqiFiles   = 8
+	BanqiRanks   = 4
+	BanqiSquares = BanqiFiles * BanqiRanks
+)
+
+// 暗棋吃子等级，大吃小
+var cucBanqiRank = [7]int{
+	PieceJiang: 6,
+	PieceShi:   5,
+	PieceXiang: 4,
+	PieceJu:    3,
+	PieceMa:    2,
+	PiecePao:   1,
+	PieceBing:  0,
+}
+
+// 暗棋子力价值，供AI估值
+var cucvlBanqi = [7]int{
+	PieceJiang: 600,
+	PieceShi:   270,
+	PieceXiang: 120,
+	PieceJu:    60,
+	PieceMa:    30,
+	PiecePao:   90,
+	PieceBing:  15,
+}
+
+// 每方各兵种的数量
+var cucnBanqiPieces = [7]int{
+	PieceJiang: 1,
+	PieceShi:   2,
+	PieceXiang: 2,
+	PieceJu:    2,
+	PieceMa:    2,
+	PiecePao:   2,
+	PieceBing:  5,
+}
+
+// 暗棋的上下左右步长
+var ccBanqiDelta = [4][2]int{{0, 1}, {0, -1}, {1, 0}, {-1, 0}}
+
+// 暗棋zobrist表，暗子只记位置不记棋子
+type banqiZobrist struct {
+	Player uint64
+	Table  [23][BanqiSquares]uint64
+	Hidden [BanqiSquares]uint64
+}
+
+var sharedBanqiZobrist = func() *banqiZobrist {
+	rc4Low := &RC4Struct{}
+	rc4Low.initKey([]byte("banqi-low"))
+	rc4High := &RC4Struct{}
+	rc4High.initKey([]byte("banqi-high"))
+	z := &banqiZobrist{}
+	z.Player = nextLong64(rc4Low, rc4High)
+	for pc := 8; pc < 23; pc++ {
+		for sq := 0; sq < BanqiSquares; sq++ {
+			z.Table[pc][sq] = nextLong64(rc4Low, rc4High)
+		}
+	}
+	for sq := 0; sq < BanqiSquares; sq++ {
+		z.Hidden[sq] = nextLong64(rc4Low, rc4High)
+	}
+	return z
+}()
+
+type BanqiStruct struct {
+	sdPlayer    int                //轮到谁走，0=红方，1=黑方
+	ucpcSquares [BanqiSquares]int  //棋盘上的棋子，暗子也保存真实棋子，只有裁判使用
+	bHidden     [BanqiSquares]bool //是否为暗子
+	ucnPool     [23]int            //尚未翻开的各棋子数量，AI只能根据它推算暗子
+	vlCaptured  [2]int             //各方被吃掉的子力价值
+	nNoProgress int                //连续没有吃子和翻子的步数
+	dwKey       uint64             //zobrist校验码
+	mvLast      int                //上一步走法
+	pcRevealed  int                //上一步翻开的棋子
+	search      *Search            //搜索时的计时状态，只在SearchMove期间存在
+}
+
+// NewBanqi 创建暗棋开局，相同的seed得到相同的暗子分布
+func NewBanqi(seed int64) *BanqiStruct {
+	b := &BanqiStruct{}
+	var pcs []int
+	for sd := 0; sd < 2; sd++ {
+		for pt, n := range cucnBanqiPieces {
+			for i := 0; i < n; i++ {
+				pcs = append(pcs, sideTag(sd)+pt)
+			}
+		}
+	}
+	r := rand.New(rand.NewSource(seed))
+	r.Shuffle(len(pcs), func(i, j int) {
+		pcs[i], pcs[j] = pcs[j], pcs[i]
+	})
+	for sq, pc := range pcs {
+		b.ucpcSquares[sq] = pc
+		b.bHidden[sq] = true
+		b.ucnPool[pc]++
+		b.dwKey ^= sharedBanqiZobrist.Hidden[sq]
+	}
+	return b
+}
+
+// banqiSquare 暗棋坐标(如"a0"，列a-h，行0-3)转换为格子，非法时返回-1
+func banqiSquare(s string) int {
+	if len(s) != 2 || s[0] < 'a' || s[0] >= 'a'+BanqiFiles || s[1] < '0' || s[1] >= '0'+BanqiRanks {
+		return -1
+	}
+	return int(s[1]-'0')*BanqiFiles + int(s[0]-'a')
+}
+
+func banqiIccs(sq int) string {
+	return string([]byte{byte(sq%BanqiFiles) + 'a', byte(sq/BanqiFiles) + '0'})
+}
+
+// banqiMove 暗棋走法，起点和终点相同表示翻子
+func banqiMove(sqSrc, sqDst int) int {
+	return sqSrc + sqDst*256
+}
+
+// banqiCanCapture 判断棋子能否按等级吃掉另一个棋子，炮另外处理
+func banqiCanCapture(pcSrc, pcDst int) bool {
+	ptSrc := pcSrc & 7
+	ptDst := pcDst & 7
+	if ptSrc == PieceJiang && ptDst == PieceBing {
+		return false
+	}
+	if ptSrc == PieceBing && ptDst == PieceJiang {
+		return true
+	}
+	return cucBanqiRank[ptSrc] >= cucBanqiRank[ptDst]
+}
+
+func banqiOnBoard(x, y int) bool {
+	return x >= 0 && x < BanqiFiles && y >= 0 && y < BanqiRanks
+}
+
+// cannonTarget 炮沿方向d越过空格找到炮架，再越过空格找到的第一个棋子，没有时返回-1
+func (b *BanqiStruct) cannonTarget(x, y int, d [2]int) int {
+	bScreen := false
+	for x, y = x+d[0], y+d[1]; banqiOnBoard(x, y); x, y = x+d[0], y+d[1] {
+		sq := y*BanqiFiles + x
+		if b.ucpcSquares[sq] == 0 {
+			continue
+		}
+		if bScreen {
+			return sq
+		}
+		bScreen = true
+	}
+	return -1
+}
+
+// generateMoves 生成全部走法，只读取明子，不会用到暗子的真实棋子
+func (b *BanqiStruct) generateMoves() []int {
+	var mvs []int
+	pcSelfSide := sideTag(b.sdPlayer)
+	pcOppSide := oppSideTag(b.sdPlayer)
+	for sqSrc := 0; sqSrc < BanqiSquares; sqSrc++ {
+		if b.bHidden[sqSrc] {
+			mvs = append(mvs, banqiMove(sqSrc, sqSrc))
+			continue
+		}
+		pcSrc := b.ucpcSquares[sqSrc]
+		if (pcSrc & pcSelfSide) == 0 {
+			continue
+		}
+		x, y := sqSrc%BanqiFiles, sqSrc/BanqiFiles
+		for _, d := range ccBanqiDelta {
+			nx, ny := x+d[0], y+d[1]
+			if !banqiOnBoard(nx, ny) {
+				continue
+			}
+			if pcSrc-pcSelfSide == PiecePao {
+				//炮隔一子吃子，炮架和目标前后都可以隔着空格
+				if sqDst := b.cannonTarget(x, y, d); sqDst >= 0 && !b.bHidden[sqDst] && (b.ucpcSquares[sqDst]&pcOppSide) != 0 {
+					mvs = append(mvs, banqiMove(sqSrc, sqDst))
+				}
+			}
+			sqDst := ny*BanqiFiles + nx
+			if b.ucpcSquares[sqDst] == 0 {
+				mvs = append(mvs, banqiMove(sqSrc, sqDst))
+				continue
+			}
+			if pcSrc-pcSelfSide == PiecePao {
+				continue
+			}
+			pcDst := b.ucpcSquares[sqDst]
+			if !b.bHidden[sqDst] && (pcDst&pcOppSide) != 0 && banqiCanCapture(pcSrc, pcDst) {
+				mvs = append(mvs, banqiMove(sqSrc, sqDst))
+			}
+		}
+	}
+	return mvs
+}
+
+// makeMove 走棋，翻子时pc为翻开的棋子，返回被吃的棋子
+func (b *BanqiStruct) makeMove(mv, pc int) int {
+	sqSrc, sqDst := src(mv), dst(mv)
+	pcCaptured := 0
+	if sqSrc == sqDst {
+		b.ucpcSquares[sqSrc] = pc
+		b.bHidden[sqSrc] = false
+		b.ucnPool[pc]--
+		b.dwKey ^= sharedBanqiZobrist.Hidden[sqSrc] ^ sharedBanqiZobrist.Table[pc][sqSrc]
+	} else {
+		pcCaptured = b.ucpcSquares[sqDst]
+		pcSrc := b.ucpcSquares[sqSrc]
+		if pcCaptured != 0 {
+			b.vlCaptured[1-b.sdPlayer] += cucvlBanqi[pcCaptured&7]
+			b.dwKey ^= sharedBanqiZobrist.Table[pcCaptured][sqDst]
+		}
+		b.ucpcSquares[sqDst] = pcSrc
+		b.ucpcSquares[sqSrc] = 0
+		b.dwKey ^= sharedBanqiZobrist.Table[pcSrc][sqSrc] ^ sharedBanqiZobrist.Table[pcSrc][sqDst]
+	}
+	b.sdPlayer = 1 - b.sdPlayer
+	b.dwKey ^= sharedBanqiZobrist.Player
+	return pcCaptured
+}
+
+func (b *BanqiStruct) undoMakeMove(mv, pcCaptured int) {
+	sqSrc, sqDst := src(mv), dst(mv)
+	b.sdPlayer = 1 - b.sdPlayer
+	b.dwKey ^= sharedBanqiZobrist.Player
+	if sqSrc == sqDst {
+		pc := b.ucpcSquares[sqSrc]
+		b.bHidden[sqSrc] = true
+		b.ucnPool[pc]++
+		b.dwKey ^= sharedBanqiZobrist.Hidden[sqSrc] ^ sharedBanqiZobrist.Table[pc][sqSrc]
+		return
+	}
+	pcSrc := b.ucpcSquares[sqDst]
+	b.ucpcSquares[sqSrc] = pcSrc
+	b.ucpcSquares[sqDst] = pcCaptured
+	b.dwKey ^= sharedBanqiZobrist.Table[pcSrc][sqSrc] ^ sharedBanqiZobrist.Table[pcSrc][sqDst]
+	if pcCaptured != 0 {
+		b.vlCaptured[1-b.sdPlayer] -= cucvlBanqi[pcCaptured&7]
+		b.dwKey ^= sharedBanqiZobrist.Table[pcCaptured][sqDst]
+	}
+}
+
+// pieceCount 一方剩余的棋子数，包括暗子
+func (b *BanqiStruct) pieceCount(sd int) int {
+	n := 0
+	for sq := 0; sq < BanqiSquares; sq++ {
+		if (b.ucpcSquares[sq] & sideTag(sd)) != 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// evaluate 以走子方为准的子力差，暗子对双方一样，只需比较被吃掉的子力
+func (b *BanqiStruct) evaluate() int {
+	return b.vlCaptured[1-b.sdPlayer] - b.vlCaptured[b.sdPlayer]
+}
+
+// searchFull Alpha-Beta搜索，翻子看作机会节点，按暗子池中各棋子的概率取平均
+func (b *BanqiStruct) searchFull(vlAlpha, vlBeta, nDepth int) int {
+	if b.timeout() {
+		return 0
+	}
+	if nDepth <= 0 {
+		return b.evaluate()
+	}
+	mvs := b.generateMoves()
+	if len(mvs) == 0 {
+		return -MateValue
+	}
+	for _, mv := range mvs {
+		vl := 0
+		if src(mv) == dst(mv) {
+			vl = b.flipValue(mv, nDepth)
+		} else {
+			pcCaptured := b.makeMove(mv, 0)
+			vl = -b.searchFull(-vlBeta, -vlAlpha, nDepth-1)
+			b.undoMakeMove(mv, pcCaptured)
+		}
+		if b.search != nil && b.search.bStop {
+			return 0
+		}
+		if vl >= vlBeta {
+			return vl
+		}
+		if vl > vlAlpha {
+			vlAlpha = vl
+		}
+	}
+	return vlAlpha
+}
+
+// flipValue 翻子的期望分值
+func (b *BanqiStruct) flipValue(mv, nDepth int) int {
+	sq := src(mv)
+	pcHidden := b.ucpcSquares[sq]
+	nTotal, vlTotal := 0, 0
+	for pc := 8; pc < 23; pc++ {
+		n := b.ucnPool[pc]
+		if n == 0 {
+			continue
+		}
+		//假设翻出的是pc，搜索结束后换回真实棋子
+		b.ucpcSquares[sq] = pc
+		b.makeMove(mv, pc)
+		vlTotal -= n * b.searchFull(-MateValue, MateValue, nDepth-1)
+		b.undoMakeMove(mv, 0)
+		nTotal += n
+	}
+	b.ucpcSquares[sq] = pcHidden
+	if nTotal == 0 {
+		return b.evaluate()
+	}
+	return vlTotal / nTotal
+}
+
+// timeout 定期检查是否到时，不在SearchMove中时不限时
+func (b *BanqiStruct) timeout() bool {
+	if b.search == nil {
+		return false
+	}
+	if b.search.bStop {
+		return true
+	}
+	b.search.nNodes++
+	if b.search.nNodes%nodesPerCheck == 0 && time.Now().After(b.search.tmDeadline) {
+		b.search.bStop = true
+	}
+	return b.search.bStop
+}
+
+// searchBest 搜索最佳走法，分值相同的走法随机选一个，超时后的结果不可信
+func (b *BanqiStruct) searchBest(nDepth int) int {
+	mvs := b.generateMoves()
+	if len(mvs) == 0 {
+		return 0
+	}
+	rand.Shuffle(len(mvs), func(i, j int) {
+		mvs[i], mvs[j] = mvs[j], mvs[i]
+	})
+	vlBest, mvBest := -MateValue-1, mvs[0]
+	for _, mv := range mvs {
+		vl := 0
+		if src(mv) == dst(mv) {
+			vl = b.flipValue(mv, nDepth)
+		} else {
+			pcCaptured := b.makeMove(mv, 0)
+			vl = -b.searchFull(-MateValue, -vlBest, nDepth-1)
+			b.undoMakeMove(mv, pcCaptured)
+		}
+		if b.search != nil && b.search.bStop {
+			return mvBest
+		}
+		if vl > vlBest {
+			vlBest, mvBest = vl, mv
+		}
+	}
+	return mvBest
+}
+
+// Variant 棋局的变体
+func (b *BanqiStruct) Variant() int {
+	return VariantBanqi
+}
+
+// Side 轮到哪方走棋，0=红方，1=黑方
+func (b *BanqiStruct) Side() int {
+	return b.sdPlayer
+}
+
+// Hash 局面的64位zobrist校验码
+func (b *BanqiStruct) Hash() uint64 {
+	return b.dwKey
+}
+
+// PlayMove 走棋，着法如"a0b0"，翻子只写一个格子如"a0"
+func (b *BanqiStruct) PlayMove(iccs string) bool {
+	var mv int
+	switch len(iccs) {
+	case 2:
+		sq := banqiSquare(iccs)
+		if sq < 0 {
+			return false
+		}
+		mv = banqiMove(sq, sq)
+	case 4:
+		sqSrc, sqDst := banqiSquare(iccs[:2]), banqiSquare(iccs[2:])
+		if sqSrc < 0 || sqDst < 0 || sqSrc == sqDst {
+			return false
+		}
+		mv = banqiMove(sqSrc, sqDst)
+	default:
+		return false
+	}
+	for _, v := range b.generateMoves() {
+		if v != mv {
+			continue
+		}
+		b.pcRevealed = 0
+		if src(mv) == dst(mv) {
+			b.pcRevealed = b.ucpcSquares[src(mv)]
+		}
+		pcCaptured := b.makeMove(mv, b.ucpcSquares[src(mv)])
+		if pcCaptured != 0 || src(mv) == dst(mv) {
+			b.nNoProgress = 0
+		} else {
+			b.nNoProgress++
+		}
+		b.mvLast = mv
+		return true
+	}
+	return false
+}
+
+// MoveString 走法转换为字符串，翻子只有一个格子
+func (b *BanqiStruct) MoveString(mv int) string {
+	if src(mv) == dst(mv) {
+		return banqiIccs(src(mv))
+	}
+	return banqiIccs(src(mv)) + banqiIccs(dst(mv))
+}
+
+// LastReveal 上一步翻开的棋子，返回格子和棋子(FEN字母)，没有翻子时返回空串
+func (b *BanqiStruct) LastReveal() (string, string) {
+	if b.pcRevealed == 0 {
+		return "", ""
+	}
+	return banqiIccs(src(b.mvLast)), string(pieceChar(b.pcRevealed))
+}
+
+// HasAttacker 暗棋中只要还有棋子就可能取胜
+func (b *BanqiStruct) HasAttacker(sd int) bool {
+	return b.pieceCount(sd) > 0
+}
+
+// Judge 判断对局结果，返回胜方和结束原因，原因为ReasonNone时对局继续
+func (b *BanqiStruct) Judge(rule model.RuleConfig) (int, int) {
+	if b.pieceCount(b.sdPlayer) == 0 {
+		return 1 - b.sdPlayer, ReasonNoPieces
+	}
+	if len(b.generateMoves()) == 0 {
+		return 1 - b.sdPlayer, ReasonStalemate
+	}
+	nLimit := rule.MoveLimit * 2
+	if nLimit <= 0 {
+		nLimit = MaxMoves - 2
+	}
+	if b.nNoProgress >= nLimit {
+		return WinnerDraw, ReasonMoveLimit
+	}
+	return WinnerDraw, ReasonNone
+}
+
+// Fen 局面字符串，从第3行到第0行，暗子用?表示，最后是走子方
+func (b *BanqiStruct) Fen() string {
+	var sb strings.Builder
+	for y := BanqiRanks - 1; y >= 0; y-- {
+		nEmpty := 0
+		for x := 0; x < BanqiFiles; x++ {
+			sq := y*BanqiFiles + x
+			if b.ucpcSquares[sq] == 0 {
+				nEmpty++
+				continue
+			}
+			if nEmpty > 0 {
+				sb.WriteString(strconv.Itoa(nEmpty))
+				nEmpty = 0
+			}
+			if b.bHidden[sq] {
+				sb.WriteByte('?')
+			} else {
+				sb.WriteByte(pieceChar(b.ucpcSquares[sq]))
+			}
+		}
+		if nEmpty > 0 {
+			sb.WriteString(strconv.Itoa(nEmpty))
+		}
+		if y > 0 {
+			sb.WriteByte('/')
+		}
+	}
+	if b.sdPlayer == 0 {
+		sb.WriteString(" w")
+	} else {
+		sb.WriteString(" b")
+	}
+	return sb.String()
+}
+
+// BestMove AI搜索的走法，nDepth为搜索深度
+func (b *BanqiStruct) BestMove(nDepth int) string {
+	mv := b.searchBest(nDepth)
+	if mv == 0 && len(b.generateMoves()) == 0 {
+		return ""
+	}
+	return b.MoveString(mv)
+}
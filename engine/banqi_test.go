@@ -0,0 +1,75 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+// banqiBoard 只摆指定棋子的暗棋局面，hidden中的格子为暗子，红方走
+func banqiBoard(pieces map[string]int, hidden ...string) *BanqiStruct {
+	b := &BanqiStruct{}
+	for s, pc := range pieces {
+		b.ucpcSquares[banqiSquare(s)] = pc
+	}
+	for _, s := range hidden {
+		b.bHidden[banqiSquare(s)] = true
+	}
+	return b
+}
+
+func TestBanqiCannon(t *testing.T) {
+	red, black := sideTag(0), sideTag(1)
+	tests := []struct {
+		name   string
+		pieces map[string]int
+		hidden []string
+		move   string
+		legal  bool
+	}{
+		{"adjacent screen", map[string]int{"a0": red + PiecePao, "b0": black + PieceBing, "c0": black + PieceJu}, nil, "a0c0", true},
+		{"screen after empty squares", map[string]int{"a0": red + PiecePao, "d0": black + PieceBing, "g0": black + PieceJu}, nil, "a0g0", true},
+		{"hidden screen", map[string]int{"a0": red + PiecePao, "c0": black + PieceBing, "e0": black + PieceJiang}, []string{"c0"}, "a0e0", true},
+		{"vertical", map[string]int{"b0": red + PiecePao, "b2": red + PieceBing, "b3": black + PieceShi}, nil, "b0b3", true},
+		{"only the first piece behind the screen", map[string]int{"a0": red + PiecePao, "c0": black + PieceBing, "e0": black + PieceMa, "g0": black + PieceJu}, nil, "a0g0", false},
+		{"no screen", map[string]int{"a0": red + PiecePao, "g0": black + PieceJu}, nil, "a0g0", false},
+		{"hidden target", map[string]int{"a0": red + PiecePao, "c0": black + PieceBing, "e0": black + PieceJu}, []string{"e0"}, "a0e0", false},
+		{"own target", map[string]int{"a0": red + PiecePao, "c0": black + PieceBing, "e0": red + PieceJu}, nil, "a0e0", false},
+		{"one step to empty square", map[string]int{"a0": red + PiecePao}, nil, "a0b0", true},
+		{"no plain capture", map[string]int{"a0": red + PiecePao, "b0": black + PieceBing}, nil, "a0b0", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := banqiBoard(tt.pieces, tt.hidden...)
+			target := b.ucpcSquares[banqiSquare(tt.move[2:])]
+			if got := b.PlayMove(tt.move); got != tt.legal {
+				t.Fatalf("PlayMove(%s) = %v, want %v", tt.move, got, tt.legal)
+			}
+			if tt.legal && target != 0 && b.vlCaptured[1] != cucvlBanqi[target&7] {
+				t.Errorf("captured value = %d, want %d", b.vlCaptured[1], cucvlBanqi[target&7])
+			}
+		})
+	}
+}
+
+func TestBanqiSearchDeadline(t *testing.T) {
+	for _, think := range []time.Duration{-time.Second, 50 * time.Millisecond} {
+		b := NewBanqi(1)
+		fen := b.Fen()
+		start := time.Now()
+		mv := b.SearchMove(BanqiMaxDepth, 0, start.Add(think))
+		//每nodesPerCheck个节点查一次钟，超出的时间应远小于完整的一层
+		limit := 200 * time.Millisecond
+		if think > 0 {
+			limit += think
+		}
+		if elapsed := time.Since(start); elapsed > limit {
+			t.Errorf("think %v: search took %v", think, elapsed)
+		}
+		if b.Fen() != fen || b.search != nil {
+			t.Fatalf("think %v: search left the board changed", think)
+		}
+		if !b.PlayMove(mv) {
+			t.Errorf("think %v: search returned illegal move %q", think, mv)
+		}
+	}
+}
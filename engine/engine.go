@@ -0,0 +1,206 @@
+// Package engine 服务端规则引擎，与客户端chess/rule.go同源，用于在服务端判定着法和对局结果
+package engine
+
+import (
+	"errors"
+	"go-chess/model"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Game 服务端对局使用的规则接口，象棋(含让子、揭棋)和暗棋各自实现
+type Game interface {
+	// Variant 棋局的变体
+	Variant() int
+	// Side 轮到哪方走棋，0=红方，1=黑方
+	Side() int
+	// PlayMove 检查字符串形式的着法，合法则走棋并返回true
+	PlayMove(move string) bool
+	// LastReveal 上一步翻开的棋子，返回格子和棋子(FEN字母)
+	LastReveal() (string, string)
+	// Judge 判断对局结果，返回胜方和结束原因
+	Judge(rule model.RuleConfig) (int, int)
+	// Hash 局面的64位zobrist校验码
+	Hash() uint64
+	// Fen 局面字符串，暗子不显示真实棋子
+	Fen() string
+	// HasAttacker 一方是否还有取胜的子力，用于超时判负还是判和
+	HasAttacker(sd int) bool
+}
+
+// Searcher 服务端AI可以搜索走法的对局。揭棋的搜索会看到暗子的真实棋子，不要用来给揭棋走棋
+type Searcher interface {
+	// SearchMove 在deadline前搜索走子方的走法，nDepth为最大深度，vlRandom越大走法越随意，没有合法走法时返回空
+	SearchMove(nDepth int, vlRandom int32, deadline time.Time) string
+}
+
+// NewGame 按变体创建开局，seed决定揭棋和暗棋的暗子分布。让子只对标准象棋有效，
+// 揭棋悔棋时按标准开局还原暗子，不能和让子一起使用
+func NewGame(nVariant, nHandicap int, removed []string, seed int64) (Game, error) {
+	switch nVariant {
+	case VariantStandard:
+		return NewHandicapPosition(nHandicap, removed)
+	case VariantJieqi:
+		if nHandicap != HandicapNone || len(removed) > 0 {
+			return nil, errors.New("jieqi does not support handicap")
+		}
+		return NewJieqiPosition(seed), nil
+	case VariantBanqi:
+		return NewBanqi(seed), nil
+	}
+	return nil, errors.New("unknown variant")
+}
+
+// NewPosition 创建一个处于开局局面的棋局
+func NewPosition() *PositionStruct {
+	p := NewPositionStruct()
+	p.startup()
+	return p
+}
+
+// NewHandicapPosition 按让子预设和自定义去掉的棋子(ICCS坐标)创建开局局面
+func NewHandicapPosition(nHandicap int, removed []string) (*PositionStruct, error) {
+	sqs, err := handicapSquares(nHandicap, removed)
+	if err != nil {
+		return nil, err
+	}
+	p := NewPositionStruct()
+	p.startupHandicap(sqs)
+	return p, nil
+}
+
+// NewJieqiPosition 创建揭棋开局，相同的seed得到相同的暗子分布，随机开局时记下seed即可复盘
+func NewJieqiPosition(seed int64) *PositionStruct {
+	p := NewPositionStruct()
+	p.startupJieqi(seed)
+	return p
+}
+
+// Variant 棋局的变体
+func (p *PositionStruct) Variant() int {
+	return p.nVariant
+}
+
+// LastReveal 揭棋中上一步揭开的暗子，返回所在格子(ICCS坐标)和棋子(FEN字母)，没有揭子时返回空串
+func (p *PositionStruct) LastReveal() (string, string) {
+	if p.pcRevealed == 0 {
+		return "", ""
+	}
+	return squareIccs(dst(p.mvLast)), string(pieceChar(p.pcRevealed))
+}
+
+// Side 轮到哪方走棋，0=红方，1=黑方
+func (p *PositionStruct) Side() int {
+	return p.sdPlayer
+}
+
+// HasAttacker 一方是否还有车马炮兵等可以过河进攻的棋子
+func (p *PositionStruct) HasAttacker(sd int) bool {
+	return p.hasAttacker(sd)
+}
+
+// Hash 局面的64位zobrist校验码(含走子方)，zobrist表由固定密钥生成，可长期保存用于索引局面
+func (p *PositionStruct) Hash() uint64 {
+	return p.zobr.dwKey
+}
+
+// Play 检查着法是否合法，合法则走棋并返回true
+func (p *PositionStruct) Play(mv int) bool {
+	if !p.legalMove(mv) || !p.makeMove(mv) {
+		return false
+	}
+	p.mvLast = mv
+	p.pcRevealed = p.mvsList[p.nMoveNum-1].ucpcRevealed
+	//吃子后重新开始计算自然着法和重复局面
+	if p.captured() {
+		p.setIrrev()
+	}
+	return true
+}
+
+// PlayMove 走ICCS坐标形式的着法，如"h2e2"
+func (p *PositionStruct) PlayMove(iccs string) bool {
+	if len(iccs) != 4 {
+		return false
+	}
+	sqSrc, sqDst := iccsSquare(iccs[:2]), iccsSquare(iccs[2:])
+	if sqSrc == 0 || sqDst == 0 {
+		return false
+	}
+	return p.Play(move(sqSrc, sqDst))
+}
+
+// MoveString 着法转换为ICCS坐标
+func (p *PositionStruct) MoveString(mv int) string {
+	return squareIccs(src(mv)) + squareIccs(dst(mv))
+}
+
+// Fen 局面的FEN串(不含回合数)，揭棋的暗子红方用X、黑方用x表示
+func (p *PositionStruct) Fen() string {
+	var sb strings.Builder
+	for y := Top; y <= Bottom; y++ {
+		nEmpty := 0
+		for x := Left; x <= Right; x++ {
+			sq := squareXY(x, y)
+			pc := p.ucpcSquares[sq]
+			if pc == 0 {
+				nEmpty++
+				continue
+			}
+			if nEmpty > 0 {
+				sb.WriteString(strconv.Itoa(nEmpty))
+				nEmpty = 0
+			}
+			switch {
+			case p.ucpcHidden[sq] == 0:
+				sb.WriteByte(pieceChar(pc))
+			case pc < 16:
+				sb.WriteByte('X')
+			default:
+				sb.WriteByte('x')
+			}
+		}
+		if nEmpty > 0 {
+			sb.WriteString(strconv.Itoa(nEmpty))
+		}
+		if y < Bottom {
+			sb.WriteByte('/')
+		}
+	}
+	if p.sdPlayer == 0 {
+		sb.WriteString(" w")
+	} else {
+		sb.WriteString(" b")
+	}
+	return sb.String()
+}
+
+// Judge 判断走棋后的对局结果，返回胜方和结束原因，原因为ReasonNone时对局继续
+func (p *PositionStruct) Judge(rule model.RuleConfig) (int, int) {
+	if p.isMate() {
+		//无子可动的一方判负
+		if p.inCheck() {
+			return 1 - p.sdPlayer, ReasonMate
+		}
+		return 1 - p.sdPlayer, ReasonStalemate
+	}
+
+	nRep := p.repStatus(3)
+	if nRep > 0 {
+		bPerpCheck := nRep&2 != 0    //走子方长将
+		bOppPerpCheck := nRep&4 != 0 //对方长将
+		if bPerpCheck && !bOppPerpCheck {
+			return 1 - p.sdPlayer, ReasonPerpetual
+		}
+		if bOppPerpCheck && !bPerpCheck {
+			return p.sdPlayer, ReasonPerpetual
+		}
+		return WinnerDraw, ReasonRepetition
+	}
+
+	if reason := p.drawReason(rule); reason != ReasonNone {
+		return WinnerDraw, reason
+	}
+	return WinnerDraw, ReasonNone
+}
@@ -0,0 +1,97 @@
+package engine
+
+import (
+	"math/rand"
+)
+
+// freePiece 揭棋中已翻开的士(仕)、相(象)不受九宫和河界的限制
+func (p *PositionStruct) freePiece(sq int) bool {
+	return p.nVariant == VariantJieqi && p.ucpcHidden[sq] == 0
+}
+
+// freeChecked 揭棋中判断帅(将)是否被翻开的士(仕)、相(象)将军
+func (p *PositionStruct) freeChecked(sqSrc int) bool {
+	pcOppSide := oppSideTag(p.sdPlayer)
+	for i := 0; i < 4; i++ {
+		sqDst := sqSrc + ccShiDelta[i]
+		pcDst := p.ucpcSquares[sqDst]
+		if pcDst == pcOppSide+PieceShi && p.freePiece(sqDst) {
+			return true
+		}
+		if pcDst != 0 {
+			continue
+		}
+		//相(象)眼为空
+		sqDst += ccShiDelta[i]
+		if p.ucpcSquares[sqDst] == pcOppSide+PieceXiang && p.freePiece(sqDst) {
+			return true
+		}
+	}
+	return false
+}
+
+// startupJieqi 揭棋开局，帅(将)以外的棋子暗放在标准位置上，真实棋子按seed打乱
+func (p *PositionStruct) startupJieqi(seed int64) {
+	p.startup()
+	p.nVariant = VariantJieqi
+	r := rand.New(rand.NewSource(seed))
+	for sd := 0; sd < 2; sd++ {
+		pcSelfSide := sideTag(sd)
+		var sqs, pcs []int
+		for sq := 0; sq < 256; sq++ {
+			pc := cucpcStartup[sq]
+			if (pc&pcSelfSide) != 0 && pc-pcSelfSide != PieceJiang {
+				sqs = append(sqs, sq)
+				pcs = append(pcs, pc)
+			}
+		}
+		r.Shuffle(len(pcs), func(i, j int) {
+			pcs[i], pcs[j] = pcs[j], pcs[i]
+		})
+		//暗子按所在格子的棋子走法行棋，ucpcSquares里放的就是格子对应的棋子
+		for i, sq := range sqs {
+			p.ucpcHidden[sq] = pcs[i]
+			p.zobr.xor1(p.zobrist.Hidden[sq])
+		}
+	}
+	p.setIrrev()
+}
+
+// revealMove 走子后揭开走动的暗子，并去掉被吃暗子的标记，返回揭开的棋子和被吃的暗子
+func (p *PositionStruct) revealMove(mv int) (int, int) {
+	if p.nVariant != VariantJieqi {
+		return 0, 0
+	}
+	sqSrc := src(mv)
+	sqDst := dst(mv)
+	pcRevealed := p.ucpcHidden[sqSrc]
+	pcHidden := p.ucpcHidden[sqDst]
+	if pcHidden != 0 {
+		p.ucpcHidden[sqDst] = 0
+		p.zobr.xor1(p.zobrist.Hidden[sqDst])
+	}
+	if pcRevealed != 0 {
+		p.ucpcHidden[sqSrc] = 0
+		p.zobr.xor1(p.zobrist.Hidden[sqSrc])
+		p.delPiece(sqDst, p.ucpcSquares[sqDst])
+		p.addPiece(sqDst, pcRevealed)
+	}
+	return pcRevealed, pcHidden
+}
+
+// undoRevealMove 撤销走子前把揭开的棋子重新盖上
+func (p *PositionStruct) undoRevealMove(mvs *MoveStruct) {
+	sqSrc := src(mvs.wmv)
+	sqDst := dst(mvs.wmv)
+	if mvs.ucpcRevealed != 0 {
+		//暗子没有离开过初始格子，盖上后按初始格子的棋子行棋
+		p.delPiece(sqDst, mvs.ucpcRevealed)
+		p.addPiece(sqDst, cucpcStartup[sqSrc])
+		p.ucpcHidden[sqSrc] = mvs.ucpcRevealed
+		p.zobr.xor1(p.zobrist.Hidden[sqSrc])
+	}
+	if mvs.ucpcHidden != 0 {
+		p.ucpcHidden[sqDst] = mvs.ucpcHidden
+		p.zobr.xor1(p.zobrist.Hidden[sqDst])
+	}
+}
@@ -0,0 +1,84 @@
+package engine
+
+import "testing"
+
+func TestNewGameHandicap(t *testing.T) {
+	tests := []struct {
+		name     string
+		variant  int
+		handicap int
+		removed  []string
+		wantErr  bool
+	}{
+		{"standard", VariantStandard, HandicapNone, nil, false},
+		{"standard with handicap", VariantStandard, HandicapOneMa, []string{"a0"}, false},
+		{"jieqi", VariantJieqi, HandicapNone, nil, false},
+		{"jieqi with preset", VariantJieqi, HandicapOneMa, nil, true},
+		{"jieqi with removed", VariantJieqi, HandicapNone, []string{"a0"}, true},
+		{"banqi", VariantBanqi, HandicapNone, nil, false},
+		{"unknown", 9, HandicapNone, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewGame(tt.variant, tt.handicap, tt.removed, 1)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewGame err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJieqiRevealAndUndo(t *testing.T) {
+	tests := []struct {
+		name  string
+		moves []string
+	}{
+		{"reveal horse", []string{"b0c2"}},
+		{"reveal soldier", []string{"c3c4"}},
+		{"cannon captures hidden piece", []string{"h2h9"}},
+		{"both sides reveal", []string{"b0c2", "h9g7", "c2d4"}},
+		{"revealed piece captures hidden piece", []string{"h2h9", "i9h9"}},
+	}
+	for _, tt := range tests {
+		for seed := int64(1); seed <= 20; seed++ {
+			p := NewJieqiPosition(seed)
+			type state struct {
+				fen    string
+				hash   uint64
+				hidden [256]int
+			}
+			var states []state
+			for _, iccs := range tt.moves {
+				states = append(states, state{p.Fen(), p.Hash(), p.ucpcHidden})
+				sqSrc := iccsSquare(iccs[:2])
+				hidden := p.ucpcHidden[sqSrc]
+				sqDst := iccsSquare(iccs[2:])
+				mv := move(sqSrc, sqDst)
+				//直接走子不经过Play，吃子后不重置历史，才能一路撤销回开局
+				if !p.legalMove(mv) || !p.makeMove(mv) {
+					//前面揭开的棋子不一定能走后面的着法，这个seed下只测到这里
+					states = states[:len(states)-1]
+					break
+				}
+				if hidden != 0 && p.ucpcSquares[sqDst] != hidden {
+					t.Fatalf("%s seed %d: %s revealed %d, want %d", tt.name, seed, iccs, p.ucpcSquares[sqDst], hidden)
+				}
+				if p.ucpcHidden[sqSrc] != 0 || p.ucpcHidden[sqDst] != 0 {
+					t.Fatalf("%s seed %d: %s left hidden marks", tt.name, seed, iccs)
+				}
+			}
+			for i := len(states) - 1; i >= 0; i-- {
+				p.undoMakeMove()
+				if got := p.Fen(); got != states[i].fen {
+					t.Errorf("%s seed %d: undo %s fen = %s, want %s", tt.name, seed, tt.moves[i], got, states[i].fen)
+				}
+				if p.Hash() != states[i].hash {
+					t.Errorf("%s seed %d: undo %s changed hash", tt.name, seed, tt.moves[i])
+				}
+				if p.ucpcHidden != states[i].hidden {
+					t.Errorf("%s seed %d: undo %s changed hidden pieces", tt.name, seed, tt.moves[i])
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,725 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"go-chess/model"
+)
+
+type RC4Struct struct {
+	s    [256]int
+	x, y int
+}
+
+func (r *RC4Struct) initZero() {
+	j := 0
+	for i := 0; i < 256; i++ {
+		r.s[i] = i
+	}
+	for i := 0; i < 256; i++ {
+		j = (j + r.s[i]) & 255
+		r.s[i], r.s[j] = r.s[j], r.s[i]
+	}
+}
+
+func (r *RC4Struct) initKey(key []byte) {
+	j := 0
+	for i := 0; i < 256; i++ {
+		r.s[i] = i
+	}
+	for i := 0; i < 256; i++ {
+		j = (j + r.s[i] + int(key[i%len(key)])) & 255
+		r.s[i], r.s[j] = r.s[j], r.s[i]
+	}
+}
+
+func (r *RC4Struct) nextByte() uint32 {
+	r.x = (r.x + 1) & 255
+	r.y = (r.y + r.s[r.x]) & 255
+	r.s[r.x], r.s[r.y] = r.s[r.y], r.s[r.x]
+	return uint32(r.s[(r.s[r.x]+r.s[r.y])&255])
+}
+
+func (r *RC4Struct) nextLong() uint32 {
+	uc0 := r.nextByte()
+	uc1 := r.nextByte()
+	uc2 := r.nextByte()
+	uc3 := r.nextByte()
+	return uc0 + (uc1 << 8) + (uc2 << 16) + (uc3 << 24)
+}
+
+// zobristHighKey 生成校验码高32位的RC4密钥，修改后已保存的局面校验码全部失效
+var zobristHighKey = []byte("go-chess")
+
+// nextLong64 低32位沿用原先零密钥RC4序列，保证与旧版开局库的32位校验码一致
+func nextLong64(rc4Low, rc4High *RC4Struct) uint64 {
+	return uint64(rc4Low.nextLong()) | uint64(rc4High.nextLong())<<32
+}
+
+type ZobristStruct struct {
+	dwKey   uint64
+	dwLock0 uint64
+	dwLock1 uint64
+}
+
+func (z *ZobristStruct) initZero() {
+	z.dwKey, z.dwLock0, z.dwLock1 = 0, 0, 0
+}
+
+func (z *ZobristStruct) initRC4(rc4Low, rc4High *RC4Struct) {
+	z.dwKey = nextLong64(rc4Low, rc4High)
+	z.dwLock0 = nextLong64(rc4Low, rc4High)
+	z.dwLock1 = nextLong64(rc4Low, rc4High)
+}
+
+func (z *ZobristStruct) xor1(zobr *ZobristStruct) {
+	z.dwKey ^= zobr.dwKey
+	z.dwLock0 ^= zobr.dwLock0
+	z.dwLock1 ^= zobr.dwLock1
+}
+
+func (z *ZobristStruct) xor2(zobr1, zobr2 *ZobristStruct) {
+	z.dwKey ^= zobr1.dwKey ^ zobr2.dwKey
+	z.dwLock0 ^= zobr1.dwLock0 ^ zobr2.dwLock0
+	z.dwLock1 ^= zobr1.dwLock1 ^ zobr2.dwLock1
+}
+
+type Zobrist struct {
+	Player *ZobristStruct          //走子方
+	Table  [14][256]*ZobristStruct //所有棋子
+	Hidden [256]*ZobristStruct     //揭棋暗子
+}
+
+func (z *Zobrist) initZobrist() {
+	rc4Low := &RC4Struct{}
+	rc4Low.initZero()
+	rc4High := &RC4Struct{}
+	rc4High.initKey(zobristHighKey)
+	z.Player.initRC4(rc4Low, rc4High)
+	for i := 0; i < 14; i++ {
+		for j := 0; j < 256; j++ {
+			z.Table[i][j] = &ZobristStruct{}
+			z.Table[i][j].initRC4(rc4Low, rc4High)
+		}
+	}
+	//放在最后生成，不影响上面已有的校验码
+	for j := 0; j < 256; j++ {
+		z.Hidden[j] = &ZobristStruct{}
+		z.Hidden[j].initRC4(rc4Low, rc4High)
+	}
+}
+
+type MoveStruct struct {
+	ucpcCaptured int  //是否吃子
+	ucbCheck     bool //是否将军
+	wmv          int  //走法
+	dwKey        uint64
+	ucpcRevealed int //揭棋中走动暗子时揭开的棋子
+	ucpcHidden   int //揭棋中被吃掉的暗子
+}
+
+func (m *MoveStruct) set(mv, pcCaptured int, bCheck bool, dwKey uint64) {
+	m.wmv = mv
+	m.ucpcCaptured = pcCaptured
+	m.ucbCheck = bCheck
+	m.dwKey = dwKey
+	m.ucpcRevealed = 0
+	m.ucpcHidden = 0
+}
+
+type PositionStruct struct {
+	sdPlayer    int                   //轮到谁走，0=红方，1=黑方
+	vlRed       int                   //红方的子力价值
+	vlBlack     int                   //黑方的子力价值
+	nDistance   int                   //距离根节点的步数
+	nMoveNum    int                   //历史走法数
+	ucpcSquares [256]int              //棋盘上的棋子
+	mvsList     [MaxMoves]*MoveStruct //历史走法信息列表
+	zobr        *ZobristStruct        //走子方zobrist校验码
+	zobrist     *Zobrist              //所有棋子zobrist校验码
+	nVariant    int                   //变体，0=标准，1=揭棋
+	ucpcHidden  [256]int              //揭棋中暗子的真实棋子，0表示明子
+	mvLast      int                   //上一步走法，吃子后历史走法会被清空
+	pcRevealed  int                   //上一步揭开的暗子
+	search      *Search               //AI搜索时的置换表等，只在搜索期间存在
+}
+
+// 所有局面共用一张zobrist表，服务端同时有很多对局，不必每个局面各生成一份
+var sharedZobrist = func() *Zobrist {
+	z := &Zobrist{
+		Player: &ZobristStruct{},
+	}
+	z.initZobrist()
+	return z
+}()
+
+func NewPositionStruct() *PositionStruct {
+	p := &PositionStruct{
+		zobr:    &ZobristStruct{},
+		zobrist: sharedZobrist,
+	}
+
+	for i := 0; i < MaxMoves; i++ {
+		tmpMoveStruct := &MoveStruct{}
+		p.mvsList[i] = tmpMoveStruct
+	}
+	return p
+}
+
+func (p *PositionStruct) clearBoard() {
+	p.sdPlayer, p.vlRed, p.vlBlack, p.nDistance = 0, 0, 0, 0
+	for i := 0; i < 256; i++ {
+		p.ucpcSquares[i] = 0
+		p.ucpcHidden[i] = 0
+	}
+	p.nVariant = VariantStandard
+	p.mvLast, p.pcRevealed = 0, 0
+	p.zobr.initZero()
+}
+
+func (p *PositionStruct) setIrrev() {
+	p.mvsList[0].set(0, 0, p.checked(), p.zobr.dwKey)
+	p.nMoveNum = 1
+}
+
+func (p *PositionStruct) startup() {
+	p.startupHandicap(nil)
+}
+
+// startupHandicap 去掉指定格子上的棋子后开局
+func (p *PositionStruct) startupHandicap(sqsRemoved []int) {
+	p.clearBoard()
+	pc := 0
+	for sq := 0; sq < 256; sq++ {
+		pc = cucpcStartup[sq]
+		if pc != 0 && !containsSquare(sqsRemoved, sq) {
+			p.addPiece(sq, pc)
+		}
+	}
+	p.setIrrev()
+}
+
+func containsSquare(sqs []int, sq int) bool {
+	for _, v := range sqs {
+		if v == sq {
+			return true
+		}
+	}
+	return false
+}
+
+// handicapSquares 根据让子预设和自定义去掉的棋子(ICCS坐标)，得到开局时要去掉的格子
+func handicapSquares(nHandicap int, removed []string) ([]int, error) {
+	preset, ok := ccHandicapSquares[nHandicap]
+	if !ok {
+		return nil, fmt.Errorf("unknown handicap %d", nHandicap)
+	}
+	sqs := append([]int{}, preset...)
+	for _, v := range removed {
+		sq := iccsSquare(v)
+		if sq == 0 || cucpcStartup[sq] == 0 {
+			return nil, fmt.Errorf("no piece on %q", v)
+		}
+		if cucpcStartup[sq]&7 == PieceJiang {
+			return nil, errors.New("can not remove jiang")
+		}
+		if !containsSquare(sqs, sq) {
+			sqs = append(sqs, sq)
+		}
+	}
+	return sqs, nil
+}
+
+func (p *PositionStruct) changeSide() {
+	p.sdPlayer = 1 - p.sdPlayer
+	p.zobr.xor1(p.zobrist.Player)
+}
+
+func (p *PositionStruct) addPiece(sq, pc int) {
+	p.ucpcSquares[sq] = pc
+	if pc < 16 {
+		p.vlRed += cucvlPiecePos[pc-8][sq]
+		p.zobr.xor1(p.zobrist.Table[pc-8][sq])
+	} else {
+		p.vlBlack += cucvlPiecePos[pc-16][squareFlip(sq)]
+		p.zobr.xor1(p.zobrist.Table[pc-9][sq])
+	}
+}
+
+func (p *PositionStruct) delPiece(sq, pc int) {
+	p.ucpcSquares[sq] = 0
+	if pc < 16 {
+		p.vlRed -= cucvlPiecePos[pc-8][sq]
+		p.zobr.xor1(p.zobrist.Table[pc-8][sq])
+	} else {
+		p.vlBlack -= cucvlPiecePos[pc-16][squareFlip(sq)]
+		p.zobr.xor1(p.zobrist.Table[pc-9][sq])
+	}
+}
+
+func (p *PositionStruct) evaluate() int {
+	if p.sdPlayer == 0 {
+		return p.vlRed - p.vlBlack + AdvancedValue
+	}
+
+	return p.vlBlack - p.vlRed + AdvancedValue
+}
+
+func (p *PositionStruct) inCheck() bool {
+	return p.mvsList[p.nMoveNum-1].ucbCheck
+}
+
+func (p *PositionStruct) captured() bool {
+	return p.mvsList[p.nMoveNum-1].ucpcCaptured != 0
+}
+
+func (p *PositionStruct) movePiece(mv int) int {
+	sqSrc := src(mv)
+	sqDst := dst(mv)
+	pcCaptured := p.ucpcSquares[sqDst]
+	if pcCaptured != 0 {
+		p.delPiece(sqDst, pcCaptured)
+	}
+	pc := p.ucpcSquares[sqSrc]
+	p.delPiece(sqSrc, pc)
+	p.addPiece(sqDst, pc)
+	return pcCaptured
+}
+
+func (p *PositionStruct) undoMovePiece(mv, pcCaptured int) {
+	sqSrc := src(mv)
+	sqDst := dst(mv)
+	pc := p.ucpcSquares[sqDst]
+	p.delPiece(sqDst, pc)
+	p.addPiece(sqSrc, pc)
+	if pcCaptured != 0 {
+		p.addPiece(sqDst, pcCaptured)
+	}
+}
+
+func (p *PositionStruct) makeMove(mv int) bool {
+	dwKey := p.zobr.dwKey
+	pcCaptured := p.movePiece(mv)
+	if p.checked() {
+		p.undoMovePiece(mv, pcCaptured)
+		return false
+	}
+	//揭棋要先揭开暗子，再判断是否将军
+	pcRevealed, pcHidden := p.revealMove(mv)
+	p.changeSide()
+	p.mvsList[p.nMoveNum].set(mv, pcCaptured, p.checked(), dwKey)
+	p.mvsList[p.nMoveNum].ucpcRevealed = pcRevealed
+	p.mvsList[p.nMoveNum].ucpcHidden = pcHidden
+	p.nMoveNum++
+	p.nDistance++
+	return true
+}
+
+func (p *PositionStruct) undoMakeMove() {
+	p.nDistance--
+	p.nMoveNum--
+	p.changeSide()
+	p.undoRevealMove(p.mvsList[p.nMoveNum])
+	p.undoMovePiece(p.mvsList[p.nMoveNum].wmv, p.mvsList[p.nMoveNum].ucpcCaptured)
+}
+
+func (p *PositionStruct) generateMoves(mvs []int, bCapture bool) int {
+	nGenMoves, pcSrc, sqDst, pcDst, nDelta := 0, 0, 0, 0, 0
+	pcSelfSide := sideTag(p.sdPlayer)
+	pcOppSide := oppSideTag(p.sdPlayer)
+
+	for sqSrc := 0; sqSrc < 256; sqSrc++ {
+		if !inBoard(sqSrc) {
+			continue
+		}
+
+		pcSrc = p.ucpcSquares[sqSrc]
+		if (pcSrc & pcSelfSide) == 0 {
+			continue
+		}
+
+		switch pcSrc - pcSelfSide {
+		case PieceJiang:
+			for i := 0; i < 4; i++ {
+				sqDst = sqSrc + ccJiangDelta[i]
+				if !inFort(sqDst) {
+					continue
+				}
+				pcDst = p.ucpcSquares[sqDst]
+				if (bCapture && (pcDst&pcOppSide) != 0) || (!bCapture && (pcDst&pcSelfSide) == 0) {
+					mvs[nGenMoves] = move(sqSrc, sqDst)
+					nGenMoves++
+				}
+			}
+			break
+		case PieceShi:
+			for i := 0; i < 4; i++ {
+				sqDst = sqSrc + ccShiDelta[i]
+				if !inFort(sqDst) && !(p.freePiece(sqSrc) && inBoard(sqDst)) {
+					continue
+				}
+				pcDst = p.ucpcSquares[sqDst]
+				if (bCapture && (pcDst&pcOppSide) != 0) || (!bCapture && (pcDst&pcSelfSide) == 0) {
+					mvs[nGenMoves] = move(sqSrc, sqDst)
+					nGenMoves++
+				}
+			}
+			break
+		case PieceXiang:
+			for i := 0; i < 4; i++ {
+				sqDst = sqSrc + ccShiDelta[i]
+				if !(inBoard(sqDst) && (noRiver(sqDst, p.sdPlayer) || p.freePiece(sqSrc)) && p.ucpcSquares[sqDst] == 0) {
+					continue
+				}
+				sqDst += ccShiDelta[i]
+				if !inBoard(sqDst) {
+					continue
+				}
+				pcDst = p.ucpcSquares[sqDst]
+				if (bCapture && (pcDst&pcOppSide) != 0) || (!bCapture && (pcDst&pcSelfSide) == 0) {
+					mvs[nGenMoves] = move(sqSrc, sqDst)
+					nGenMoves++
+				}
+			}
+			break
+		case PieceMa:
+			for i := 0; i < 4; i++ {
+				sqDst = sqSrc + ccJiangDelta[i]
+				if p.ucpcSquares[sqDst] != 0 {
+					continue
+				}
+				for j := 0; j < 2; j++ {
+					sqDst = sqSrc + ccMaDelta[i][j]
+					if !inBoard(sqDst) {
+						continue
+					}
+					pcDst = p.ucpcSquares[sqDst]
+					if (bCapture && (pcDst&pcOppSide) != 0) || (!bCapture && (pcDst&pcSelfSide) == 0) {
+						mvs[nGenMoves] = move(sqSrc, sqDst)
+						nGenMoves++
+					}
+				}
+			}
+			break
+		case PieceJu:
+			for i := 0; i < 4; i++ {
+				nDelta = ccJiangDelta[i]
+				sqDst = sqSrc + nDelta
+				for inBoard(sqDst) {
+					pcDst = p.ucpcSquares[sqDst]
+					if pcDst == 0 {
+						if !bCapture {
+							mvs[nGenMoves] = move(sqSrc, sqDst)
+							nGenMoves++
+						}
+					} else {
+						if (pcDst & pcOppSide) != 0 {
+							mvs[nGenMoves] = move(sqSrc, sqDst)
+							nGenMoves++
+						}
+						break
+					}
+					sqDst += nDelta
+				}
+
+			}
+			break
+		case PiecePao:
+			for i := 0; i < 4; i++ {
+				nDelta = ccJiangDelta[i]
+				sqDst = sqSrc + nDelta
+				for inBoard(sqDst) {
+					pcDst = p.ucpcSquares[sqDst]
+					if pcDst == 0 {
+						if !bCapture {
+							mvs[nGenMoves] = move(sqSrc, sqDst)
+							nGenMoves++
+						}
+					} else {
+						break
+					}
+					sqDst += nDelta
+				}
+				sqDst += nDelta
+				for inBoard(sqDst) {
+					pcDst = p.ucpcSquares[sqDst]
+					if pcDst != 0 {
+						if (pcDst & pcOppSide) != 0 {
+							mvs[nGenMoves] = move(sqSrc, sqDst)
+							nGenMoves++
+						}
+						break
+					}
+					sqDst += nDelta
+				}
+			}
+			break
+		case PieceBing:
+			sqDst = squareForward(sqSrc, p.sdPlayer)
+			if inBoard(sqDst) {
+				pcDst = p.ucpcSquares[sqDst]
+				if (bCapture && (pcDst&pcOppSide) != 0) || (!bCapture && (pcDst&pcSelfSide) == 0) {
+					mvs[nGenMoves] = move(sqSrc, sqDst)
+					nGenMoves++
+				}
+			}
+			if hasRiver(sqSrc, p.sdPlayer) {
+				for nDelta = -1; nDelta <= 1; nDelta += 2 {
+					sqDst = sqSrc + nDelta
+					if inBoard(sqDst) {
+						pcDst = p.ucpcSquares[sqDst]
+						if (bCapture && (pcDst&pcOppSide) != 0) || (!bCapture && (pcDst&pcSelfSide) == 0) {
+							mvs[nGenMoves] = move(sqSrc, sqDst)
+							nGenMoves++
+						}
+					}
+				}
+			}
+			break
+		}
+	}
+	return nGenMoves
+}
+
+func (p *PositionStruct) legalMove(mv int) bool {
+	sqSrc := src(mv)
+	pcSrc := p.ucpcSquares[sqSrc]
+	pcSelfSide := sideTag(p.sdPlayer)
+	if (pcSrc & pcSelfSide) == 0 {
+		return false
+	}
+
+	sqDst := dst(mv)
+	pcDst := p.ucpcSquares[sqDst]
+	if (pcDst & pcSelfSide) != 0 {
+		return false
+	}
+
+	tmpPiece := pcSrc - pcSelfSide
+	switch tmpPiece {
+	case PieceJiang:
+		return inFort(sqDst) && jiangSpan(sqSrc, sqDst)
+	case PieceShi:
+		return (inFort(sqDst) || p.freePiece(sqSrc) && inBoard(sqDst)) && shiSpan(sqSrc, sqDst)
+	case PieceXiang:
+		return (sameRiver(sqSrc, sqDst) || p.freePiece(sqSrc)) && inBoard(sqDst) && xiangSpan(sqSrc, sqDst) &&
+			p.ucpcSquares[xiangPin(sqSrc, sqDst)] == 0
+	case PieceMa:
+		sqPin := maPin(sqSrc, sqDst)
+		return sqPin != sqSrc && p.ucpcSquares[sqPin] == 0
+	case PieceJu, PiecePao:
+		nDelta := 0
+		if sameX(sqSrc, sqDst) {
+			if sqDst < sqSrc {
+				nDelta = -1
+			} else {
+				nDelta = 1
+			}
+		} else if sameY(sqSrc, sqDst) {
+			if sqDst < sqSrc {
+				nDelta = -16
+			} else {
+				nDelta = 16
+			}
+		} else {
+			return false
+		}
+		sqPin := sqSrc + nDelta
+		for sqPin != sqDst && p.ucpcSquares[sqPin] == 0 {
+			sqPin += nDelta
+		}
+		if sqPin == sqDst {
+			return pcDst == 0 || tmpPiece == PieceJu
+		} else if pcDst != 0 && tmpPiece == PiecePao {
+			sqPin += nDelta
+			for sqPin != sqDst && p.ucpcSquares[sqPin] == 0 {
+				sqPin += nDelta
+			}
+			return sqPin == sqDst
+		} else {
+			return false
+		}
+	case PieceBing:
+		if hasRiver(sqDst, p.sdPlayer) && (sqDst == sqSrc-1 || sqDst == sqSrc+1) {
+			return true
+		}
+		return sqDst == squareForward(sqSrc, p.sdPlayer)
+	default:
+
+	}
+
+	return false
+}
+
+func (p *PositionStruct) checked() bool {
+	nDelta, sqDst, pcDst := 0, 0, 0
+	pcSelfSide := sideTag(p.sdPlayer)
+	pcOppSide := oppSideTag(p.sdPlayer)
+
+	for sqSrc := 0; sqSrc < 256; sqSrc++ {
+		if !inBoard(sqSrc) || p.ucpcSquares[sqSrc] != pcSelfSide+PieceJiang {
+			continue
+		}
+
+		if p.ucpcSquares[squareForward(sqSrc, p.sdPlayer)] == pcOppSide+PieceBing {
+			return true
+		}
+		for nDelta = -1; nDelta <= 1; nDelta += 2 {
+			if p.ucpcSquares[sqSrc+nDelta] == pcOppSide+PieceBing {
+				return true
+			}
+		}
+
+		for i := 0; i < 4; i++ {
+			if p.ucpcSquares[sqSrc+ccShiDelta[i]] != 0 {
+				continue
+			}
+			for j := 0; j < 2; j++ {
+				pcDst = p.ucpcSquares[sqSrc+ccMaCheckDelta[i][j]]
+				if pcDst == pcOppSide+PieceMa {
+					return true
+				}
+			}
+		}
+
+		for i := 0; i < 4; i++ {
+			nDelta = ccJiangDelta[i]
+			sqDst = sqSrc + nDelta
+			for inBoard(sqDst) {
+				pcDst = p.ucpcSquares[sqDst]
+				if pcDst != 0 {
+					if pcDst == pcOppSide+PieceJu || pcDst == pcOppSide+PieceJiang {
+						return true
+					}
+					break
+				}
+				sqDst += nDelta
+			}
+			sqDst += nDelta
+			for inBoard(sqDst) {
+				pcDst = p.ucpcSquares[sqDst]
+				if pcDst != 0 {
+					if pcDst == pcOppSide+PiecePao {
+						return true
+					}
+					break
+				}
+				sqDst += nDelta
+			}
+		}
+		return p.nVariant == VariantJieqi && p.freeChecked(sqSrc)
+	}
+	return false
+}
+
+func (p *PositionStruct) isMate() bool {
+	pcCaptured := 0
+	mvs := make([]int, MaxGenMoves)
+	nGenMoveNum := p.generateMoves(mvs, false)
+	for i := 0; i < nGenMoveNum; i++ {
+		pcCaptured = p.movePiece(mvs[i])
+		if !p.checked() {
+			p.undoMovePiece(mvs[i], pcCaptured)
+			return false
+		}
+
+		p.undoMovePiece(mvs[i], pcCaptured)
+	}
+	return true
+}
+
+func (p *PositionStruct) drawValue() int {
+	if p.nDistance&1 == 0 {
+		return -DrawValue
+	}
+
+	return DrawValue
+}
+
+func (p *PositionStruct) repStatus(nRecur int) int {
+	bSelfSide, bPerpCheck, bOppPerpCheck := false, true, true
+	lpmvs := [MaxMoves]*MoveStruct{}
+	for i := 0; i < MaxMoves; i++ {
+		lpmvs[i] = p.mvsList[i]
+	}
+
+	for i := p.nMoveNum - 1; i >= 0 && lpmvs[i].wmv != 0 && lpmvs[i].ucpcCaptured == 0; i-- {
+		if bSelfSide {
+			bPerpCheck = bPerpCheck && lpmvs[i].ucbCheck
+			if lpmvs[i].dwKey == p.zobr.dwKey {
+				nRecur--
+				if nRecur == 0 {
+					result := 1
+					if bPerpCheck {
+						result += 2
+					}
+					if bOppPerpCheck {
+						result += 4
+					}
+					return result
+				}
+			}
+		} else {
+			bOppPerpCheck = bOppPerpCheck && lpmvs[i].ucbCheck
+		}
+		bSelfSide = !bSelfSide
+	}
+	return 0
+}
+
+func (p *PositionStruct) repValue(nRepStatus int) int {
+	vlReturn := 0
+	if nRepStatus&2 != 0 {
+		vlReturn += p.nDistance - BanValue
+	}
+	if nRepStatus&4 != 0 {
+		vlReturn += BanValue - p.nDistance
+	}
+
+	if vlReturn == 0 {
+		return p.drawValue()
+	}
+
+	return vlReturn
+}
+
+// hasAttacker 判断一方是否还有可以过河进攻的子力(车马炮兵)
+func (p *PositionStruct) hasAttacker(sd int) bool {
+	pcSelfSide := sideTag(sd)
+	for sq := 0; sq < 256; sq++ {
+		pc := p.ucpcSquares[sq]
+		if (pc & pcSelfSide) == 0 {
+			continue
+		}
+		//揭棋中暗子可能是任何棋子，翻开的士相也能过河
+		if p.nVariant == VariantJieqi && pc-pcSelfSide != PieceJiang {
+			return true
+		}
+		switch pc - pcSelfSide {
+		case PieceMa, PieceJu, PiecePao, PieceBing:
+			return true
+		}
+	}
+	return false
+}
+
+// insufficientMaterial 双方都没有进攻子力，谁也无法将死对方
+func (p *PositionStruct) insufficientMaterial() bool {
+	return !p.hasAttacker(0) && !p.hasAttacker(1)
+}
+
+// drawReason 按和棋规则判断当前局面是否和棋，nMoveNum在吃子后由setIrrev重置，即为自然着法数
+func (p *PositionStruct) drawReason(rule model.RuleConfig) int {
+	if rule.Insufficient && p.insufficientMaterial() {
+		return ReasonInsufficient
+	}
+	//历史走法表长度有限，限着不能超过MaxMoves
+	nLimit := rule.MoveLimit * 2
+	if nLimit <= 0 || nLimit > MaxMoves-2 {
+		nLimit = MaxMoves - 2
+	}
+	if p.nMoveNum-1 >= nLimit {
+		return ReasonMoveLimit
+	}
+	return ReasonNone
+}
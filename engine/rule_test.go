@@ -0,0 +1,156 @@
+package engine
+
+import (
+	"go-chess/model"
+	"strings"
+	"testing"
+)
+
+// positionFromFen 按FEN串(不含回合数)摆出局面，只用于测试
+func positionFromFen(t *testing.T, fen string) *PositionStruct {
+	t.Helper()
+	fields := strings.Fields(fen)
+	rows := strings.Split(fields[0], "/")
+	if len(rows) != 10 {
+		t.Fatalf("bad fen %q", fen)
+	}
+	p := NewPositionStruct()
+	p.clearBoard()
+	for i, row := range rows {
+		x := Left
+		for _, c := range row {
+			if c >= '1' && c <= '9' {
+				x += int(c - '0')
+				continue
+			}
+			pc := 0
+			for _, side := range []int{16, 8} {
+				for pt := PieceJiang; pt <= PieceBing; pt++ {
+					if pieceChar(side+pt) == byte(c) {
+						pc = side + pt
+					}
+				}
+			}
+			if pc == 0 {
+				t.Fatalf("bad piece %q in fen %q", c, fen)
+			}
+			p.addPiece(squareXY(x, Top+i), pc)
+			x++
+		}
+	}
+	if len(fields) > 1 && fields[1] == "b" {
+		p.changeSide()
+	}
+	p.setIrrev()
+	if got := p.Fen(); got != fen {
+		t.Fatalf("fen round trip = %q, want %q", got, fen)
+	}
+	return p
+}
+
+func TestJudge(t *testing.T) {
+	shuffle := []string{"d0d1", "e9e8", "d1d0", "e8e9"}
+	perpetual := []string{"a8a9", "e9e8", "a9a8", "e8e9"}
+	repeat := func(moves []string, n int) []string {
+		var out []string
+		for i := 0; i < n; i++ {
+			out = append(out, moves...)
+		}
+		return out
+	}
+	tests := []struct {
+		name       string
+		fen        string
+		rule       model.RuleConfig
+		moves      []string
+		wantWinner int
+		wantReason int
+	}{
+		{
+			name:       "mate",
+			fen:        "3k5/3R5/3R5/9/9/9/9/9/9/4K4 b",
+			rule:       model.RuleConfig{MoveLimit: 60},
+			wantWinner: 0,
+			wantReason: ReasonMate,
+		},
+		{
+			name:       "stalemate loses",
+			fen:        "3k5/R8/9/9/9/9/9/9/9/4K4 b",
+			rule:       model.RuleConfig{MoveLimit: 60},
+			wantWinner: 0,
+			wantReason: ReasonStalemate,
+		},
+		{
+			name:       "game goes on",
+			fen:        "rnbakabnr/9/1c5c1/p1p1p1p1p/9/9/P1P1P1P1P/1C5C1/9/RNBAKABNR w",
+			rule:       model.RuleConfig{MoveLimit: 60, Insufficient: true},
+			wantWinner: WinnerDraw,
+			wantReason: ReasonNone,
+		},
+		{
+			name:       "insufficient material",
+			fen:        "3akab2/9/9/9/9/9/9/9/4A4/3K5 w",
+			rule:       model.RuleConfig{MoveLimit: 60, Insufficient: true},
+			wantWinner: WinnerDraw,
+			wantReason: ReasonInsufficient,
+		},
+		{
+			name:       "insufficient material disabled",
+			fen:        "3akab2/9/9/9/9/9/9/9/4A4/3K5 w",
+			rule:       model.RuleConfig{MoveLimit: 60},
+			wantWinner: WinnerDraw,
+			wantReason: ReasonNone,
+		},
+		{
+			name:       "attacker left",
+			fen:        "4k4/9/9/9/9/9/9/9/4P4/3K5 w",
+			rule:       model.RuleConfig{MoveLimit: 60, Insufficient: true},
+			wantWinner: WinnerDraw,
+			wantReason: ReasonNone,
+		},
+		{
+			name:       "move limit",
+			fen:        "4k4/9/9/9/9/9/9/9/9/3K5 w",
+			rule:       model.RuleConfig{MoveLimit: 2},
+			moves:      shuffle,
+			wantWinner: WinnerDraw,
+			wantReason: ReasonMoveLimit,
+		},
+		{
+			name:       "repetition",
+			fen:        "4k4/9/9/9/9/9/9/9/9/3K5 w",
+			rule:       model.RuleConfig{MoveLimit: 60},
+			moves:      repeat(shuffle, 3),
+			wantWinner: WinnerDraw,
+			wantReason: ReasonRepetition,
+		},
+		{
+			name:       "perpetual check loses",
+			fen:        "4k4/R8/9/9/9/9/9/9/9/3K5 w",
+			rule:       model.RuleConfig{MoveLimit: 60},
+			moves:      repeat(perpetual, 3),
+			wantWinner: 1,
+			wantReason: ReasonPerpetual,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := positionFromFen(t, tt.fen)
+			for i, mv := range tt.moves {
+				if !p.PlayMove(mv) {
+					t.Fatalf("move %d %s is illegal", i, mv)
+				}
+				if i == len(tt.moves)-1 {
+					break
+				}
+				if _, reason := p.Judge(tt.rule); reason != ReasonNone {
+					t.Fatalf("game ended early after move %d %s: reason %d", i, mv, reason)
+				}
+			}
+			winner, reason := p.Judge(tt.rule)
+			if winner != tt.wantWinner || reason != tt.wantReason {
+				t.Errorf("Judge = (%d, %d), want (%d, %d)", winner, reason, tt.wantWinner, tt.wantReason)
+			}
+		})
+	}
+}
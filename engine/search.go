@@ -0,0 +1,487 @@
+package engine
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// 服务端AI的搜索，移植自客户端的引擎，去掉了开局库。
+// 每次搜索单独分配置换表和历史表，搜索时走的是对局的副本，可以在多个goroutine中同时搜索不同的对局。
+
+const (
+	//SearchHashSize 服务端每次搜索的置换表大小，比客户端小，避免同时搜索时占用太多内存
+	SearchHashSize = 1 << 16
+	//nodesPerCheck 每搜索多少个节点检查一次是否超时
+	nodesPerCheck = 1024
+	//BanqiMaxDepth 暗棋AI的最大搜索深度
+	BanqiMaxDepth = 3
+)
+
+type HashItem struct {
+	ucDepth int
+	ucFlag  int
+	svl     int
+	wmv     int
+	dwLock0 uint64
+	dwLock1 uint64
+}
+
+type Search struct {
+	mvResult      int
+	nHistoryTable [65536]int
+	mvKillers     [LimitDepth][2]int
+	hashTable     []HashItem
+	vlRandom      int32     //根节点的随机分值，越大走法越随意
+	tmDeadline    time.Time //到时后停止搜索
+	nNodes        int
+	bStop         bool
+}
+
+type SortStruct struct {
+	mvHash    int   //置换表走法
+	mvKiller1 int   //杀手走法
+	mvKiller2 int   //杀手走法
+	nPhase    int   //当前阶段
+	nIndex    int   //当前采用第几个走法
+	nGenMoves int   //总共有几个走法
+	mvs       []int //所有的走法
+}
+
+// SearchMove 搜索走子方的走法，nDepth为最大深度，vlRandom为随机分值，
+// 到deadline时返回最后一个完整深度的结果，没有合法走法时返回空
+func (p *PositionStruct) SearchMove(nDepth int, vlRandom int32, deadline time.Time) string {
+	if nDepth > LimitDepth {
+		nDepth = LimitDepth
+	}
+	p.search = &Search{
+		hashTable:  make([]HashItem, SearchHashSize),
+		vlRandom:   vlRandom,
+		tmDeadline: deadline,
+	}
+	defer func() {
+		p.search = nil
+	}()
+	mv := p.searchMain(nDepth)
+	if mv == 0 {
+		return ""
+	}
+	return p.MoveString(mv)
+}
+
+// timeout 定期检查是否到时，到时后整棵树都停止搜索
+func (p *PositionStruct) timeout() bool {
+	if p.search.bStop {
+		return true
+	}
+	p.search.nNodes++
+	if p.search.nNodes%nodesPerCheck == 0 && time.Now().After(p.search.tmDeadline) {
+		p.search.bStop = true
+	}
+	return p.search.bStop
+}
+
+func (p *PositionStruct) nullMove() {
+	dwKey := p.zobr.dwKey
+	p.changeSide()
+	p.mvsList[p.nMoveNum].set(0, 0, false, dwKey)
+	p.nMoveNum++
+	p.nDistance++
+}
+
+func (p *PositionStruct) undoNullMove() {
+	p.nDistance--
+	p.nMoveNum--
+	p.changeSide()
+}
+
+func (p *PositionStruct) nullOkay() bool {
+	if p.sdPlayer == 0 {
+		return p.vlRed > NullMargin
+	}
+	return p.vlBlack > NullMargin
+}
+
+// leafNode 到达最大深度或历史走法表已满时不再展开
+func (p *PositionStruct) leafNode() bool {
+	return p.nDistance == LimitDepth || p.nMoveNum >= MaxMoves-1
+}
+
+func (p *PositionStruct) probeHash(vlAlpha, vlBeta, nDepth int) (int, int) {
+	hsh := &p.search.hashTable[p.zobr.dwKey&(SearchHashSize-1)]
+	if hsh.dwLock0 != p.zobr.dwLock0 || hsh.dwLock1 != p.zobr.dwLock1 {
+		return -MateValue, 0
+	}
+	mv := hsh.wmv
+	vl := hsh.svl
+	bMate := false
+	if vl > WinValue {
+		if vl < BanValue {
+			//可能导致搜索的不稳定性，立刻退出，但最佳着法可能拿到
+			return -MateValue, mv
+		}
+		vl -= p.nDistance
+		bMate = true
+	} else if vl < -WinValue {
+		if vl > -BanValue {
+			//同上
+			return -MateValue, mv
+		}
+		vl += p.nDistance
+		bMate = true
+	}
+	if hsh.ucDepth >= nDepth || bMate {
+		if hsh.ucFlag == HashBeta {
+			if vl >= vlBeta {
+				return vl, mv
+			}
+			return -MateValue, mv
+		} else if hsh.ucFlag == HashAlpha {
+			if vl <= vlAlpha {
+				return vl, mv
+			}
+			return -MateValue, mv
+		}
+		return vl, mv
+	}
+	return -MateValue, mv
+}
+
+func (p *PositionStruct) recordHash(nFlag, vl, nDepth, mv int) {
+	hsh := &p.search.hashTable[p.zobr.dwKey&(SearchHashSize-1)]
+	if hsh.ucDepth > nDepth {
+		return
+	}
+	hsh.ucFlag = nFlag
+	hsh.ucDepth = nDepth
+	if vl > WinValue {
+		if mv == 0 && vl <= BanValue {
+			return
+		}
+		hsh.svl = vl + p.nDistance
+	} else if vl < -WinValue {
+		if mv == 0 && vl >= -BanValue {
+			return //同上
+		}
+		hsh.svl = vl - p.nDistance
+	} else {
+		hsh.svl = vl
+	}
+	hsh.wmv = mv
+	hsh.dwLock0 = p.zobr.dwLock0
+	hsh.dwLock1 = p.zobr.dwLock1
+}
+
+func (p *PositionStruct) mvvLva(mv int) int {
+	return (cucMvvLva[p.ucpcSquares[dst(mv)]] << 3) - cucMvvLva[p.ucpcSquares[src(mv)]]
+}
+
+// sortHistory 按历史表排序走法
+func (p *PositionStruct) sortHistory(mvs []int) {
+	sort.Slice(mvs, func(a, b int) bool {
+		return p.search.nHistoryTable[mvs[a]] > p.search.nHistoryTable[mvs[b]]
+	})
+}
+
+func (p *PositionStruct) initSort(mvHash int, s *SortStruct) {
+	s.mvHash = mvHash
+	s.mvKiller1 = p.search.mvKillers[p.nDistance][0]
+	s.mvKiller2 = p.search.mvKillers[p.nDistance][1]
+	s.nPhase = PhaseHash
+}
+
+func (p *PositionStruct) nextSort(s *SortStruct) int {
+	switch s.nPhase {
+	case PhaseHash:
+		s.nPhase = PhaseKiller1
+		if s.mvHash != 0 {
+			return s.mvHash
+		}
+		fallthrough
+	case PhaseKiller1:
+		s.nPhase = PhaseKiller2
+		if s.mvKiller1 != s.mvHash && s.mvKiller1 != 0 && p.legalMove(s.mvKiller1) {
+			return s.mvKiller1
+		}
+		fallthrough
+	case PhaseKiller2:
+		s.nPhase = PhaseGenMoves
+		if s.mvKiller2 != s.mvHash && s.mvKiller2 != 0 && p.legalMove(s.mvKiller2) {
+			return s.mvKiller2
+		}
+		fallthrough
+	case PhaseGenMoves:
+		s.nPhase = PhaseRest
+		s.nGenMoves = p.generateMoves(s.mvs, false)
+		s.mvs = s.mvs[:s.nGenMoves]
+		p.sortHistory(s.mvs)
+		s.nIndex = 0
+		fallthrough
+	case PhaseRest:
+		for s.nIndex < s.nGenMoves {
+			mv := s.mvs[s.nIndex]
+			s.nIndex++
+			if mv != s.mvHash && mv != s.mvKiller1 && mv != s.mvKiller2 {
+				return mv
+			}
+		}
+	}
+	return 0
+}
+
+func (p *PositionStruct) setBestMove(mv, nDepth int) {
+	p.search.nHistoryTable[mv] += nDepth * nDepth
+	if p.search.mvKillers[p.nDistance][0] != mv {
+		p.search.mvKillers[p.nDistance][1] = p.search.mvKillers[p.nDistance][0]
+		p.search.mvKillers[p.nDistance][0] = mv
+	}
+}
+
+func (p *PositionStruct) searchQuiesc(vlAlpha, vlBeta int) int {
+	if p.timeout() {
+		return 0
+	}
+	vl := p.repStatus(1)
+	if vl != 0 {
+		return p.repValue(vl)
+	}
+	if p.leafNode() {
+		return p.evaluate()
+	}
+
+	mvs := make([]int, MaxGenMoves)
+	nGenMoves := 0
+	vlBest := -MateValue
+	if p.inCheck() {
+		nGenMoves = p.generateMoves(mvs, false)
+		mvs = mvs[:nGenMoves]
+		p.sortHistory(mvs)
+	} else {
+		vl = p.evaluate()
+		if vl > vlBest {
+			vlBest = vl
+			if vl >= vlBeta {
+				return vl
+			}
+			if vl > vlAlpha {
+				vlAlpha = vl
+			}
+		}
+		nGenMoves = p.generateMoves(mvs, true)
+		mvs = mvs[:nGenMoves]
+		sort.Slice(mvs, func(a, b int) bool {
+			return p.mvvLva(mvs[a]) > p.mvvLva(mvs[b])
+		})
+	}
+
+	for i := 0; i < nGenMoves; i++ {
+		if p.makeMove(mvs[i]) {
+			vl = -p.searchQuiesc(-vlBeta, -vlAlpha)
+			p.undoMakeMove()
+			if vl > vlBest {
+				vlBest = vl
+				if vl >= vlBeta {
+					//Beta截断
+					return vl
+				}
+				if vl > vlAlpha {
+					vlAlpha = vl
+				}
+			}
+		}
+	}
+
+	if vlBest == -MateValue {
+		return p.nDistance - MateValue
+	}
+	return vlBest
+}
+
+func (p *PositionStruct) searchFull(vlAlpha, vlBeta, nDepth int, bNoNull bool) int {
+	if nDepth <= 0 {
+		return p.searchQuiesc(vlAlpha, vlBeta)
+	}
+	if p.timeout() {
+		return 0
+	}
+	vl := p.repStatus(1)
+	if vl != 0 {
+		return p.repValue(vl)
+	}
+	if p.leafNode() {
+		return p.evaluate()
+	}
+
+	vl, mvHash := p.probeHash(vlAlpha, vlBeta, nDepth)
+	if vl > -MateValue {
+		return vl
+	}
+
+	if !bNoNull && !p.inCheck() && p.nullOkay() {
+		p.nullMove()
+		vl = -p.searchFull(-vlBeta, 1-vlBeta, nDepth-NullDepth-1, true)
+		p.undoNullMove()
+		if vl >= vlBeta {
+			return vl
+		}
+	}
+
+	nHashFlag := HashAlpha
+	vlBest := -MateValue
+	mvBest := 0
+	nNewDepth := 0
+
+	tmpSort := &SortStruct{
+		mvs: make([]int, MaxGenMoves),
+	}
+	p.initSort(mvHash, tmpSort)
+
+	for mv := p.nextSort(tmpSort); mv != 0; mv = p.nextSort(tmpSort) {
+		if p.makeMove(mv) {
+			if p.inCheck() {
+				nNewDepth = nDepth
+			} else {
+				nNewDepth = nDepth - 1
+			}
+			if vlBest == -MateValue {
+				vl = -p.searchFull(-vlBeta, -vlAlpha, nNewDepth, false)
+			} else {
+				vl = -p.searchFull(-vlAlpha-1, -vlAlpha, nNewDepth, false)
+				if vl > vlAlpha && vl < vlBeta {
+					vl = -p.searchFull(-vlBeta, -vlAlpha, nNewDepth, false)
+				}
+			}
+			p.undoMakeMove()
+
+			if vl > vlBest {
+				vlBest = vl
+				if vl >= vlBeta {
+					nHashFlag = HashBeta
+					mvBest = mv
+					break
+				}
+				if vl > vlAlpha {
+					nHashFlag = HashPV
+					mvBest = mv
+					vlAlpha = vl
+				}
+			}
+		}
+	}
+
+	if p.search.bStop {
+		//超时的分值不可信，不写入置换表
+		return 0
+	}
+	if vlBest == -MateValue {
+		//如果是杀棋，就根据杀棋步数给出评价
+		return p.nDistance - MateValue
+	}
+	p.recordHash(nHashFlag, vlBest, nDepth, mvBest)
+	if mvBest != 0 {
+		p.setBestMove(mvBest, nDepth)
+	}
+	return vlBest
+}
+
+func (p *PositionStruct) searchRoot(nDepth int) int {
+	vl, nNewDepth := 0, 0
+	vlBest := -MateValue
+	tmpSort := &SortStruct{
+		mvs: make([]int, MaxGenMoves),
+	}
+	p.initSort(p.search.mvResult, tmpSort)
+	for mv := p.nextSort(tmpSort); mv != 0; mv = p.nextSort(tmpSort) {
+		if p.makeMove(mv) {
+			if p.inCheck() {
+				nNewDepth = nDepth
+			} else {
+				nNewDepth = nDepth - 1
+			}
+			if vlBest == -MateValue {
+				vl = -p.searchFull(-MateValue, MateValue, nNewDepth, true)
+			} else {
+				vl = -p.searchFull(-vlBest-1, -vlBest, nNewDepth, false)
+				if vl > vlBest {
+					vl = -p.searchFull(-MateValue, -vlBest, nNewDepth, true)
+				}
+			}
+			p.undoMakeMove()
+			if p.search.bStop {
+				return vlBest
+			}
+			if vl > vlBest {
+				vlBest = vl
+				p.search.mvResult = mv
+				if vlBest > -WinValue && vlBest < WinValue && p.search.vlRandom > 0 {
+					vlBest += int(rand.Int31()&p.search.vlRandom) - int(rand.Int31()&p.search.vlRandom)
+				}
+			}
+		}
+	}
+	p.recordHash(HashPV, vlBest, nDepth, p.search.mvResult)
+	p.setBestMove(p.search.mvResult, nDepth)
+	return vlBest
+}
+
+// searchMain 迭代加深搜索，超时后丢弃未完成的一层，返回0表示没有合法走法
+func (p *PositionStruct) searchMain(nDepth int) int {
+	p.nDistance = 0
+	mvBest, nLegal := 0, 0
+	mvs := make([]int, MaxGenMoves)
+	nGenMoves := p.generateMoves(mvs, false)
+	for i := 0; i < nGenMoves; i++ {
+		if p.makeMove(mvs[i]) {
+			p.undoMakeMove()
+			mvBest = mvs[i]
+			nLegal++
+		}
+	}
+	if nLegal <= 1 {
+		return mvBest
+	}
+
+	p.search.mvResult = mvBest
+	for i := 1; i <= nDepth; i++ {
+		vl := p.searchRoot(i)
+		if p.search.bStop {
+			break
+		}
+		mvBest = p.search.mvResult
+		if vl > WinValue || vl < -WinValue {
+			break
+		}
+		if time.Now().After(p.search.tmDeadline) {
+			break
+		}
+	}
+	return mvBest
+}
+
+// SearchMove 暗棋AI不看暗子，翻子按概率估值，搜索量随深度增长很快，
+// 逐层加深到nDepth，到deadline时返回最后一个完整深度的结果
+func (b *BanqiStruct) SearchMove(nDepth int, vlRandom int32, deadline time.Time) string {
+	if nDepth > BanqiMaxDepth {
+		nDepth = BanqiMaxDepth
+	}
+	mvs := b.generateMoves()
+	if len(mvs) == 0 {
+		return ""
+	}
+	b.search = &Search{tmDeadline: deadline}
+	defer func() {
+		b.search = nil
+	}()
+	mvBest := mvs[0]
+	for i := 1; i <= nDepth; i++ {
+		mv := b.searchBest(i)
+		if b.search.bStop {
+			break
+		}
+		mvBest = mv
+		if time.Now().After(deadline) {
+			break
+		}
+	}
+	return b.MoveString(mvBest)
+}
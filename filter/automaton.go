@@ -0,0 +1,103 @@
+package filter
+
+// pattern 词表里的一个写法，word为命中时报告的敏感词
+type pattern struct {
+	word  string
+	size  int  //归一化后的字符数
+	latin bool //全是字母和数字，要求前后不紧挨着字母或数字
+}
+
+type node struct {
+	next map[rune]int
+	fail int
+	dict int //沿失配链最近的一个有词结尾的节点，0表示没有
+	out  int //在这个节点结尾的写法，-1表示没有
+}
+
+// automaton Aho–Corasick自动机，一遍扫描找出所有写法的所有出现位置
+type automaton struct {
+	nodes    []node
+	patterns []pattern
+}
+
+func newAutomaton() *automaton {
+	return &automaton{nodes: []node{{next: make(map[rune]int), out: -1}}}
+}
+
+// add 加入一个写法，归一化后为空或重复的写法忽略
+func (a *automaton) add(spelling, word string) {
+	runes := fold([]rune(spelling)).runes
+	if len(runes) == 0 {
+		return
+	}
+	latin := true
+	s := 0
+	for _, r := range runes {
+		latin = latin && isLatin(r)
+		t, ok := a.nodes[s].next[r]
+		if !ok {
+			t = len(a.nodes)
+			a.nodes = append(a.nodes, node{next: make(map[rune]int), out: -1})
+			a.nodes[s].next[r] = t
+		}
+		s = t
+	}
+	if a.nodes[s].out >= 0 {
+		return
+	}
+	a.nodes[s].out = len(a.patterns)
+	a.patterns = append(a.patterns, pattern{word, len(runes), latin})
+}
+
+// build 所有写法加入后按层计算失配链
+func (a *automaton) build() {
+	queue := make([]int, 0, len(a.nodes))
+	for _, t := range a.nodes[0].next {
+		queue = append(queue, t)
+	}
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		for r, t := range a.nodes[s].next {
+			f := a.nodes[s].fail
+			for f != 0 && a.nodes[f].next[r] == 0 {
+				f = a.nodes[f].fail
+			}
+			if ft, ok := a.nodes[f].next[r]; ok && ft != t {
+				a.nodes[t].fail = ft
+			}
+			ft := a.nodes[t].fail
+			if a.nodes[ft].out >= 0 {
+				a.nodes[t].dict = ft
+			} else {
+				a.nodes[t].dict = a.nodes[ft].dict
+			}
+			queue = append(queue, t)
+		}
+	}
+}
+
+// match 一处命中，start、end为在归一化文本中的下标，end不含
+type match struct {
+	pattern int
+	start   int
+	end     int
+}
+
+// find 找出归一化文本中所有写法的出现位置
+func (a *automaton) find(runes []rune) []match {
+	var matches []match
+	s := 0
+	for i, r := range runes {
+		for s != 0 && a.nodes[s].next[r] == 0 {
+			s = a.nodes[s].fail
+		}
+		s = a.nodes[s].next[r]
+		for t := s; t != 0; t = a.nodes[t].dict {
+			if p := a.nodes[t].out; p >= 0 {
+				matches = append(matches, match{p, i + 1 - a.patterns[p].size, i + 1})
+			}
+		}
+	}
+	return matches
+}
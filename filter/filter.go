@@ -0,0 +1,137 @@
+// Package filter 聊天敏感词过滤，词表来自配置文件和词表文件，配置文件修改后重新加载
+package filter
+
+import (
+	"bufio"
+	"errors"
+	"go-chess/model"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// 命中敏感词后的处理方式
+const (
+	ModeMask  = "mask"  //敏感词替换成*后照常发送
+	ModeBlock = "block" //拦下整条消息
+)
+
+const maskRune = '*'
+
+// Filter 由一份词表建好的过滤器，建好后只读，可以并发使用
+type Filter struct {
+	mode string
+	ac   *automaton
+}
+
+// Result 一条消息的检查结果
+type Result struct {
+	Text    string   //把敏感词替换成*后的文本
+	Words   []string //命中的敏感词，没有命中时为空
+	Blocked bool     //block模式下命中了敏感词，整条消息不能发送
+}
+
+// New 按配置建过滤器，words、词表文件和aliases合并，aliases的键为拼音、谐音等变体写法，值为对应的敏感词
+func New(cfg model.FilterConfig) (*Filter, error) {
+	mode := cfg.Mode
+	if mode == "" {
+		mode = ModeBlock
+	}
+	if mode != ModeMask && mode != ModeBlock {
+		return nil, errors.New("filter mode must be mask or block")
+	}
+	ac := newAutomaton()
+	for _, w := range cfg.Words {
+		ac.add(w, w)
+	}
+	for spelling, w := range cfg.Aliases {
+		ac.add(spelling, w)
+	}
+	if cfg.File != "" {
+		if err := loadFile(ac, cfg.File); err != nil {
+			return nil, err
+		}
+	}
+	ac.build()
+	return &Filter{mode, ac}, nil
+}
+
+// loadFile 词表文件每行一个词，#开头为注释，"变体写法=敏感词"为别名
+func loadFile(ac *automaton, name string) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if i := strings.Index(line, "="); i > 0 {
+			ac.add(strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:]))
+		} else {
+			ac.add(line, line)
+		}
+	}
+	return scanner.Err()
+}
+
+// Check 检查一条消息，拼音和缩写写法只在前后不紧挨着字母或数字时算命中
+func (f *Filter) Check(text string) Result {
+	runes := []rune(text)
+	folded := fold(runes)
+	out := append([]rune(nil), runes...)
+	var words []string
+	for _, m := range f.ac.find(folded.runes) {
+		p := f.ac.patterns[m.pattern]
+		start, end := folded.pos[m.start], folded.pos[m.end-1]
+		if p.latin && (start > 0 && isLatin(runes[start-1]) || end+1 < len(runes) && isLatin(runes[end+1])) {
+			continue
+		}
+		if !containsString(words, p.word) {
+			words = append(words, p.word)
+		}
+		for i := start; i <= end; i++ {
+			out[i] = maskRune
+		}
+	}
+	if len(words) == 0 {
+		return Result{Text: text}
+	}
+	return Result{Text: string(out), Words: words, Blocked: f.mode == ModeBlock}
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// current 正在使用的过滤器，重新加载时整体替换，检查消息时不用加锁
+var current atomic.Value
+
+// Load 按配置重建过滤器并替换正在使用的，出错时保留原来的
+func Load(cfg model.FilterConfig) error {
+	f, err := New(cfg)
+	if err != nil {
+		return err
+	}
+	current.Store(f)
+	log.Printf("filter loaded, mode:%s words:%d\n", f.mode, len(f.ac.patterns))
+	return nil
+}
+
+// Check 用正在使用的过滤器检查消息，还没有加载时不过滤
+func Check(text string) Result {
+	f, _ := current.Load().(*Filter)
+	if f == nil {
+		return Result{Text: text}
+	}
+	return f.Check(text)
+}
@@ -0,0 +1,125 @@
+package filter
+
+import (
+	"go-chess/model"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var testConfig = model.FilterConfig{
+	Mode:    ModeMask,
+	Words:   []string{"傻逼", "操你妈", "他妈的"},
+	Aliases: map[string]string{"sb": "傻逼", "shabi": "傻逼", "tmd": "他妈的"},
+}
+
+func TestCheckMask(t *testing.T) {
+	f, err := New(testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		text  string
+		want  string
+		words []string
+	}{
+		{"clean", "你好，下一盘吗", "你好，下一盘吗", nil},
+		{"plain word", "你是傻逼", "你是**", []string{"傻逼"}},
+		{"variant and space", "儍 逼", "***", []string{"傻逼"}},
+		{"punctuation inside", "傻.逼!", "***!", []string{"傻逼"}},
+		{"invisible rune inside", "傻\u200b逼", "***", []string{"傻逼"}},
+		{"fullwidth pinyin", "ＳＨＡＢＩ", "*****", []string{"傻逼"}},
+		{"upper case alias", "You SB", "You **", []string{"傻逼"}},
+		{"dotted abbreviation", "s.b", "***", []string{"傻逼"}},
+		{"alias inside a latin word", "usb接口坏了", "usb接口坏了", nil},
+		{"alias next to digits", "sb2", "sb2", nil},
+		{"alias next to chinese", "你sb吧", "你**吧", []string{"傻逼"}},
+		{"several words", "tmd傻逼操你妈", "********", []string{"他妈的", "傻逼", "操你妈"}},
+		{"repeated word reported once", "傻逼傻逼", "****", []string{"傻逼"}},
+		{"word before other text", "他妈的好棋", "***好棋", []string{"他妈的"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := f.Check(tt.text)
+			if got.Text != tt.want {
+				t.Errorf("Text = %q, want %q", got.Text, tt.want)
+			}
+			if !reflect.DeepEqual(got.Words, tt.words) {
+				t.Errorf("Words = %v, want %v", got.Words, tt.words)
+			}
+			if got.Blocked {
+				t.Error("mask mode blocked the message")
+			}
+		})
+	}
+}
+
+func TestCheckBlock(t *testing.T) {
+	cfg := testConfig
+	cfg.Mode = ModeBlock
+	f, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := f.Check("你是傻逼"); !got.Blocked || !reflect.DeepEqual(got.Words, []string{"傻逼"}) {
+		t.Errorf("Check = %+v, want blocked", got)
+	}
+	if got := f.Check("好棋"); got.Blocked || got.Text != "好棋" {
+		t.Errorf("Check = %+v, want passed", got)
+	}
+}
+
+func TestNew(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "words.txt")
+	content := "# 注释\n\n滚蛋\ngundan = 滚蛋\n"
+	if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		cfg     model.FilterConfig
+		text    string
+		words   []string
+		wantErr bool
+	}{
+		{"default mode is block", model.FilterConfig{Words: []string{"滚蛋"}}, "滚蛋", []string{"滚蛋"}, false},
+		{"unknown mode", model.FilterConfig{Mode: "drop"}, "", nil, true},
+		{"missing file", model.FilterConfig{File: filepath.Join(dir, "none.txt")}, "", nil, true},
+		{"file word", model.FilterConfig{File: file}, "你滚蛋", []string{"滚蛋"}, false},
+		{"file alias", model.FilterConfig{File: file}, "gundan", []string{"滚蛋"}, false},
+		{"empty word ignored", model.FilterConfig{Words: []string{" ", "。"}}, "。 。", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := New(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := f.Check(tt.text); !reflect.DeepEqual(got.Words, tt.words) {
+				t.Errorf("Words = %v, want %v", got.Words, tt.words)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	if err := Load(testConfig); err != nil {
+		t.Fatal(err)
+	}
+	if got := Check("傻逼"); got.Text != "**" {
+		t.Errorf("Check = %+v, want masked", got)
+	}
+	//配置出错时保留原来的过滤器
+	if err := Load(model.FilterConfig{Mode: "drop"}); err == nil {
+		t.Fatal("Load accepted an unknown mode")
+	}
+	if got := Check("傻逼"); got.Text != "**" {
+		t.Errorf("Check after failed reload = %+v, want masked", got)
+	}
+}
@@ -0,0 +1,56 @@
+package filter
+
+import "unicode"
+
+// variants 常见的繁体和异体写法，匹配前统一成简体
+var variants = map[rune]rune{
+	'儍': '傻', '媽': '妈', '妳': '你', '屍': '尸', '幹': '干', '肏': '操', '艹': '操',
+	'殺': '杀', '腦': '脑', '殘': '残', '豬': '猪', '滾': '滚', '賤': '贱', '嗎': '吗',
+	'爺': '爷', '雞': '鸡', '屄': '逼', '筆': '笔', '癡': '痴',
+}
+
+// folded 归一化后的文本，pos[i]为第i个字符在原文中的下标
+type folded struct {
+	runes []rune
+	pos   []int
+}
+
+// fold 全角转半角、字母转小写、繁体和异体转简体，并去掉夹在字中间的空格、标点和不可见字符
+func fold(text []rune) folded {
+	f := folded{
+		runes: make([]rune, 0, len(text)),
+		pos:   make([]int, 0, len(text)),
+	}
+	for i, r := range text {
+		r, ok := foldRune(r)
+		if !ok {
+			continue
+		}
+		f.runes = append(f.runes, r)
+		f.pos = append(f.pos, i)
+	}
+	return f
+}
+
+// foldRune 归一化一个字符，第二个返回值为false表示这个字符是干扰字符，匹配时跳过
+func foldRune(r rune) (rune, bool) {
+	switch {
+	case r == '　':
+		return ' ', false
+	case r >= '！' && r <= '～':
+		r -= 0xfee0
+	}
+	if unicode.IsSpace(r) || unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.Is(unicode.Cf, r) {
+		return r, false
+	}
+	if v, ok := variants[r]; ok {
+		return v, true
+	}
+	return unicode.ToLower(r), true
+}
+
+// isLatin 半角字母和数字，拼音和缩写写法的词要求前后不能紧挨着字母或数字
+func isLatin(r rune) bool {
+	r, _ = foldRune(r)
+	return r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r))
+}
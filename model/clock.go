@@ -0,0 +1,17 @@
+package model
+
+// 计时方式
+const (
+	ClockSudden  = "sudden"  //包干，用完基本时间判负
+	ClockFischer = "fischer" //每走一步加秒
+	ClockJiamiao = "jiamiao" //加秒，基本时间用完后每步限时increment秒
+	ClockByoyomi = "byoyomi" //读秒，基本时间用完后有periods次increment秒的读秒
+)
+
+// TimeControl 对局用时设置，时间单位为秒
+type TimeControl struct {
+	Mode      string `mapstructure:"mode" json:"mode"`
+	Base      int    `mapstructure:"base" json:"base"`           //基本用时
+	Increment int    `mapstructure:"increment" json:"increment"` //每步加秒、步时或每次读秒的时长
+	Periods   int    `mapstructure:"periods" json:"periods"`     //读秒次数
+}
@@ -1,11 +1,18 @@
 package model
 
 type ServerConfig struct {
-	Name      string      `mapstructure:"name"`
-	Port      int         `mapstructure:"port"`
-	GormInfo  GormConfig  `mapstructure:"gorm"`
-	RedisInfo RedisConfig `mapstructure:"redis"`
-	EtcdInfo  EtcdConfig  `mapstructure:"etcd"`
+	Name           string           `mapstructure:"name"`
+	Port           int              `mapstructure:"port"`
+	GormInfo       GormConfig       `mapstructure:"gorm"`
+	RedisInfo      RedisConfig      `mapstructure:"redis"`
+	EtcdInfo       EtcdConfig       `mapstructure:"etcd"`
+	RuleInfo       RuleConfig       `mapstructure:"rule"`
+	ClockInfo      TimeControl      `mapstructure:"clock"`
+	RoomInfo       RoomConfig       `mapstructure:"room"`
+	BotInfo        BotConfig        `mapstructure:"bot"`
+	BrokerInfo     BrokerConfig     `mapstructure:"broker"`
+	FilterInfo     FilterConfig     `mapstructure:"filter"`
+	ModerationInfo ModerationConfig `mapstructure:"moderation"`
 }
 
 type GormConfig struct {
@@ -26,3 +33,39 @@ type RedisConfig struct {
 type EtcdConfig struct {
 	Addr string `mapstructure:"addr"`
 }
+
+type RuleConfig struct {
+	MoveLimit    int  `mapstructure:"moveLimit"`    //自然限着，每方多少回合未吃子判和
+	Insufficient bool `mapstructure:"insufficient"` //双方均无进攻子力时判和
+}
+
+type RoomConfig struct {
+	Grace        int    `mapstructure:"grace"`        //对局中断线后保留座位的秒数
+	InviteUrl    string `mapstructure:"inviteUrl"`    //邀请链接的地址，后面拼上房间号和邀请码
+	InviteSecret string `mapstructure:"inviteSecret"` //邀请码的签名密钥，不能写进仓库，也可以用环境变量CHESS_INVITE_SECRET设置
+}
+
+type BotConfig struct {
+	Workers int `mapstructure:"workers"` //同时搜索的AI数量，超出的排队等待
+}
+
+type BrokerConfig struct {
+	Type string `mapstructure:"type"` //redis为redis发布订阅，可多实例部署；memory为进程内，只能单实例
+	Node string `mapstructure:"node"` //实例编号，为空时启动时随机生成
+}
+
+type FilterConfig struct {
+	Mode    string            `mapstructure:"mode"`    //mask把敏感词替换成*后照常发送，block拦下整条消息并警告
+	Words   []string          `mapstructure:"words"`   //敏感词
+	File    string            `mapstructure:"file"`    //词表文件，每行一个词，和words合并
+	Aliases map[string]string `mapstructure:"aliases"` //拼音、谐音等变体写法，值为对应的敏感词
+}
+
+type ModerationConfig struct {
+	StrikeWindow int      `mapstructure:"strikeWindow"` //违规次数的统计周期(秒)，从最后一次违规算起
+	Mute         int      `mapstructure:"mute"`         //每次违规禁言的秒数
+	KickStrikes  int      `mapstructure:"kickStrikes"`  //违规达到这个次数时踢出房间
+	BanStrikes   int      `mapstructure:"banStrikes"`   //违规达到这个次数时禁止聊天
+	Ban          int      `mapstructure:"ban"`          //禁止聊天的秒数
+	Admins       []string `mapstructure:"admins"`       //可以查看和解除处罚的管理员uuid
+}
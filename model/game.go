@@ -0,0 +1,52 @@
+package model
+
+import "time"
+
+// ResultPlaying 对局还在进行，结束后result为0红胜、1黑胜、2和棋
+const ResultPlaying = -1
+
+type Game struct {
+	Id             int64
+	RoomId         string
+	RedUuid        string
+	BlackUuid      string
+	Variant        int
+	Handicap       int    //让子预设，揭棋、暗棋和自定义局面为0
+	Removed        string //额外去掉的棋子，ICCS坐标，逗号分隔
+	ClockMode      string
+	ClockBase      int
+	ClockIncrement int
+	ClockPeriods   int
+	StartFen       string
+	Seed           int64 //揭棋、暗棋的暗子分布
+	Result         int
+	Reason         int
+	MoveCount      int
+	StartedAt      time.Time
+	EndedAt        *time.Time
+}
+
+type GameMove struct {
+	Id        int64
+	GameId    int64
+	Ply       int //第几步，从1开始
+	Side      int
+	Move      string
+	Fen       string //走棋后的局面
+	Hash      string //走棋后局面的zobrist校验码，16位十六进制，用于按局面检索
+	ClockMs   int64  //走棋方走棋后剩余的时间
+	CreatedAt time.Time
+}
+
+// GameQuery 对局列表的筛选条件，字符串为空、时间为零值时不筛选
+type GameQuery struct {
+	Uuid         string
+	Opponent     string //对手的uuid
+	Result       string //win、loss、draw，相对Uuid而言
+	Color        string //red、black
+	From         time.Time
+	To           time.Time
+	FinishedOnly bool
+	Page         int
+	Size         int
+}
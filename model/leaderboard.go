@@ -0,0 +1,8 @@
+package model
+
+// RankEntry 排行榜中的一名用户，Rank从1开始
+type RankEntry struct {
+	Rank  int64
+	Uuid  string
+	Score float64 //当前等级分，或周期内等级分的变化
+}
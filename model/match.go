@@ -0,0 +1,29 @@
+package model
+
+// MatchTicket 匹配队列中的一名用户，存在redis中供所有api实例共享
+type MatchTicket struct {
+	Uuid        string      `json:"uuid"`
+	Name        string      `json:"name"`
+	TimeControl TimeControl `json:"time_control"`
+	Rated       bool        `json:"rated"`
+	Rating      float64     `json:"rating"`    //该用时类别下的等级分，决定匹配范围
+	JoinedAt    int64       `json:"joined_at"` //加入队列的时间(毫秒)
+	SeenAt      int64       `json:"seen_at"`   //持有连接的实例最近一次续期的时间(毫秒)，过期的票据在撮合时清理
+}
+
+// Match 匹配成功的结果，通过redis发布给等待中的双方
+type Match struct {
+	RoomId      string      `json:"room_id"`
+	Uuids       [2]string   `json:"uuids"`
+	Names       [2]string   `json:"names"`
+	Ratings     [2]float64  `json:"ratings"`
+	TimeControl TimeControl `json:"time_control"`
+	Rated       bool        `json:"rated"`
+}
+
+// PendingMatch 已建好房间、还在等双方进入的匹配，超时后释放座位
+type PendingMatch struct {
+	RoomId   string         `json:"room_id"`
+	Tickets  [2]MatchTicket `json:"tickets"`
+	Deadline int64          `json:"deadline"` //双方都要在这之前进入房间(毫秒)
+}
@@ -0,0 +1,10 @@
+package model
+
+// Penalty 用户的违规记录和处罚，保存在redis中，重连后仍然有效
+type Penalty struct {
+	Uuid     string `json:"uuid"`
+	Name     string `json:"name"`
+	Strikes  int64  `json:"strikes"`   //统计周期内的违规次数
+	MuteLeft int64  `json:"mute_left"` //剩余的禁言秒数
+	BanLeft  int64  `json:"ban_left"`  //剩余的禁止聊天秒数
+}
@@ -0,0 +1,26 @@
+package model
+
+import "time"
+
+type Rating struct {
+	Id         int64
+	Uuid       string
+	Category   string //bullet、blitz、rapid、classical
+	Rating     float64
+	Deviation  float64
+	Volatility float64
+	Games      int
+	UpdatedAt  time.Time
+}
+
+type RatingHistory struct {
+	Id         int64
+	Uuid       string
+	Category   string
+	GameId     int64
+	Rating     float64 //这局结束后的等级分
+	Deviation  float64
+	Volatility float64
+	Delta      float64 //等级分变化
+	CreatedAt  time.Time
+}
@@ -0,0 +1,28 @@
+package model
+
+import "github.com/dgrijalva/jwt-go"
+
+const (
+	RoomWaiting = "waiting" //等待入座或准备
+	RoomFull    = "full"    //座位已满，尚未开局
+	RoomPlaying = "playing" //对局进行中
+)
+
+// RoomMeta 大厅创建的房间信息，存在redis哈希meta_<id>中，和room_<id>一起在房间空置后清理
+type RoomMeta struct {
+	Owner     string //创建者uuid
+	Private   bool   //私有房间不在大厅中列出，需要密码或邀请码才能进入
+	Password  string //密码的哈希，为空时不需要密码
+	CreatedAt int64  //创建时间(毫秒)
+	Playing   bool   //是否有进行中的对局，由房间在开局和终局时更新
+	Locked    bool   //锁定后新用户不能进入，房主和已占座的用户不受影响
+	Matched   bool   //匹配创建的房间，用时和计分按匹配时的设置，不能修改
+}
+
+// InviteClaims 房间邀请码，Created为房间的创建时间，房间清理后重新创建的同号房间不认旧邀请码
+type InviteClaims struct {
+	RoomId  string `json:"room_id"`
+	Created int64  `json:"created"`
+	Invitee string `json:"invitee,omitempty"` //指定被邀请用户的uuid，为空时任何人可用
+	jwt.StandardClaims
+}
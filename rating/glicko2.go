@@ -0,0 +1,125 @@
+// Package rating Glicko-2等级分，每局棋结束后作为一个评分周期更新双方的等级分
+package rating
+
+import (
+	"go-chess/model"
+	"math"
+)
+
+const (
+	scale        = 173.7178 //Glicko与Glicko-2之间的换算系数
+	tau          = 0.5      //波动率的变化幅度
+	epsilon      = 0.000001 //求解波动率的精度
+	maxDeviation = 350      //新用户的评分偏差，也是上限
+	minDeviation = 30       //评分偏差的下限，避免等级分被锁死
+
+	DefaultRating     = 1500
+	DefaultDeviation  = maxDeviation
+	DefaultVolatility = 0.06
+)
+
+// 按用时划分的等级分类别
+const (
+	CategoryBullet    = "bullet"
+	CategoryBlitz     = "blitz"
+	CategoryRapid     = "rapid"
+	CategoryClassical = "classical"
+)
+
+// Glicko2 一名用户在某个类别下的等级分
+type Glicko2 struct {
+	Rating     float64
+	Deviation  float64
+	Volatility float64
+}
+
+// Default 新用户的等级分
+func Default() Glicko2 {
+	return Glicko2{DefaultRating, DefaultDeviation, DefaultVolatility}
+}
+
+// Category 按预计对局时长(基本用时加40步的加秒)划分类别
+func Category(tc model.TimeControl) string {
+	estimate := tc.Base + 40*tc.Increment
+	if tc.Mode == model.ClockByoyomi {
+		estimate = tc.Base + tc.Periods*tc.Increment + 40*tc.Increment/2
+	}
+	switch {
+	case estimate < 180:
+		return CategoryBullet
+	case estimate < 480:
+		return CategoryBlitz
+	case estimate < 1500:
+		return CategoryRapid
+	}
+	return CategoryClassical
+}
+
+func g(phi float64) float64 {
+	return 1 / math.Sqrt(1+3*phi*phi/(math.Pi*math.Pi))
+}
+
+// Update 对局结束后更新等级分，score为1胜、0.5和、0负
+func Update(player, opponent Glicko2, score float64) Glicko2 {
+	return updatePeriod(player, []Glicko2{opponent}, []float64{score})
+}
+
+// updatePeriod 按Glicko-2论文的步骤计算一个评分周期内多局对局后的等级分，scores与opponents一一对应
+func updatePeriod(player Glicko2, opponents []Glicko2, scores []float64) Glicko2 {
+	mu := (player.Rating - DefaultRating) / scale
+	phi := player.Deviation / scale
+
+	var vInv, sum float64
+	for i, opponent := range opponents {
+		muJ := (opponent.Rating - DefaultRating) / scale
+		phiJ := opponent.Deviation / scale
+		gJ := g(phiJ)
+		e := 1 / (1 + math.Exp(-gJ*(mu-muJ)))
+		vInv += gJ * gJ * e * (1 - e)
+		sum += gJ * (scores[i] - e)
+	}
+	v := 1 / vInv
+	delta := v * sum
+
+	sigma := volatility(phi, v, delta, player.Volatility)
+	phiStar := math.Sqrt(phi*phi + sigma*sigma)
+	phiNew := 1 / math.Sqrt(1/(phiStar*phiStar)+1/v)
+	muNew := mu + phiNew*phiNew*sum
+
+	deviation := math.Max(minDeviation, math.Min(maxDeviation, phiNew*scale))
+	return Glicko2{muNew*scale + DefaultRating, deviation, sigma}
+}
+
+// volatility 用Illinois算法求解新的波动率
+func volatility(phi, v, delta, sigma float64) float64 {
+	a := math.Log(sigma * sigma)
+	f := func(x float64) float64 {
+		ex := math.Exp(x)
+		d := phi*phi + v + ex
+		return ex*(delta*delta-phi*phi-v-ex)/(2*d*d) - (x-a)/(tau*tau)
+	}
+
+	A := a
+	var B float64
+	if delta*delta > phi*phi+v {
+		B = math.Log(delta*delta - phi*phi - v)
+	} else {
+		k := 1.0
+		for f(a-k*tau) < 0 {
+			k++
+		}
+		B = a - k*tau
+	}
+	fA, fB := f(A), f(B)
+	for math.Abs(B-A) > epsilon {
+		C := A + (A-B)*fA/(fB-fA)
+		fC := f(C)
+		if fC*fB <= 0 {
+			A, fA = B, fB
+		} else {
+			fA /= 2
+		}
+		B, fB = C, fC
+	}
+	return math.Exp(A / 2)
+}
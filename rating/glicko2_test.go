@@ -0,0 +1,84 @@
+package rating
+
+import (
+	"go-chess/model"
+	"math"
+	"testing"
+)
+
+func near(a, b, tolerance float64) bool {
+	return math.Abs(a-b) <= tolerance
+}
+
+// TestUpdatePeriodPaperExample Glickman《Example of the Glicko-2 system》中的算例
+func TestUpdatePeriodPaperExample(t *testing.T) {
+	player := Glicko2{1500, 200, 0.06}
+	opponents := []Glicko2{
+		{1400, 30, 0.06},
+		{1550, 100, 0.06},
+		{1700, 300, 0.06},
+	}
+	got := updatePeriod(player, opponents, []float64{1, 0, 0})
+	if !near(got.Rating, 1464.06, 0.01) {
+		t.Errorf("rating = %.4f, want 1464.06", got.Rating)
+	}
+	if !near(got.Deviation, 151.52, 0.01) {
+		t.Errorf("deviation = %.4f, want 151.52", got.Deviation)
+	}
+	if !near(got.Volatility, 0.05999, 0.00001) {
+		t.Errorf("volatility = %.6f, want 0.05999", got.Volatility)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	tests := []struct {
+		name     string
+		player   Glicko2
+		opponent Glicko2
+		score    float64
+		gain     bool //等级分是否上涨
+	}{
+		{"win against equal", Default(), Default(), 1, true},
+		{"loss against equal", Default(), Default(), 0, false},
+		{"draw against stronger", Glicko2{1500, 80, 0.06}, Glicko2{1800, 80, 0.06}, 0.5, true},
+		{"draw against weaker", Glicko2{1800, 80, 0.06}, Glicko2{1500, 80, 0.06}, 0.5, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Update(tt.player, tt.opponent, tt.score)
+			if (got.Rating > tt.player.Rating) != tt.gain {
+				t.Errorf("rating %.2f -> %.2f, want gain %v", tt.player.Rating, got.Rating, tt.gain)
+			}
+			if got.Deviation < minDeviation || got.Deviation > maxDeviation {
+				t.Errorf("deviation %.2f out of range", got.Deviation)
+			}
+			if got.Deviation >= tt.player.Deviation && tt.player.Deviation < maxDeviation {
+				t.Errorf("deviation %.2f did not shrink from %.2f", got.Deviation, tt.player.Deviation)
+			}
+		})
+	}
+
+	//同样等级分和偏差的双方，胜方涨的分和负方掉的分相同
+	winner := Update(Default(), Default(), 1)
+	loser := Update(Default(), Default(), 0)
+	if !near(winner.Rating-DefaultRating, DefaultRating-loser.Rating, 1e-9) {
+		t.Errorf("asymmetric update: %.4f vs %.4f", winner.Rating, loser.Rating)
+	}
+}
+
+func TestCategory(t *testing.T) {
+	tests := []struct {
+		tc   model.TimeControl
+		want string
+	}{
+		{model.TimeControl{Mode: model.ClockSudden, Base: 60}, CategoryBullet},
+		{model.TimeControl{Mode: model.ClockFischer, Base: 180, Increment: 2}, CategoryBlitz},
+		{model.TimeControl{Mode: model.ClockFischer, Base: 600, Increment: 5}, CategoryRapid},
+		{model.TimeControl{Mode: model.ClockByoyomi, Base: 1800, Increment: 30, Periods: 3}, CategoryClassical},
+	}
+	for _, tt := range tests {
+		if got := Category(tt.tc); got != tt.want {
+			t.Errorf("Category(%+v) = %s, want %s", tt.tc, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package rating
+
+import "time"
+
+// 排行榜的统计周期
+const (
+	PeriodAll   = "all"   //按当前等级分排名
+	PeriodWeek  = "week"  //按本周等级分的变化排名
+	PeriodMonth = "month" //按本月等级分的变化排名
+)
+
+// Categories 所有等级分类别
+var Categories = []string{CategoryBullet, CategoryBlitz, CategoryRapid, CategoryClassical}
+
+// IsCategory 是否为合法的类别
+func IsCategory(category string) bool {
+	for _, c := range Categories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// PeriodStart 统计周期的开始时间，每周从周一开始，PeriodAll返回零值
+func PeriodStart(period string, now time.Time) time.Time {
+	y, m, d := now.Date()
+	switch period {
+	case PeriodWeek:
+		offset := (int(now.Weekday()) + 6) % 7
+		return time.Date(y, m, d-offset, 0, 0, 0, 0, now.Location())
+	case PeriodMonth:
+		return time.Date(y, m, 1, 0, 0, 0, 0, now.Location())
+	}
+	return time.Time{}
+}
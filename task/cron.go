@@ -9,6 +9,13 @@ import (
 func CronInit() {
 	c := cron.New()
 	c.Start()
+	//启动时先重建一次排行榜，redis重启后不用等到第二天
+	go func() {
+		err := RebuildLeaderboards()
+		if err != nil {
+			log.Println("rebuild leaderboards err", err)
+		}
+	}()
 	_, err := c.AddFunc("@every 1h", func() {
 		err := redis.DeleteEmptyRoom()
 		if err != nil {
@@ -20,4 +27,15 @@ func CronInit() {
 		log.Println("cron err", err)
 		return
 	}
+	_, err = c.AddFunc("@daily", func() {
+		err := RebuildLeaderboards()
+		if err != nil {
+			log.Println("cron err", err)
+			return
+		}
+	})
+	if err != nil {
+		log.Println("cron err", err)
+		return
+	}
 }
@@ -0,0 +1,40 @@
+package task
+
+import (
+	"go-chess/dao/mysql"
+	"go-chess/dao/redis"
+	"go-chess/model"
+	"go-chess/rating"
+	"time"
+)
+
+// RebuildLeaderboards 从MySQL重建各类别的总榜和本周、本月榜，修正redis丢失或与数据库不一致的数据
+func RebuildLeaderboards() error {
+	now := time.Now()
+	for _, category := range rating.Categories {
+		ratings, err := mysql.SelectCategoryRatings(category)
+		if err != nil {
+			return err
+		}
+		entries := make([]model.RankEntry, 0, len(ratings))
+		for _, r := range ratings {
+			entries = append(entries, model.RankEntry{Uuid: r.Uuid, Score: r.Rating})
+		}
+		err = redis.RebuildLeaderboard(redis.LeaderboardKey(category, rating.PeriodAll, now), entries, 0)
+		if err != nil {
+			return err
+		}
+
+		for _, period := range []string{rating.PeriodWeek, rating.PeriodMonth} {
+			entries, err := mysql.SumRatingDeltas(category, rating.PeriodStart(period, now))
+			if err != nil {
+				return err
+			}
+			err = redis.RebuildLeaderboard(redis.LeaderboardKey(category, period, now), entries, redis.PeriodTTL(period))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}